@@ -3,6 +3,7 @@ package agent
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -16,8 +17,8 @@ import (
 	"go.uber.org/multierr"
 	"golang.org/x/sys/windows"
 
-	"github.com/rupor-github/win-gpg-agent/assuan/client"
 	"github.com/rupor-github/win-gpg-agent/config"
+	"github.com/rupor-github/win-gpg-agent/gpgagent"
 	"github.com/rupor-github/win-gpg-agent/util"
 )
 
@@ -32,8 +33,13 @@ type Agent struct {
 	ctx       context.Context
 	wg        sync.WaitGroup
 	conns     []*Connector
+	pool      *gpgagent.Pool
 }
 
+// maxAssuanSessions limits number of parallel sessions to gpg-agent used by
+// agent-gui itself.
+const maxAssuanSessions = 4
+
 // NewAgent initializes Agent structure.
 func NewAgent(cfg *config.Config) (*Agent, error) {
 
@@ -139,23 +145,13 @@ func (a *Agent) forceCleanup() error {
 	return nil
 }
 
-func sendAssuanCmd(sockPath string, transact func(*client.Session) error) error {
-	conn, err := client.Dial(sockPath)
-	if err != nil {
-		return fmt.Errorf("unable to dial assuan socket \"%s\": %w", sockPath, err)
-	}
-	defer conn.Close()
-
-	ses, err := client.Init(conn)
-	if err != nil {
-		return fmt.Errorf("unable to init assuan session on \"%s\": %w", sockPath, err)
-	}
-	defer ses.Close()
-
-	if err := transact(ses); err != nil {
-		return err
+// Pool returns sessions to running gpg-agent, which could be used in
+// parallel. It is nil until agent is started.
+func (a *Agent) Pool() *gpgagent.Pool {
+	if a == nil {
+		return nil
 	}
-	return nil
+	return a.pool
 }
 
 // Start executes gpg-agent using configuration values.
@@ -203,9 +199,10 @@ func (a *Agent) Start() error {
 		)
 	}
 
-	if err := sendAssuanCmd(sockPath,
-		func(ses *client.Session) error {
-			if err := ses.Reset(); err != nil {
+	a.pool = gpgagent.NewPool("assuan-file:"+sockPath, maxAssuanSessions)
+	if err := a.pool.Do(a.ctx,
+		func(c *gpgagent.Client) error {
+			if err := c.Reset(a.ctx); err != nil {
 				return fmt.Errorf("unable to RESET assuan session on \"%s\": %w", sockPath, err)
 			}
 			return nil
@@ -270,10 +267,18 @@ func (a *Agent) Stop() error {
 	a.cancel()
 
 	// tell gpg-agent to exit
+	if a.pool == nil {
+		// Start failed before gpg-agent could be reached.
+		if err := a.forceCleanup(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			return err
+		}
+		return nil
+	}
 	sockPath := a.conns[ConnectorSockAgent].PathGPG()
-	if err := sendAssuanCmd(sockPath,
-		func(ses *client.Session) error {
-			if _, err := ses.SimpleCmd("KILLAGENT", ""); err != nil {
+	defer a.pool.Close()
+	if err := a.pool.Do(context.Background(),
+		func(c *gpgagent.Client) error {
+			if err := c.KillAgent(context.Background()); err != nil {
 				return fmt.Errorf("unable to send KILLAGENT on \"%s\": %w", sockPath, err)
 			}
 			return nil
@@ -167,9 +167,10 @@ func (c *Connector) handleAssuanRequest(socketName string, conn net.Conn, deadli
 	log.Printf("[%d] Accepted request from %s", id, socketName)
 
 	socketNameAssuan := c.PathGPG()
-	connAssuan, err := client.Dial(socketNameAssuan)
+	connAssuan, err := client.Dial("assuan-file:" + socketNameAssuan)
 	if err != nil {
 		log.Printf("[%d] Unable to dial assuan socket \"%s\": %s", id, socketNameAssuan, err.Error())
+		return
 	}
 
 	c.wg.Add(1)
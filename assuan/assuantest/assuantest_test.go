@@ -0,0 +1,120 @@
+package assuantest_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/assuantest"
+	"github.com/rupor-github/win-gpg-agent/assuan/client"
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+	"github.com/rupor-github/win-gpg-agent/assuan/server"
+)
+
+const echoScript = `# Echo server conversation.
+S: OK Echo
+C: ECHO hello%25world
+S: D hello%25world
+S: OK
+C: NAME
+S: INQUIRE NAME
+C: D Bob
+C: END
+S~ D Hello,? Bob
+S: OK
+C: BYE
+S: OK
+`
+
+func echoProto() server.ProtoInfo {
+	return server.ProtoInfo{
+		Greeting: "Echo",
+		Handlers: map[string]server.CommandHandler{
+			"ECHO": func(_ context.Context, pipe *common.Pipe, _ interface{}, params string) error {
+				return pipe.WriteData([]byte(params))
+			},
+			"NAME": func(_ context.Context, pipe *common.Pipe, _ interface{}, _ string) error {
+				res, err := server.Inquire(pipe, []string{"NAME"})
+				if err != nil {
+					return err
+				}
+				return pipe.WriteData([]byte("Hello " + string(res["NAME"])))
+			},
+		},
+		GetDefaultState: func() interface{} { return nil },
+	}
+}
+
+func parse(t *testing.T, text string) *assuantest.Script {
+	t.Helper()
+	s, err := assuantest.ParseScript(t.Name(), strings.NewReader(text))
+	if err != nil {
+		t.Fatal("Unexpected script error:", err)
+	}
+	return s
+}
+
+func TestParseScript(t *testing.T) {
+	for _, bad := range []string{"X: OK", "S", "S~ (", "OK"} {
+		if _, err := assuantest.ParseScript("bad", strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+	s := parse(t, echoScript)
+	if len(s.Lines) != 12 || s.Lines[0].No != 2 || s.Lines[0].FromClient || !s.Lines[1].FromClient {
+		t.Errorf("Unexpected script: %+v", s.Lines)
+	}
+}
+
+func TestRunServer(t *testing.T) {
+	if err := assuantest.RunServer(parse(t, echoScript), echoProto()); err != nil {
+		t.Error("Unexpected divergence:", err)
+	}
+
+	bad := strings.Replace(echoScript, "S: D hello%25world", "S: D hello world", 1)
+	err := assuantest.RunServer(parse(t, bad), echoProto())
+	var d *assuantest.Divergence
+	if !errors.As(err, &d) || d.Line.No != 4 || d.Got != "D hello%25world" {
+		t.Errorf("Unexpected divergence: %v", err)
+	}
+}
+
+func TestRunClient(t *testing.T) {
+	run := func(ses *client.Session) error {
+		if data, err := ses.SimpleCmd("ECHO", "hello%world"); err != nil || string(data) != "hello%world" {
+			return fmt.Errorf("unexpected ECHO result: %q (%v)", data, err)
+		}
+		data, err := ses.Transact("NAME", "", map[string]interface{}{"NAME": "Bob"})
+		if err != nil || string(data) != "Hello Bob" {
+			return fmt.Errorf("unexpected NAME result: %q (%v)", data, err)
+		}
+		return ses.Close()
+	}
+	if err := assuantest.RunClient(parse(t, strings.Replace(echoScript, "S~ D Hello,? Bob", "S: D Hello Bob", 1)), run); err != nil {
+		t.Error("Unexpected divergence:", err)
+	}
+
+	bad := strings.Replace(echoScript, "C: ECHO hello%25world", "C: ECHO hello", 1)
+	err := assuantest.RunClient(parse(t, bad), run)
+	var d *assuantest.Divergence
+	if !errors.As(err, &d) || d.Line.No != 3 || d.Got != "ECHO hello%25world" {
+		t.Errorf("Unexpected divergence: %v", err)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	proto := echoProto()
+	proto.Logger = assuantest.NewRecorder(&buf, true)
+	if err := assuantest.RunServer(parse(t, echoScript), proto); err != nil {
+		t.Fatal("Unexpected divergence:", err)
+	}
+
+	recorded := parse(t, buf.String())
+	if err := assuantest.RunServer(recorded, echoProto()); err != nil {
+		t.Errorf("Recorded script does not replay: %v\n%s", err, buf.String())
+	}
+}
@@ -0,0 +1,46 @@
+package assuantest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+)
+
+// Recorder is common.Logger which writes protocol lines logged by pipe in
+// script format, so conversation could be replayed later. Lines are
+// recorded after redaction, use Pipe.SetRedactor(nil) to capture secrets.
+type Recorder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	server bool
+}
+
+// NewRecorder creates recorder for pipe of server (server is true) or client
+// side of conversation.
+func NewRecorder(w io.Writer, server bool) *Recorder {
+	return &Recorder{w: w, server: server}
+}
+
+// Logf implements common.Logger, only LogTrace messages are recorded.
+func (r *Recorder) Logf(level common.LogLevel, format string, args ...interface{}) {
+	if level != common.LogTrace {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if len(msg) < 2 || msg[1] != ' ' || (msg[0] != '<' && msg[0] != '>') {
+		return
+	}
+	// Pipe marks lines it sent with ">".
+	fromClient := (msg[0] == '>') != r.server
+	side := "S: "
+	if fromClient {
+		side = "C: "
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintln(r.w, side+strings.TrimSuffix(msg[2:], "\n"))
+}
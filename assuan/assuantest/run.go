@@ -0,0 +1,129 @@
+package assuantest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/client"
+	"github.com/rupor-github/win-gpg-agent/assuan/server"
+)
+
+// Timeout limits waiting for every single line of conversation.
+var Timeout = 5 * time.Second
+
+// player sends lines of one side of conversation and checks lines sent by
+// the other side.
+type player struct {
+	script *Script
+	conn   net.Conn
+	rd     *bufio.Reader
+	client bool
+}
+
+func newPlayer(s *Script, conn net.Conn, client bool) *player {
+	return &player{script: s, conn: conn, rd: bufio.NewReader(conn), client: client}
+}
+
+func (p *player) readLine() (string, error) {
+	_ = p.conn.SetReadDeadline(time.Now().Add(Timeout))
+	line, err := p.rd.ReadString('\n')
+	if err != nil {
+		if line != "" && errors.Is(err, io.EOF) {
+			return line, nil
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// expectsMore reports whether other side should send something after line i.
+func (p *player) expectsMore(i int) bool {
+	for _, l := range p.script.Lines[i:] {
+		if l.FromClient != p.client {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *player) play() error {
+	for i, l := range p.script.Lines {
+		if l.FromClient == p.client {
+			_ = p.conn.SetWriteDeadline(time.Now().Add(Timeout))
+			if _, err := io.WriteString(p.conn, l.Text+"\n"); err != nil {
+				if !p.expectsMore(i) {
+					// Peer is not interested in the rest (for example
+					// client does not wait for OK after BYE).
+					return nil
+				}
+				return &Divergence{Script: p.script.Name, Line: l, Got: "<write: " + err.Error() + ">"}
+			}
+			continue
+		}
+		got, err := p.readLine()
+		if err != nil {
+			return &Divergence{Script: p.script.Name, Line: l, Got: "<" + err.Error() + ">"}
+		}
+		if !l.Match(got) {
+			return &Divergence{Script: p.script.Name, Line: l, Got: got}
+		}
+	}
+	return nil
+}
+
+// RunServer plays client side of script against server implementing proto
+// and returns the first divergence. Lines sent by server after the end of
+// script are not checked.
+func RunServer(s *Script, proto server.ProtoInfo) error {
+	cl, srv := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer srv.Close()
+		_ = server.ServeContext(ctx, srv, proto)
+	}()
+
+	err := newPlayer(s, cl, true).play()
+	cl.Close()
+	cancel()
+	<-done
+	return err
+}
+
+// RunClient plays server side of script (including greeting) while fn
+// drives client session. Script divergence takes precedence over error
+// returned by fn. Client must not send anything after the end of script.
+func RunClient(s *Script, fn func(ses *client.Session) error) error {
+	cl, srv := net.Pipe()
+	perr := make(chan error, 1)
+	go func() {
+		defer srv.Close()
+		p := newPlayer(s, srv, false)
+		if err := p.play(); err != nil {
+			perr <- err
+			return
+		}
+		// Client should not send anything else, just close connection.
+		if got, err := p.readLine(); err == nil {
+			perr <- &Divergence{Script: s.Name, Got: got, Finished: true}
+			return
+		}
+		perr <- nil
+	}()
+
+	ses, err := client.Init(cl)
+	if err == nil {
+		err = fn(ses)
+	}
+	cl.Close()
+	if derr := <-perr; derr != nil {
+		return derr
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+// Package assuantest replays scripted Assuan conversations against servers
+// and clients.
+//
+// Script is a text file with one protocol line per script line:
+//
+//	# Comments and empty lines are ignored.
+//	S: OK Pleased to meet you
+//	C: GETINFO version
+//	S~ D 2\.\d+\.\d+
+//	S: OK
+//
+// "C:" lines are sent by client and "S:" lines by server, exactly as they
+// appear on the wire (parameters are percent-escaped). "C~" and "S~" lines
+// contain regular expressions which must match the whole line. Inquiries are
+// ordinary lines: server sends INQUIRE, client answers with D and END.
+//
+// Scripts could be written by hand or captured with Recorder.
+package assuantest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Line is a single step of Script.
+type Line struct {
+	// Line number in script file.
+	No int
+	// True if line is sent by client.
+	FromClient bool
+	// Protocol line without line feed, or regular expression source if
+	// Pattern is not nil.
+	Text    string
+	Pattern *regexp.Regexp
+}
+
+// Match reports whether protocol line matches script line.
+func (l Line) Match(s string) bool {
+	if l.Pattern != nil {
+		return l.Pattern.MatchString(s)
+	}
+	return l.Text == s
+}
+
+func (l Line) String() string {
+	sep := ": "
+	if l.Pattern != nil {
+		sep = "~ "
+	}
+	if l.FromClient {
+		return "C" + sep + l.Text
+	}
+	return "S" + sep + l.Text
+}
+
+// Script is a recorded conversation.
+type Script struct {
+	// Name is used in error messages, usually file name.
+	Name  string
+	Lines []Line
+}
+
+// ParseScript reads script from r.
+func ParseScript(name string, r io.Reader) (*Script, error) {
+	s := &Script{Name: name}
+	sc := bufio.NewScanner(r)
+	for no := 1; sc.Scan(); no++ {
+		text := strings.TrimRight(sc.Text(), "\r")
+		if len(strings.TrimSpace(text)) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if len(text) < 2 || (text[0] != 'C' && text[0] != 'S') || (text[1] != ':' && text[1] != '~') {
+			return nil, fmt.Errorf("%s:%d: line should start with C:, S:, C~ or S~", name, no)
+		}
+
+		l := Line{No: no, FromClient: text[0] == 'C', Text: strings.TrimPrefix(text[2:], " ")}
+		if text[1] == '~' {
+			re, err := regexp.Compile("^(?:" + l.Text + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", name, no, err)
+			}
+			l.Pattern = re
+		}
+		s.Lines = append(s.Lines, l)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadScript reads script from file.
+func LoadScript(path string) (*Script, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseScript(path, f)
+}
+
+// Divergence describes the first difference between script and actual
+// conversation.
+type Divergence struct {
+	Script string
+	// Script line which was expected, zero if script was already finished.
+	Line     Line
+	Got      string
+	Finished bool
+}
+
+func (d *Divergence) Error() string {
+	if d.Finished {
+		return fmt.Sprintf("%s: unexpected line after end of script: %q", d.Script, d.Got)
+	}
+	return fmt.Sprintf("%s:%d: expected %q, got %q", d.Script, d.Line.No, d.Line.String(), d.Got)
+}
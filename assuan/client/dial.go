@@ -0,0 +1,258 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Prefixes of socket files recognized by Dial.
+const (
+	redirectPrefix = "%Assuan%\n"
+	cygwinPrefix   = "!<socket >"
+)
+
+// maxRedirects limits chain of GnuPG redirection files.
+const maxRedirects = 4
+
+// Dial connects to Assuan (or agent) endpoint, see DialContext.
+func Dial(addr string) (net.Conn, error) {
+	return DialContext(context.Background(), addr)
+}
+
+// DialContext connects to Assuan (or agent) endpoint and returns connection
+// ready for use. Address could be:
+//
+//	unix:<path>         - AF_UNIX socket
+//	assuan-file:<path>  - libassuan socket file on Windows (TCP port and nonce)
+//	cygwin:<path>       - Cygwin/MSYS socket file
+//	tcp:<host>:<port>   - plain TCP connection
+//	pipe:<name>         - Windows named pipe, \\.\pipe\ is added if missing
+//	<path>              - format of the file is detected automatically
+//
+// Automatic detection follows GnuPG redirection files ("%Assuan%" followed by
+// "socket=<name>" line, environment variables in name are expanded), which
+// are used when socket could not be created in GnuPG home directory.
+func DialContext(ctx context.Context, addr string) (net.Conn, error) {
+	return dialAddr(ctx, addr, 0)
+}
+
+func dialAddr(ctx context.Context, addr string, depth int) (net.Conn, error) {
+	var d net.Dialer
+	scheme, rest := splitScheme(addr)
+	switch scheme {
+	case "unix":
+		return d.DialContext(ctx, "unix", rest)
+	case "tcp":
+		return d.DialContext(ctx, "tcp", rest)
+	case "pipe":
+		if !strings.HasPrefix(rest, `\\`) {
+			rest = `\\.\pipe\` + rest
+		}
+		return dialPipe(ctx, rest)
+	case "assuan-file":
+		data, err := ioutil.ReadFile(rest)
+		if err != nil {
+			return nil, err
+		}
+		return dialAssuanFile(ctx, rest, data)
+	case "cygwin":
+		data, err := ioutil.ReadFile(rest)
+		if err != nil {
+			return nil, err
+		}
+		return dialCygwin(ctx, rest, data)
+	}
+	return dialFile(ctx, addr, depth)
+}
+
+// splitScheme separates known address scheme, single letter "schemes" are
+// drive names in Windows paths.
+func splitScheme(addr string) (string, string) {
+	i := strings.IndexByte(addr, ':')
+	if i < 0 {
+		return "", addr
+	}
+	switch scheme := addr[:i]; scheme {
+	case "unix", "tcp", "pipe", "assuan-file", "cygwin":
+		return scheme, addr[i+1:]
+	}
+	return "", addr
+}
+
+// dialFile detects format of socket file and connects accordingly.
+func dialFile(ctx context.Context, fn string, depth int) (net.Conn, error) {
+	if fi, err := os.Stat(fn); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", fn)
+	}
+
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, err
+		}
+		// AF_UNIX sockets could not be read as files on some systems.
+		var d net.Dialer
+		if conn, uerr := d.DialContext(ctx, "unix", fn); uerr == nil {
+			return conn, nil
+		}
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte(redirectPrefix)):
+		if depth >= maxRedirects {
+			return nil, fmt.Errorf("too many redirections for socket \"%s\"", fn)
+		}
+		target, err := parseRedirect(data)
+		if err != nil {
+			return nil, fmt.Errorf("bad redirection file \"%s\": %w", fn, err)
+		}
+		log.Printf("Socket \"%s\" redirected to \"%s\"", fn, target)
+		return dialAddr(ctx, target, depth+1)
+	case bytes.HasPrefix(data, []byte(cygwinPrefix)):
+		return dialCygwin(ctx, fn, data)
+	case len(data) == 0:
+		// AF_UNIX socket on Windows looks like empty file.
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", fn)
+	}
+	return dialAssuanFile(ctx, fn, data)
+}
+
+// parseRedirect returns target of GnuPG socket redirection file.
+func parseRedirect(data []byte) (string, error) {
+	for _, line := range strings.Split(string(data[len(redirectPrefix):]), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "socket=") {
+			target := os.ExpandEnv(strings.TrimPrefix(line, "socket="))
+			if target == "" {
+				break
+			}
+			return target, nil
+		}
+	}
+	return "", fmt.Errorf("socket name not found")
+}
+
+// dialAssuanFile connects to libassuan socket emulation on Windows - file
+// contains TCP port on the first line followed by 16 bytes of nonce.
+func dialAssuanFile(ctx context.Context, fn string, data []byte) (net.Conn, error) {
+	var port int
+	var nonce [16]byte
+
+	reader := bytes.NewBuffer(data)
+
+	// Read the target port number from the first line
+	tmp, err := reader.ReadString('\n')
+	if err == nil {
+		// Sanity check, make sure this is actually an int
+		port, err = strconv.Atoi(strings.TrimSpace(tmp))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the rest of the nonce from the file
+	n, err := reader.Read(nonce[:])
+	if err != nil {
+		return nil, err
+	} else if n != 16 {
+		err = fmt.Errorf("read incorrect number of bytes for nonce. Expected 16, got %d (0x%X)", n, nonce)
+		return nil, err
+	}
+
+	log.Printf("Client dial for assuan socket \"%s\" - port: %d", fn, port)
+
+	// Try to connect to the libassaun TCP socket hosted on localhost
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(nonce[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dialCygwin connects to Cygwin socket emulation - file contains
+// "!<socket >PORT s NONCE", where nonce is 4 dash separated little endian
+// 32 bit words in hex.
+func dialCygwin(ctx context.Context, fn string, data []byte) (net.Conn, error) {
+	var port int
+	var kind, nonceStr string
+	if _, err := fmt.Sscanf(strings.TrimPrefix(string(data), cygwinPrefix), "%d %s %s", &port, &kind, &nonceStr); err != nil {
+		return nil, fmt.Errorf("bad cygwin socket file \"%s\": %w", fn, err)
+	}
+	if kind != "s" {
+		return nil, fmt.Errorf("unsupported cygwin socket type \"%s\" in \"%s\"", kind, fn)
+	}
+	nonce, err := parseCygwinNonce(nonceStr)
+	if err != nil {
+		return nil, fmt.Errorf("bad cygwin socket file \"%s\": %w", fn, err)
+	}
+
+	log.Printf("Client dial for cygwin socket \"%s\" - port: %d", fn, port)
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	if err := cygwinHandshake(conn, nonce); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cygwin handshake on \"%s\" failed: %w", fn, err)
+	}
+	return conn, nil
+}
+
+func parseCygwinNonce(s string) (nonce [16]byte, err error) {
+	words := strings.Split(s, "-")
+	if len(words) != 4 {
+		return nonce, fmt.Errorf("invalid nonce \"%s\"", s)
+	}
+	for i, w := range words {
+		b, err := hex.DecodeString(w)
+		if err != nil || len(b) != 4 {
+			return nonce, fmt.Errorf("invalid nonce \"%s\"", s)
+		}
+		nonce[i*4], nonce[i*4+1], nonce[i*4+2], nonce[i*4+3] = b[3], b[2], b[1], b[0]
+	}
+	return nonce, nil
+}
+
+// cygwinHandshake exchanges nonce and pid:uid:gid credentials with server.
+func cygwinHandshake(conn io.ReadWriter, nonce [16]byte) error {
+	if _, err := conn.Write(nonce[:]); err != nil {
+		return err
+	}
+	var nonceR [16]byte
+	if _, err := io.ReadFull(conn, nonceR[:]); err != nil {
+		return err
+	}
+	if nonceR != nonce {
+		return fmt.Errorf("server returned invalid nonce")
+	}
+
+	var creds [12]byte
+	binary.LittleEndian.PutUint32(creds[0:], uint32(os.Getpid()))
+	binary.LittleEndian.PutUint32(creds[4:], uint32(os.Getuid()))
+	binary.LittleEndian.PutUint32(creds[8:], uint32(os.Getgid()))
+	if _, err := conn.Write(creds[:]); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(conn, creds[:])
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+func dialPipe(_ context.Context, name string) (net.Conn, error) {
+	return nil, fmt.Errorf("named pipes are not supported on this platform: %s", name)
+}
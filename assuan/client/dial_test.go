@@ -0,0 +1,163 @@
+package client_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	assuan "github.com/rupor-github/win-gpg-agent/assuan/client"
+	"github.com/rupor-github/win-gpg-agent/assuan/server"
+)
+
+// echoOnce accepts single connection, runs handshake and echoes one line.
+func echoOnce(t *testing.T, l net.Listener, handshake func(net.Conn) error) {
+	t.Helper()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if handshake != nil {
+			if err := handshake(conn); err != nil {
+				t.Error("Unexpected handshake error:", err)
+				return
+			}
+		}
+		_, _ = io.Copy(conn, io.LimitReader(conn, 5))
+	}()
+}
+
+func checkEcho(t *testing.T, addr string) {
+	t.Helper()
+	conn, err := assuan.Dial(addr)
+	if err != nil {
+		t.Fatalf("Unexpected error on Dial(%q): %v", addr, err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal("Unexpected write error:", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping\n" {
+		t.Errorf("Unexpected echo from %q: %q (%v)", addr, buf, err)
+	}
+}
+
+func TestDial(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("unix", func(t *testing.T) {
+		path := filepath.Join(dir, "S.unix")
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal("Unexpected listen error:", err)
+		}
+		defer l.Close()
+
+		for _, addr := range []string{path, "unix:" + path} {
+			echoOnce(t, l, nil)
+			checkEcho(t, addr)
+		}
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal("Unexpected listen error:", err)
+		}
+		defer l.Close()
+		echoOnce(t, l, nil)
+		checkEcho(t, "tcp:"+l.Addr().String())
+	})
+
+	t.Run("assuan file", func(t *testing.T) {
+		path := filepath.Join(dir, "S.assuan")
+		l, err := server.ListenAssuanSocket(path)
+		if err != nil {
+			t.Fatal("Unexpected listen error:", err)
+		}
+		defer l.Close()
+
+		for _, addr := range []string{path, "assuan-file:" + path} {
+			echoOnce(t, l, nil)
+			checkEcho(t, addr)
+		}
+	})
+
+	t.Run("cygwin", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal("Unexpected listen error:", err)
+		}
+		defer l.Close()
+
+		nonce := []byte("0123456789abcdef")
+		var words []byte
+		for i := 0; i < 4; i++ {
+			b := nonce[i*4 : i*4+4]
+			if i != 0 {
+				words = append(words, '-')
+			}
+			words = append(words, hex.EncodeToString([]byte{b[3], b[2], b[1], b[0]})...)
+		}
+		path := filepath.Join(dir, "S.cygwin")
+		content := "!<socket >" + strconv.Itoa(l.Addr().(*net.TCPAddr).Port) + " s " + string(words)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		handshake := func(conn net.Conn) error {
+			buf := make([]byte, 16)
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return err
+			}
+			if !bytes.Equal(buf, nonce) {
+				t.Errorf("Mismatched nonce: wanted %q, got %q", nonce, buf)
+			}
+			if _, err := conn.Write(buf); err != nil {
+				return err
+			}
+			creds := make([]byte, 12)
+			if _, err := io.ReadFull(conn, creds); err != nil {
+				return err
+			}
+			_, err := conn.Write(creds)
+			return err
+		}
+		for _, addr := range []string{path, "cygwin:" + path} {
+			echoOnce(t, l, handshake)
+			checkEcho(t, addr)
+		}
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		path := filepath.Join(dir, "S.target")
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal("Unexpected listen error:", err)
+		}
+		defer l.Close()
+
+		t.Setenv("ASSUAN_TEST_DIR", dir)
+		redirect := filepath.Join(dir, "S.redirect")
+		if err := ioutil.WriteFile(redirect, []byte("%Assuan%\nsocket=${ASSUAN_TEST_DIR}/S.target\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		echoOnce(t, l, nil)
+		checkEcho(t, redirect)
+
+		loop := filepath.Join(dir, "S.loop")
+		if err := ioutil.WriteFile(loop, []byte("%Assuan%\nsocket="+loop+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := assuan.Dial(loop); err == nil {
+			t.Error("Expected error for redirection loop")
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+	"os"
+)
+
+// SendFD passes file descriptor to server over unix socket (SCM_RIGHTS).
+// common.ErrNoFDPassing is returned if session stream is not unix socket.
+func (ses *Session) SendFD(f *os.File) error {
+	return ses.Pipe.SendFD(f)
+}
+
+// ReceiveFD returns the oldest file descriptor passed by server and not yet
+// claimed, caller is responsible for closing it.
+func (ses *Session) ReceiveFD() (*os.File, error) {
+	return ses.Pipe.ReceiveFD()
+}
+
+// InputFD passes f to server and makes it input descriptor for following
+// commands (INPUT FD).
+func (ses *Session) InputFD(ctx context.Context, f *os.File) error {
+	if err := ses.SendFD(f); err != nil {
+		return err
+	}
+	_, err := ses.SimpleCmdContext(ctx, "INPUT", "FD")
+	return err
+}
+
+// OutputFD passes f to server and makes it output descriptor for following
+// commands (OUTPUT FD).
+func (ses *Session) OutputFD(ctx context.Context, f *os.File) error {
+	if err := ses.SendFD(f); err != nil {
+		return err
+	}
+	_, err := ses.SimpleCmdContext(ctx, "OUTPUT", "FD")
+	return err
+}
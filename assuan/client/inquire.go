@@ -0,0 +1,78 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"errors"
+	"io"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+)
+
+// Inquirer answers server inquiries. When server sends "INQUIRE KEYWORD args"
+// while executing command, Inquire is called with keyword and unparsed
+// arguments (possibly empty). Data read from returned reader is sent to
+// server followed by END, nil reader sends empty response.
+//
+// If Inquire returns error inquiry is cancelled (CAN is sent to server) and
+// this error is returned by command once server completes it.
+type Inquirer interface {
+	Inquire(ctx context.Context, keyword string, args string) (io.Reader, error)
+}
+
+// InquireFunc is an adapter to allow use of ordinary functions as Inquirer.
+type InquireFunc func(ctx context.Context, keyword string, args string) (io.Reader, error)
+
+// Inquire calls f(ctx, keyword, args).
+func (f InquireFunc) Inquire(ctx context.Context, keyword string, args string) (io.Reader, error) {
+	return f(ctx, keyword, args)
+}
+
+type rawArgsKey struct{}
+
+// RawInquiryArgs returns arguments of inquiry being answered as they were
+// received, before percent-decoding. It is needed for arguments with their
+// own encoding, for example plus-escaped passphrase pinentry sends with
+// INQUIRE QUALITY (see common.PlusUnescape).
+func RawInquiryArgs(ctx context.Context) string {
+	args, _ := ctx.Value(rawArgsKey{}).(string)
+	return args
+}
+
+// InquireData answers inquiries with static data looked up by keyword,
+// inquiry arguments are ignored. Values can be either []byte, string or
+// implementers of io.Reader or encoding.TextMarshaler.
+type InquireData map[string]interface{}
+
+// Inquire implements Inquirer.
+func (d InquireData) Inquire(_ context.Context, keyword string, _ string) (io.Reader, error) {
+	v, prs := d[keyword]
+	if !prs {
+		return nil, noInquireData(keyword)
+	}
+
+	switch v := v.(type) {
+	case []byte:
+		return bytes.NewReader(v), nil
+	case string:
+		return bytes.NewReader([]byte(v)), nil
+	case io.Reader:
+		return v, nil
+	case encoding.TextMarshaler:
+		marshalled, err := v.MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(marshalled), nil
+	default:
+		return nil, errors.New("invalid type in data map value")
+	}
+}
+
+func noInquireData(keyword string) *common.Error {
+	return &common.Error{
+		Src: common.ErrSrcAssuan, Code: common.ErrAssNoInquireCb,
+		SrcName: "assuan", Message: "missing data with keyword " + keyword,
+	}
+}
@@ -1,74 +1,15 @@
 package client
 
 import (
-	"bytes"
-	"encoding"
-	"errors"
-	"fmt"
+	"context"
 	"io"
-	"io/ioutil"
 	"log"
-	"net"
-	"os"
 	"os/exec"
-	"strconv"
 	"strings"
 
 	"github.com/rupor-github/win-gpg-agent/assuan/common"
 )
 
-// Dial Asuan file socket on Windows - read contents of the target file and connect to a TCP port.
-func Dial(fn string) (net.Conn, error) {
-
-	f, err := os.Open(fn)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	data, err := ioutil.ReadAll(f)
-	if err != nil {
-		return nil, err
-	}
-
-	var port int
-	var nonce [16]byte
-
-	reader := bytes.NewBuffer(data)
-
-	// Read the target port number from the first line
-	tmp, err := reader.ReadString('\n')
-	if err == nil {
-		// Sanity check, make sure this is actually an int
-		port, err = strconv.Atoi(strings.TrimSpace(tmp))
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	// Read the rest of the nonce from the file
-	n, err := reader.Read(nonce[:])
-	if err != nil {
-		return nil, err
-	} else if n != 16 {
-		err = fmt.Errorf("read incorrect number of bytes for nonce. Expected 16, got %d (0x%X)", n, nonce)
-		return nil, err
-	}
-
-	log.Printf("Client dial for assuan socket \"%s\" - port: %d, nonce: %X", fn, port, nonce)
-
-	// Try to connect to the libassaun TCP socket hosted on localhost
-	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", fmt.Sprint(port)))
-	if err != nil {
-		return nil, err
-	}
-	_, err = conn.Write(nonce[:])
-	if err != nil {
-		return nil, err
-	}
-	return conn, nil
-}
-
 // Session struct is a wrapper which represents an alive connection between
 // client and server.
 //
@@ -78,12 +19,19 @@ func Dial(fn string) (net.Conn, error) {
 // represents client side of connection.
 type Session struct {
 	Pipe common.Pipe
+
+	// Number of responses to abandoned (cancelled) commands still expected from
+	// server. They are drained before next command is sent.
+	stale int
+
+	// Default handler for server inquiries.
+	inquirer Inquirer
 }
 
 // Init initiates session using passed Reader/Writer.
 func Init(stream io.ReadWriter) (*Session, error) {
 	log.Println("Starting session...")
-	ses := &Session{Pipe: common.New(stream)}
+	ses := &Session{Pipe: common.New(common.WithFDPassing(stream))}
 
 	// Take server's OK from pipe.
 	_, _, err := ses.Pipe.ReadLine()
@@ -123,6 +71,13 @@ func InitCmd(cmd *exec.Cmd) (*Session, error) {
 	return ses, nil
 }
 
+// OnStatus registers handler for status lines (S KEYWORD ...) sent by server
+// while commands are executed. Empty keyword registers catch-all handler, nil
+// handler removes registration. See common.Pipe.OnStatus for details.
+func (ses *Session) OnStatus(keyword string, handler common.StatusHandler) {
+	ses.Pipe.OnStatus(keyword, handler)
+}
+
 // Close sends BYE and closes underlying pipe.
 func (ses *Session) Close() error {
 	log.Println("Closing session (sending BYE)...")
@@ -144,111 +99,237 @@ func (ses *Session) Reset() error {
 	return err
 }
 
-// SimpleCmd sends command with specified parameters and reads data sent by server if any.
+// SetInquirer sets default handler for server inquiries received while
+// executing SimpleCmd, Option and Reset. Without handler all inquiries are
+// cancelled.
+func (ses *Session) SetInquirer(inq Inquirer) {
+	ses.inquirer = inq
+}
+
+// SimpleCmd sends command with specified parameters and reads data sent by
+// server if any. Server inquiries are answered by Inquirer set with
+// SetInquirer.
 func (ses *Session) SimpleCmd(cmd string, params string) (data []byte, err error) {
+	return ses.SimpleCmdContext(context.Background(), cmd, params)
+}
+
+// SimpleCmdContext is the same as SimpleCmd but command is aborted when
+// context is done, in which case returned error is *common.Error with
+// ErrCanceled or ErrTimeout code. Context deadline is applied to the
+// underlying stream if it supports deadlines (net.Conn, os.File). Response to
+// aborted command is discarded by the next call.
+func (ses *Session) SimpleCmdContext(ctx context.Context, cmd string, params string) (data []byte, err error) {
 	log.Println("Sending command:", cmd, params)
-	err = ses.Pipe.WriteLine(cmd, params)
+	return ses.transact(ctx, cmd, common.EscapeParameters(params), ses.inquirer)
+}
+
+// SimpleCmdEncoded is the same as SimpleCmdContext but params are sent as
+// is, they must be already escaped (for example with common.PlusEscape).
+func (ses *Session) SimpleCmdEncoded(ctx context.Context, cmd string, params string) (data []byte, err error) {
+	log.Println("Sending command:", cmd, params)
+	return ses.transact(ctx, cmd, params, ses.inquirer)
+}
+
+// Transact sends command with specified params and uses byte arrays in data
+// argument to answer server's inquiries. Values in data can be either []byte
+// or pointer to implementer of io.Reader or encoding.TextMarhshaller. Values
+// are looked up by inquiry keyword, see InquireData.
+func (ses *Session) Transact(cmd string, params string, data map[string]interface{}) (rdata []byte, err error) {
+	return ses.TransactContext(context.Background(), cmd, params, data)
+}
+
+// TransactContext is the same as Transact but transaction is aborted when
+// context is done, see SimpleCmdContext. If server inquiry is being answered
+// at that moment CAN is sent to server.
+func (ses *Session) TransactContext(ctx context.Context, cmd string, params string, data map[string]interface{}) (rdata []byte, err error) {
+	return ses.TransactWith(ctx, cmd, params, InquireData(data))
+}
+
+// TransactWith sends command with specified params and uses inq to answer
+// server's inquiries. If inq is nil all inquiries are cancelled. Error
+// returned by inq is returned after server completes the command. Context is
+// handled the same way as in TransactContext.
+func (ses *Session) TransactWith(ctx context.Context, cmd string, params string, inq Inquirer) (rdata []byte, err error) {
+	log.Println("Initiating transaction:", cmd, params)
+	return ses.transact(ctx, cmd, common.EscapeParameters(params), inq)
+}
+
+// transact sends command with already escaped params.
+func (ses *Session) transact(ctx context.Context, cmd string, params string, inq Inquirer) (rdata []byte, err error) {
+	var inquiring bool
+
+	stop := ses.Pipe.WatchContext(ctx)
+	rdata, err = ses.exchange(ctx, cmd, params, inq, &inquiring)
+	stop()
+
+	if err != nil && ctx.Err() != nil {
+		log.Println("... aborted:", ctx.Err())
+		if inquiring {
+			// Server waits for our data, tell it we are not going to send any.
+			if err := ses.Pipe.WriteLine("CAN", ""); err != nil {
+				log.Println("... I/O error:", err)
+			}
+		}
+		return nil, common.ContextError(ctx.Err())
+	}
+	return rdata, err
+}
+
+func (ses *Session) exchange(ctx context.Context, cmd string, params string, inq Inquirer, inquiring *bool) (rdata []byte, err error) {
+	if err := ses.drain(); err != nil {
+		return nil, err
+	}
+
+	err = ses.Pipe.WriteEncodedLine(cmd, params)
 	if err != nil {
 		log.Println("... I/O error:", err)
-		return []byte{}, err
+		return nil, err
 	}
+	ses.stale++
 
+	// First error returned by inquirer, reported when server completes command.
+	var inqErr error
 	for {
-		scmd, sparams, err := ses.Pipe.ReadLine()
+		// Inquiry arguments may use their own encoding, keep them raw.
+		scmd, raw, err := ses.Pipe.ReadRawLine()
 		if err != nil {
 			log.Println("... I/O error:", err)
-			return []byte{}, err
+			return nil, err
 		}
-
-		if scmd == "OK" {
-			return data, nil
+		sparams, err := common.UnescapeParameters(raw)
+		if err != nil {
+			log.Println("... I/O error:", err)
+			return nil, err
 		}
-		if scmd == "ERR" {
+
+		switch scmd {
+		case "INQUIRE":
+			*inquiring = true
+			herr, err := ses.answerInquiry(ctx, sparams, raw, inq)
+			if err != nil {
+				return nil, err
+			}
+			*inquiring = false
+			if herr != nil && inqErr == nil {
+				inqErr = herr
+			}
+		case "OK":
+			ses.stale--
+			if inqErr != nil {
+				return nil, inqErr
+			}
+			return rdata, nil
+		case "ERR":
+			ses.stale--
 			log.Println("... Received ERR: ", sparams)
+			if inqErr != nil {
+				return nil, inqErr
+			}
 			return []byte{}, common.DecodeErrCmd(sparams)
-		}
-		if scmd == "D" {
-			data = append(data, []byte(sparams)...)
+		case "D":
+			log.Println("... Received data chunk")
+			rdata = append(rdata, []byte(sparams)...)
 		}
 	}
 }
 
-// Transact sends command with specified params and uses byte arrays in data
-// argument to answer server's inquiries. Values in data can be either []byte
-// or pointer to implementer of io.Reader or encoding.TextMarhshaller.
-func (ses *Session) Transact(cmd string, params string, data map[string]interface{}) (rdata []byte, err error) {
-	log.Println("Initiating transaction:", cmd, params)
-	err = ses.Pipe.WriteLine(cmd, params)
-	if err != nil {
-		return nil, err
+// answerInquiry sends response to server inquiry. Inquirer errors are
+// returned as herr after inquiry is cancelled, I/O errors are returned as err.
+func (ses *Session) answerInquiry(ctx context.Context, sparams, raw string, inq Inquirer) (herr, err error) {
+	keyword, args := sparams, ""
+	if i := strings.IndexByte(sparams, ' '); i >= 0 {
+		keyword, args = sparams[:i], strings.TrimLeft(sparams[i+1:], " ")
+	}
+	if i := strings.IndexByte(raw, ' '); i >= 0 {
+		ctx = context.WithValue(ctx, rawArgsKey{}, strings.TrimLeft(raw[i+1:], " "))
 	}
 
-	for {
-		scmd, sparams, err := ses.Pipe.ReadLine()
-		if err != nil {
+	var r io.Reader
+	if inq == nil {
+		herr = noInquireData(keyword)
+	} else {
+		r, herr = inq.Inquire(ctx, keyword, args)
+	}
+
+	if herr == nil && r != nil {
+		src := &ctxReader{ctx: ctx, r: r}
+		w := ses.Pipe.DataWriter()
+		if _, err = io.Copy(w, src); err == nil {
+			err = w.Close()
+		}
+		if err != nil && src.err == nil {
+			log.Println("... I/O error:", err)
 			return nil, err
 		}
+		herr = src.err
+	}
 
-		if scmd == "INQUIRE" {
-			inquireResp, prs := data[sparams]
-			if !prs {
-				log.Println("... unknown request:", sparams)
-				if err := ses.Pipe.WriteLine("CAN", ""); err != nil {
-					return nil, err
-				}
+	if herr != nil {
+		log.Println("... cancelling inquiry", keyword+":", herr)
+		if err := ses.Pipe.WriteLine("CAN", ""); err != nil {
+			log.Println("... I/O error:", err)
+			return nil, err
+		}
+		return herr, nil
+	}
 
-				// We asked for FOO but we don't have FOO.
-				return nil, errors.New("missing data with keyword " + sparams)
-			}
+	if err := ses.Pipe.WriteLine("END", ""); err != nil {
+		log.Println("... I/O error:", err)
+		return nil, err
+	}
+	return nil, nil
+}
 
-			switch v := inquireResp.(type) {
-			case []byte:
-				if err := ses.Pipe.WriteData(v); err != nil {
-					log.Println("... I/O error:", err)
-					return nil, err
-				}
-			case io.Reader:
-				if err := ses.Pipe.WriteDataReader(v); err != nil {
-					log.Println("... I/O error:", err)
-					return nil, err
-				}
-			case encoding.TextMarshaler:
-				marhshalled, err := v.MarshalText()
-				if err != nil {
-					return nil, err
-				}
-				if err := ses.Pipe.WriteData(marhshalled); err != nil {
-					log.Println("... I/O error:", err)
-					return nil, err
-				}
-			default:
-				return nil, errors.New("invalid type in data map value")
-			}
+// ctxReader stops reading from data source when context is done. Read which
+// is already blocked cannot be interrupted though. Error returned by data
+// source is remembered to tell it apart from pipe I/O errors.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+	err error
+}
 
-			if err := ses.Pipe.WriteLine("END", ""); err != nil {
-				log.Println("... I/O error:", err)
-				return nil, err
-			}
-		}
+func (cr *ctxReader) Read(b []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := cr.r.Read(b)
+	if err != nil && err != io.EOF {
+		cr.err = err
+	}
+	return n, err
+}
 
-		// Same as SimpleCmd.
-		if scmd == "OK" {
-			return rdata, nil
-		}
-		if scmd == "ERR" {
-			log.Println("... Received ERR: ", sparams)
-			return []byte{}, common.DecodeErrCmd(sparams)
+// drain reads and discards responses to abandoned commands, so next command
+// would not receive them.
+func (ses *Session) drain() error {
+	for ses.stale > 0 {
+		scmd, _, err := ses.Pipe.ReadLine()
+		if err != nil {
+			log.Println("... I/O error while discarding stale response:", err)
+			return err
 		}
-		if scmd == "D" {
-			log.Println("... Received data chunk")
-			rdata = append(rdata, []byte(sparams)...)
+		switch scmd {
+		case "INQUIRE":
+			if err := ses.Pipe.WriteLine("CAN", ""); err != nil {
+				return err
+			}
+		case "OK", "ERR":
+			log.Println("... Discarded stale response:", scmd)
+			ses.stale--
 		}
 	}
+	return nil
 }
 
 // Option sets options for connections.
 func (ses *Session) Option(name string, value string) error {
+	return ses.OptionContext(context.Background(), name, value)
+}
+
+// OptionContext is the same as Option but could be aborted, see SimpleCmdContext.
+func (ses *Session) OptionContext(ctx context.Context, name string, value string) error {
 	log.Println("Setting option", name, "to", value+"...")
-	_, err := ses.SimpleCmd("OPTION", name+" = "+value)
+	_, err := ses.SimpleCmdContext(ctx, "OPTION", name+" = "+value)
 	return err
 }
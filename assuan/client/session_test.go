@@ -3,10 +3,14 @@ package client_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 	"testing"
+	"time"
 
 	assuan "github.com/rupor-github/win-gpg-agent/assuan/client"
 	"github.com/rupor-github/win-gpg-agent/assuan/common"
@@ -90,6 +94,41 @@ OK`)
 	}
 }
 
+func TestSession_Status(t *testing.T) {
+	srvResp := strings.NewReader(`OK Pleased to meet you
+S PINENTRY_LAUNCHED 4242 curses 1.1.0
+S PROGRESS primegen ? 1 2
+D ABCDEF
+OK`)
+	clReq := bytes.Buffer{}
+
+	ses, err := assuan.Init(common.ReadWriter{Reader: srvResp, Writer: &clReq})
+	if err != nil {
+		t.Log("Unexpected error on client.Init:", err)
+		t.FailNow()
+	}
+	defer ses.Close()
+
+	statuses := map[string]string{}
+	ses.OnStatus("", func(keyword, params string) {
+		statuses[keyword] = params
+	})
+
+	data, err := ses.SimpleCmd("GENKEY", "")
+	if err != nil {
+		t.Error("Unexpected error on client.SimpleCmd:", err)
+	}
+	if string(data) != "ABCDEF" {
+		t.Error("Wrong data received:", string(data))
+	}
+	if statuses["PINENTRY_LAUNCHED"] != "4242 curses 1.1.0" {
+		t.Error("Missing or wrong PINENTRY_LAUNCHED status:", statuses)
+	}
+	if statuses["PROGRESS"] != "primegen ? 1 2" {
+		t.Error("Missing or wrong PROGRESS status:", statuses)
+	}
+}
+
 type DummmyMarhshaller struct {
 	s string
 }
@@ -140,3 +179,244 @@ END
 		t.Error("Got:", clReq.Bytes())
 	}
 }
+
+func TestSession_SimpleCmdContext(t *testing.T) {
+	cl, srv := net.Pipe()
+	defer cl.Close()
+	defer srv.Close()
+
+	go func() {
+		pipe := common.New(srv)
+		pipe.WriteLine("OK", "Pleased to meet you")
+		for {
+			cmd, _, err := pipe.ReadLine()
+			if err != nil {
+				return
+			}
+			if cmd == "SLOW" {
+				// Answer only after client gave up.
+				time.Sleep(100 * time.Millisecond)
+				pipe.WriteLine("D", "late")
+				pipe.WriteLine("OK", "")
+				continue
+			}
+			pipe.WriteLine("D", "fast")
+			pipe.WriteLine("OK", "")
+		}
+	}()
+
+	ses, err := assuan.Init(cl)
+	if err != nil {
+		t.Log("Unexpected error on client.Init:", err)
+		t.FailNow()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = ses.SimpleCmdContext(ctx, "SLOW", "")
+	var perr *common.Error
+	if !errors.As(err, &perr) || perr.Code != common.ErrTimeout {
+		t.Error("Expected timeout error, got:", err)
+		t.FailNow()
+	}
+
+	// Response to abandoned command must not be mixed with new one.
+	data, err := ses.SimpleCmd("FAST", "")
+	if err != nil {
+		t.Error("Unexpected error on client.SimpleCmd:", err)
+		t.FailNow()
+	}
+	if string(data) != "fast" {
+		t.Error("Wrong data received:", string(data))
+	}
+}
+
+// slowReader produces endless stream of data slowly.
+type slowReader struct{}
+
+func (slowReader) Read(b []byte) (int, error) {
+	time.Sleep(5 * time.Millisecond)
+	return copy(b, strings.Repeat("x", 2000)), nil
+}
+
+func TestSession_TransactContextCancel(t *testing.T) {
+	cl, srv := net.Pipe()
+	defer cl.Close()
+	defer srv.Close()
+
+	srvLines := make(chan string, 10)
+	go func() {
+		pipe := common.New(srv)
+		pipe.WriteLine("OK", "Pleased to meet you")
+		if _, _, err := pipe.ReadLine(); err != nil {
+			return
+		}
+		pipe.WriteLine("INQUIRE", "foo")
+		for {
+			cmd, _, err := pipe.ReadLine()
+			if err != nil {
+				return
+			}
+			if cmd == "D" {
+				continue
+			}
+			srvLines <- cmd
+			if cmd == "CAN" {
+				pipe.WriteLine("ERR", "536871193 IPC call has been cancelled <User defined source 1>")
+				return
+			}
+		}
+	}()
+
+	ses, err := assuan.Init(cl)
+	if err != nil {
+		t.Log("Unexpected error on client.Init:", err)
+		t.FailNow()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	_, err = ses.TransactContext(ctx, "CMD", "", map[string]interface{}{"foo": &slowReader{}})
+	var perr *common.Error
+	if !errors.As(err, &perr) || perr.Code != common.ErrTimeout {
+		t.Error("Expected timeout error, got:", err)
+		t.FailNow()
+	}
+
+	select {
+	case cmd := <-srvLines:
+		if cmd != "CAN" {
+			t.Error("Client did not send CAN, got:", cmd)
+		}
+	case <-time.After(time.Second):
+		t.Error("Client did not send CAN")
+	}
+}
+
+func TestSession_TransactWith(t *testing.T) {
+	t.Run("inquiry with arguments", func(t *testing.T) {
+		srvResp := strings.NewReader(`OK Pleased to meet you
+INQUIRE QUALITY pass%25word
+INQUIRE PASSPHRASE
+OK
+`)
+		clReq := bytes.Buffer{}
+		ses, err := assuan.Init(common.ReadWriter{Reader: srvResp, Writer: &clReq})
+		if err != nil {
+			t.Log("Unexpected error on client.Init:", err)
+			t.FailNow()
+		}
+
+		var gotArgs string
+		_, err = ses.TransactWith(context.Background(), "GETPIN", "", assuan.InquireFunc(func(_ context.Context, keyword, args string) (io.Reader, error) {
+			switch keyword {
+			case "QUALITY":
+				gotArgs = args
+				return strings.NewReader("42"), nil
+			case "PASSPHRASE":
+				return nil, nil
+			}
+			return nil, errors.New("unexpected inquiry")
+		}))
+		if err != nil {
+			t.Error("Unexpected error on client.TransactWith:", err)
+		}
+		if gotArgs != "pass%word" {
+			t.Errorf("Inquiry arguments mismatch: wanted %s, got %s", "pass%word", gotArgs)
+		}
+		if expected := "GETPIN\nD 42\nEND\nEND\n"; clReq.String() != expected {
+			t.Errorf("Client sent different output: wanted %q, got %q", expected, clReq.String())
+		}
+	})
+	t.Run("data map is looked up by keyword", func(t *testing.T) {
+		srvResp := strings.NewReader(`OK Pleased to meet you
+INQUIRE NEEDPIN "Please enter PIN"
+OK
+`)
+		clReq := bytes.Buffer{}
+		ses, err := assuan.Init(common.ReadWriter{Reader: srvResp, Writer: &clReq})
+		if err != nil {
+			t.Log("Unexpected error on client.Init:", err)
+			t.FailNow()
+		}
+
+		_, err = ses.Transact("CMD", "", map[string]interface{}{"NEEDPIN": "1234"})
+		if err != nil {
+			t.Error("Unexpected error on client.Transact:", err)
+		}
+		if expected := "CMD\nD 1234\nEND\n"; clReq.String() != expected {
+			t.Errorf("Client sent different output: wanted %q, got %q", expected, clReq.String())
+		}
+	})
+	t.Run("handler error cancels inquiry", func(t *testing.T) {
+		srvResp := strings.NewReader(`OK Pleased to meet you
+INQUIRE CIPHERTEXT
+ERR 83886179 Operation cancelled
+OK
+`)
+		clReq := bytes.Buffer{}
+		ses, err := assuan.Init(common.ReadWriter{Reader: srvResp, Writer: &clReq})
+		if err != nil {
+			t.Log("Unexpected error on client.Init:", err)
+			t.FailNow()
+		}
+
+		herr := errors.New("no ciphertext")
+		_, err = ses.TransactWith(context.Background(), "PKDECRYPT", "", assuan.InquireFunc(func(context.Context, string, string) (io.Reader, error) {
+			return nil, herr
+		}))
+		if !errors.Is(err, herr) {
+			t.Error("Expected handler error, got:", err)
+		}
+		if expected := "PKDECRYPT\nCAN\n"; clReq.String() != expected {
+			t.Errorf("Client sent different output: wanted %q, got %q", expected, clReq.String())
+		}
+
+		// Server response to cancelled inquiry is consumed, session is usable.
+		if _, err := ses.SimpleCmd("NOP", ""); err != nil {
+			t.Error("Unexpected error on client.SimpleCmd:", err)
+		}
+	})
+	t.Run("simple command without handler", func(t *testing.T) {
+		srvResp := strings.NewReader(`OK Pleased to meet you
+INQUIRE PINENTRY_LAUNCHED 1234
+ERR 83886179 Operation cancelled
+`)
+		clReq := bytes.Buffer{}
+		ses, err := assuan.Init(common.ReadWriter{Reader: srvResp, Writer: &clReq})
+		if err != nil {
+			t.Log("Unexpected error on client.Init:", err)
+			t.FailNow()
+		}
+
+		_, err = ses.SimpleCmd("GET_PASSPHRASE", "X X X X")
+		var perr *common.Error
+		if !errors.As(err, &perr) || perr.Code != common.ErrAssNoInquireCb {
+			t.Error("Expected missing inquiry handler error, got:", err)
+		}
+		if expected := "GET_PASSPHRASE X X X X\nCAN\n"; clReq.String() != expected {
+			t.Errorf("Client sent different output: wanted %q, got %q", expected, clReq.String())
+		}
+	})
+	t.Run("session default handler", func(t *testing.T) {
+		srvResp := strings.NewReader(`OK Pleased to meet you
+INQUIRE PINENTRY_LAUNCHED 1234
+OK
+`)
+		clReq := bytes.Buffer{}
+		ses, err := assuan.Init(common.ReadWriter{Reader: srvResp, Writer: &clReq})
+		if err != nil {
+			t.Log("Unexpected error on client.Init:", err)
+			t.FailNow()
+		}
+
+		ses.SetInquirer(assuan.InquireData{"PINENTRY_LAUNCHED": []byte{}})
+		if _, err := ses.SimpleCmd("GET_PASSPHRASE", "X X X X"); err != nil {
+			t.Error("Unexpected error on client.SimpleCmd:", err)
+		}
+		if expected := "GET_PASSPHRASE X X X X\nEND\n"; clReq.String() != expected {
+			t.Errorf("Client sent different output: wanted %q, got %q", expected, clReq.String())
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package common
+
+import (
+	"errors"
+	"io"
+)
+
+// dataChunkLen is a maximum length of encoded payload in single D line, 3 is
+// for 'D ' and line feed.
+const dataChunkLen = MaxLineLen - 3
+
+// DataReader decodes sequence of D commands terminated by END and presents
+// it as a binary-safe stream. It is created with Pipe.DataReader.
+type DataReader struct {
+	pipe  *Pipe
+	limit int64
+	total int64
+	buf   []byte
+	err   error
+}
+
+// DataReader returns io.Reader which incrementally reads and decodes data
+// sent by peer using D commands until END is received (reported as io.EOF).
+// If limit is positive and peer sends more than limit bytes of decoded data
+// reading fails with ErrAssTooMuchData error. CAN from peer is reported as
+// ErrAssCanceled error, any other command terminates reading with
+// ErrAssUnexpectedCmd error.
+//
+// Data lines are read from pipe only when needed, so nothing is read before
+// first call to Read.
+func (p *Pipe) DataReader(limit int64) *DataReader {
+	return &DataReader{pipe: p, limit: limit}
+}
+
+// Read implements io.Reader.
+func (r *DataReader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *DataReader) next() error {
+	cmd, chunk, err := r.pipe.ReadRawLine()
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "D":
+	case "END":
+		return io.EOF
+	case "CAN":
+		return Error{Src: ErrSrcAssuan, Code: ErrAssCanceled, SrcName: "assuan", Message: "IPC call has been cancelled"}
+	default:
+		return Error{Src: ErrSrcAssuan, Code: ErrAssUnexpectedCmd, SrcName: "assuan", Message: "unexpected IPC command"}
+	}
+
+	r.buf, err = unescapeData(r.buf[:0], chunk)
+	if err != nil {
+		return err
+	}
+	r.total += int64(len(r.buf))
+	if r.limit > 0 && r.total > r.limit {
+		r.buf = r.buf[:0]
+		r.pipe.Logf(LogError, "... too much data, limit is %d", r.limit)
+		return Error{Src: ErrSrcAssuan, Code: ErrAssTooMuchData, SrcName: "assuan", Message: "too much data"}
+	}
+	return nil
+}
+
+// DataWriter encodes arbitrary binary data and sends it to peer using one or
+// more D commands. It is created with Pipe.DataWriter.
+type DataWriter struct {
+	pipe   *Pipe
+	line   []byte
+	closed bool
+}
+
+// DataWriter returns io.WriteCloser which escapes written data and sends it
+// in D lines no longer than MaxLineLen. Escape sequences are never split
+// between lines. Data is buffered until full line could be sent, so Close
+// must be called to flush the rest. Close does not send END, caller is
+// responsible for terminating data according to the protocol state.
+func (p *Pipe) DataWriter() *DataWriter {
+	return &DataWriter{pipe: p, line: make([]byte, 0, MaxLineLen)}
+}
+
+// Write implements io.Writer.
+func (w *DataWriter) Write(b []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed data writer")
+	}
+	for i, c := range b {
+		need := 1
+		if needsEscape(c) {
+			need = 3
+		}
+		if len(w.line)+need > dataChunkLen+2 {
+			if err := w.flush(); err != nil {
+				return i, err
+			}
+		}
+		if len(w.line) == 0 {
+			w.line = append(w.line, 'D', ' ')
+		}
+		if need == 3 {
+			w.line = append(w.line, '%', hexDigits[c>>4], hexDigits[c&0xF])
+		} else {
+			w.line = append(w.line, c)
+		}
+	}
+	return len(b), nil
+}
+
+// Close flushes buffered data. It does not close underlying pipe.
+func (w *DataWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush()
+}
+
+func (w *DataWriter) flush() error {
+	if len(w.line) == 0 {
+		return nil
+	}
+	w.line = append(w.line, '\n')
+	w.pipe.trace(true, string(w.line))
+	_, err := w.pipe.w.Write(w.line)
+	w.line = w.line[:0]
+	return err
+}
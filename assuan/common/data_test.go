@@ -0,0 +1,150 @@
+package common_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+)
+
+func binarySample(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		// Make sure escapes are spread over chunk boundaries.
+		switch i % 7 {
+		case 0:
+			data[i] = '%'
+		case 3:
+			data[i] = '\n'
+		default:
+			data[i] = byte(i)
+		}
+	}
+	return data
+}
+
+func TestDataWriter(t *testing.T) {
+	t.Run("escapes are not split between lines", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		pipe := common.NewPipe(nil, &buf)
+		defer pipe.Close()
+
+		data := binarySample(common.MaxLineLen * 5)
+		w := pipe.DataWriter()
+		// Write in odd pieces to exercise buffering.
+		for i := 0; i < len(data); i += 333 {
+			end := i + 333
+			if end > len(data) {
+				end = len(data)
+			}
+			if _, err := w.Write(data[i:end]); err != nil {
+				t.Error("Unexpected error on DataWriter.Write:", err)
+				t.FailNow()
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Error("Unexpected error on DataWriter.Close:", err)
+			t.FailNow()
+		}
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if len(lines) < 2 {
+			t.Error("DataWriter did not wrap lines")
+		}
+		for _, line := range lines {
+			if len(line)+1 > common.MaxLineLen {
+				t.Error("DataWriter wrote line bigger than MaxLineLen")
+				t.FailNow()
+			}
+			if !strings.HasPrefix(line, "D ") {
+				t.Error("DataWriter wrote non-data line:", line)
+				t.FailNow()
+			}
+			if i := strings.LastIndexByte(line, '%'); i >= 0 && i > len(line)-3 {
+				t.Error("DataWriter split escape sequence:", line[i:])
+				t.FailNow()
+			}
+		}
+	})
+	t.Run("round trip", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		pipe := common.NewPipe(nil, &buf)
+
+		data := binarySample(common.MaxLineLen * 3)
+		if err := pipe.WriteData(data); err != nil {
+			t.Error("Unexpected error on pipe.WriteData:", err)
+			t.FailNow()
+		}
+		buf.WriteString("END\n")
+
+		rpipe := common.NewPipe(&buf, nil)
+		read, err := rpipe.ReadData()
+		if err != nil {
+			t.Error("Unexpected error on pipe.ReadData:", err)
+			t.FailNow()
+		}
+		if !bytes.Equal(data, read) {
+			t.Error("Data was corrupted during round trip")
+		}
+	})
+}
+
+func TestDataReader(t *testing.T) {
+	t.Run("incremental read", func(t *testing.T) {
+		sample := "D AB%0A\nD %25CD\nEND\nOK\n"
+		pipe := common.NewPipe(strings.NewReader(sample), nil)
+
+		rdr := pipe.DataReader(0)
+		b := make([]byte, 2)
+		var got []byte
+		for {
+			n, err := rdr.Read(b)
+			got = append(got, b[:n]...)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Error("Unexpected error on DataReader.Read:", err)
+				t.FailNow()
+			}
+		}
+		if string(got) != "AB\n%CD" {
+			t.Errorf("DataReader read incorrect data: %q", got)
+		}
+		// Lines after END must stay in pipe.
+		if cmd, _, err := pipe.ReadLine(); err != nil || cmd != "OK" {
+			t.Error("DataReader consumed lines after END:", cmd, err)
+		}
+	})
+	t.Run("limit", func(t *testing.T) {
+		sample := "D ABCDEF\nD ABCDEF\nEND\n"
+		pipe := common.NewPipe(strings.NewReader(sample), nil)
+
+		_, err := io.ReadAll(pipe.DataReader(8))
+		var perr common.Error
+		if !errors.As(err, &perr) || perr.Code != common.ErrAssTooMuchData {
+			t.Error("DataReader did not enforce limit:", err)
+		}
+	})
+	t.Run("cancel", func(t *testing.T) {
+		sample := "D ABCDEF\nCAN\n"
+		pipe := common.NewPipe(strings.NewReader(sample), nil)
+
+		_, err := io.ReadAll(pipe.DataReader(0))
+		var perr common.Error
+		if !errors.As(err, &perr) || perr.Code != common.ErrAssCanceled {
+			t.Error("DataReader did not report cancellation:", err)
+		}
+	})
+	t.Run("bad escape", func(t *testing.T) {
+		sample := "D AB%4\nEND\n"
+		pipe := common.NewPipe(strings.NewReader(sample), nil)
+
+		if _, err := io.ReadAll(pipe.DataReader(0)); err == nil {
+			t.Error("DataReader accepted truncated escape sequence")
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"context"
 	"errors"
 	"regexp"
 	"strconv"
@@ -13,29 +14,101 @@ const (
 	errCodeMask = 65535
 )
 
-// ErrorCode - error code as defined by Assuan protocol.
+// ErrorCode - error code as defined by Assuan protocol. ErrorCode is an error
+// itself, so errors.Is(err, ErrCanceled) could be used to check code of
+// Error regardless of its source.
 type ErrorCode uint16
 
+// Description returns canonical (libgpg-error) description of error code.
+func (c ErrorCode) Description() string {
+	if d, prs := errorDescriptions[c]; prs {
+		return d
+	}
+	return "Unknown error code"
+}
+
+func (c ErrorCode) Error() string {
+	return c.Description()
+}
+
 // ErrorSource - error source as defined by Assuan protocol.
 type ErrorSource uint8
 
+// Description returns canonical (libgpg-error) name of error source.
+func (s ErrorSource) Description() string {
+	if d, prs := sourceDescriptions[s]; prs {
+		return d
+	}
+	return "Unknown source"
+}
+
 // Error is used to present errors returned by server.
 type Error struct {
 	Src     ErrorSource
 	Code    ErrorCode
 	SrcName string
 	Message string
+	// Err is underlying error, if any. It is not sent to peer.
+	Err error
+}
+
+// NewError creates error with canonical source name and description.
+func NewError(src ErrorSource, code ErrorCode) *Error {
+	return &Error{Src: src, Code: code, SrcName: src.Description(), Message: code.Description()}
+}
+
+// WrapError creates error with canonical source name and description which
+// wraps err, so peer gets proper ERR line while err could still be examined
+// locally with errors.Is and errors.As.
+func WrapError(src ErrorSource, code ErrorCode, err error) *Error {
+	e := NewError(src, code)
+	e.Err = err
+	return e
 }
 
 func (e Error) Error() string {
-	return e.SrcName + ": " + e.Message
+	src, msg := e.SrcName, e.Message
+	if src == "" {
+		src = e.Src.Description()
+	}
+	if msg == "" {
+		msg = e.Code.Description()
+	}
+	if e.Err != nil {
+		return src + ": " + msg + ": " + e.Err.Error()
+	}
+	return src + ": " + msg
+}
+
+// Unwrap returns underlying error.
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether error matches target: ErrorCode matches code of any
+// source, Error (or *Error) matches the same code and source (unknown source
+// in target matches any source).
+func (e Error) Is(target error) bool {
+	switch t := target.(type) {
+	case ErrorCode:
+		return e.Code == t
+	case Error:
+		return e.matches(t)
+	case *Error:
+		return t != nil && e.matches(*t)
+	}
+	return false
+}
+
+func (e Error) matches(t Error) bool {
+	return e.Code == t.Code && (t.Src == ErrSrcUnknown || e.Src == t.Src)
 }
 
 // WriteError converts arbitrary error object to protocol error with Assuan Write Error code.
 func WriteError(err error) *Error {
 	return &Error{
 		Src: ErrSrcAssuan, Code: ErrAssWriteError,
-		SrcName: "assuan", Message: err.Error(),
+		SrcName: "assuan", Message: err.Error(), Err: err,
 	}
 }
 
@@ -43,11 +116,27 @@ func WriteError(err error) *Error {
 func ReadError(err error) *Error {
 	return &Error{
 		Src: ErrSrcAssuan, Code: ErrAssReadError,
-		SrcName: "assuan", Message: err.Error(),
+		SrcName: "assuan", Message: err.Error(), Err: err,
 	}
 }
 
-var errParamsRegex = regexp.MustCompile(`^(\d{1,10}) ([\w ]+)(?:<([\w ]+)>)?$`)
+// ContextError converts error returned by context.Context Err method to
+// protocol error: deadline is reported as ErrTimeout, anything else as
+// ErrCanceled.
+func ContextError(err error) *Error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &Error{
+			Src: ErrSrcAssuan, Code: ErrTimeout,
+			SrcName: "assuan", Message: "operation timed out", Err: err,
+		}
+	}
+	return &Error{
+		Src: ErrSrcAssuan, Code: ErrCanceled,
+		SrcName: "assuan", Message: "operation cancelled", Err: err,
+	}
+}
+
+var errParamsRegex = regexp.MustCompile(`^(\d{1,10})(?:[ \t]+(.*?))?(?:[ \t]*<([^<>]*)>)?[ \t]*$`)
 
 func mapSource(src string) string {
 	// Used for protocol-level errors
@@ -72,17 +161,20 @@ func DecodeErrCmd(params string) error {
 	if groups == nil {
 		return errors.New("malformed ERR arguments")
 	}
-	codeStr, desc := groups[1], strings.TrimSpace(groups[2])
-	src := "unknown source"
-	if len(groups) == 4 {
-		src = mapSource(groups[3])
-	}
+	codeStr, desc, src := groups[1], strings.TrimSpace(groups[2]), mapSource(strings.TrimSpace(groups[3]))
 	code, err := strconv.Atoi(codeStr)
 	if err != nil {
 		return errors.New("malformed ERR arguments (code)")
 	}
 
 	srcCode, errCode := SplitErrCode(code)
+	// Fill in canonical text when peer omitted it.
+	if desc == "" {
+		desc = errCode.Description()
+	}
+	if src == "" {
+		src = srcCode.Description()
+	}
 
 	return Error{Src: srcCode, Code: errCode, SrcName: src, Message: desc}
 }
@@ -1,6 +1,9 @@
 package common_test
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"testing"
 
 	"github.com/rupor-github/win-gpg-agent/assuan/common"
@@ -41,3 +44,79 @@ func TestDecodeErrCmd(t *testing.T) {
 		t.Errorf("Error message mismatch: wanted '%s', got '%s'", "Unknown IPC command", err.Message)
 	}
 }
+
+func TestDecodeErrCmd_Punctuation(t *testing.T) {
+	errI := common.DecodeErrCmd("83886179 Operation cancelled (by user) <Pinentry>")
+	err, ok := errI.(common.Error)
+	if !ok {
+		t.Fatal("Non-common.Error error returned:", errI)
+	}
+	if err.Src != common.ErrSrcPinentry || err.Code != common.ErrCanceled {
+		t.Errorf("Error mismatch: got %d/%d", err.Src, err.Code)
+	}
+	if err.Message != "Operation cancelled (by user)" || err.SrcName != "Pinentry" {
+		t.Errorf("Error text mismatch: got '%s' <%s>", err.Message, err.SrcName)
+	}
+
+	// Canonical text is used when peer sends code only.
+	err = common.DecodeErrCmd("83886179").(common.Error)
+	if err.Message != "Operation cancelled" || err.SrcName != "Pinentry" {
+		t.Errorf("Error text mismatch: got '%s' <%s>", err.Message, err.SrcName)
+	}
+}
+
+func TestErrorDescription(t *testing.T) {
+	if d := common.ErrNoSeckey.Description(); d != "No secret key" {
+		t.Errorf("Description mismatch: got '%s'", d)
+	}
+	if d := common.ErrSrcGPGagent.Description(); d != "GPG Agent" {
+		t.Errorf("Source description mismatch: got '%s'", d)
+	}
+	if d := common.ErrorCode(16000).Description(); d != "Unknown error code" {
+		t.Errorf("Description mismatch: got '%s'", d)
+	}
+
+	err := common.NewError(common.ErrSrcPinentry, common.ErrTimeout)
+	if err.Error() != "Pinentry: Timeout" {
+		t.Errorf("Error text mismatch: got '%s'", err.Error())
+	}
+	if s := (common.Error{Src: common.ErrSrcAssuan, Code: common.ErrAssInvValue}).Error(); s != "Assuan: Invalid value passed to IPC" {
+		t.Errorf("Error text mismatch: got '%s'", s)
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	var err error = common.DecodeErrCmd("83886179 canceled <pinentry>")
+	if !errors.Is(err, common.ErrCanceled) {
+		t.Error("errors.Is does not match error code")
+	}
+	if !errors.Is(fmt.Errorf("GETPIN: %w", err), common.NewError(common.ErrSrcPinentry, common.ErrCanceled)) {
+		t.Error("errors.Is does not match wrapped error")
+	}
+	if errors.Is(err, common.NewError(common.ErrSrcGPGagent, common.ErrCanceled)) {
+		t.Error("errors.Is matches different source")
+	}
+	if !errors.Is(err, common.Error{Code: common.ErrCanceled}) {
+		t.Error("errors.Is does not match unknown source")
+	}
+	if errors.Is(err, common.ErrTimeout) {
+		t.Error("errors.Is matches different code")
+	}
+}
+
+func TestWrapError(t *testing.T) {
+	cause := io.ErrUnexpectedEOF
+	err := common.WrapError(common.ErrSrcAssuan, common.ErrAssReadError, cause)
+	if !errors.Is(err, cause) {
+		t.Error("Underlying error is not found")
+	}
+	if !errors.Is(err, common.ErrAssReadError) {
+		t.Error("errors.Is does not match error code")
+	}
+	if err.Message != "IPC read error" || err.SrcName != "Assuan" {
+		t.Errorf("Error text mismatch: got '%s' <%s>", err.Message, err.SrcName)
+	}
+	if err.Error() != "Assuan: IPC read error: unexpected EOF" {
+		t.Errorf("Error text mismatch: got '%s'", err.Error())
+	}
+}
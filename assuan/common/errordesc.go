@@ -0,0 +1,433 @@
+package common
+
+// Descriptions of error sources and codes, generated with gpg_strsource and
+// gpg_strerror from libgpg-error.
+
+var sourceDescriptions = map[ErrorSource]string{
+	ErrSrcUnknown:  "Unspecified source",
+	ErrSrcGcrypt:   "gcrypt",
+	ErrSrcGPG:      "GnuPG",
+	ErrSrcGPGSM:    "GpgSM",
+	ErrSrcGPGagent: "GPG Agent",
+	ErrSrcPinentry: "Pinentry",
+	ErrSrcSCD:      "SCD",
+	ErrSrcGPGME:    "GPGME",
+	ErrSrcKeybox:   "Keybox",
+	ErrSrcKSBA:     "KSBA",
+	ErrSrcDirmngr:  "Dirmngr",
+	ErrSrcGSTA:     "GSTI",
+	ErrSrcGPA:      "GPA",
+	ErrSrcKleo:     "Kleopatra",
+	ErrSrcG13:      "G13",
+	ErrSrcAssuan:   "Assuan",
+	ErrSrcTLS:      "TLS",
+	ErrSrcAny:      "Any source",
+	ErrSrcUser1:    "User defined source 1",
+	ErrSrcUser2:    "User defined source 2",
+	ErrSrcUser3:    "User defined source 3",
+	ErrSrcUser4:    "User defined source 4",
+}
+
+var errorDescriptions = map[ErrorCode]string{
+	ErrNoError:               "Success",
+	ErrGeneral:               "General error",
+	ErrUnknownPacket:         "Unknown packet",
+	ErrUnknownVersion:        "Unknown version in packet",
+	ErrPubkeyAlgo:            "Invalid public key algorithm",
+	ErrDigestAlgo:            "Invalid digest algorithm",
+	ErrBadPubkey:             "Bad public key",
+	ErrBadSeckey:             "Bad secret key",
+	ErrBadSignature:          "Bad signature",
+	ErrNoPubkey:              "No public key",
+	ErrChecksum:              "Checksum error",
+	ErrBadPassphrase:         "Bad passphrase",
+	ErrCipherAlgo:            "Invalid cipher algorithm",
+	ErrKeyringOpen:           "Cannot open keyring",
+	ErrInvPacket:             "Invalid packet",
+	ErrInvArmor:              "Invalid armor",
+	ErrNoUserID:              "No user ID",
+	ErrNoSeckey:              "No secret key",
+	ErrWrongSeckey:           "Wrong secret key used",
+	ErrBadKey:                "Bad session key",
+	ErrComprAlgo:             "Unknown compression algorithm",
+	ErrNoPrime:               "Number is not prime",
+	ErrNoEncodingMethod:      "Invalid encoding method",
+	ErrNoEncryptionScheme:    "Invalid encryption scheme",
+	ErrNoSignatureScheme:     "Invalid signature scheme",
+	ErrInvAttr:               "Invalid attribute",
+	ErrNoValue:               "No value",
+	ErrNotFound:              "Not found",
+	ErrValueNotFound:         "Value not found",
+	ErrSyntax:                "Syntax error",
+	ErrBadMpi:                "Bad MPI value",
+	ErrInvPassphrase:         "Invalid passphrase",
+	ErrSigClass:              "Invalid signature class",
+	ErrResourceLimit:         "Resources exhausted",
+	ErrInvKeyring:            "Invalid keyring",
+	ErrTrustdb:               "Trust DB error",
+	ErrBadCert:               "Bad certificate",
+	ErrInvUserID:             "Invalid user ID",
+	ErrUnexpected:            "Unexpected error",
+	ErrTimeConflict:          "Time conflict",
+	ErrKeyserver:             "Keyserver error",
+	ErrWrongPubkeyAlgo:       "Wrong public key algorithm",
+	ErrTributeToDA:           "Tribute to D. A.",
+	ErrWeakKey:               "Weak encryption key",
+	ErrInvKeylen:             "Invalid key length",
+	ErrInvArg:                "Invalid argument",
+	ErrBadURI:                "Syntax error in URI",
+	ErrInvURI:                "Invalid URI",
+	ErrNetwork:               "Network error",
+	ErrUnknownHost:           "Unknown host",
+	ErrSelftestFailed:        "Selftest failed",
+	ErrNotEncrypted:          "Data not encrypted",
+	ErrNotProcessed:          "Data not processed",
+	ErrUnusablePubkey:        "Unusable public key",
+	ErrUnusableSeckey:        "Unusable secret key",
+	ErrInvValue:              "Invalid value",
+	ErrBadCertChain:          "Bad certificate chain",
+	ErrMissingCert:           "Missing certificate",
+	ErrNoData:                "No data",
+	ErrBug:                   "Bug",
+	ErrNotSupported:          "Not supported",
+	ErrInvOp:                 "Invalid operation code",
+	ErrTimeout:               "Timeout",
+	ErrInternal:              "Internal error",
+	ErrEOFGcrypt:             "EOF (gcrypt)",
+	ErrInvObj:                "Invalid object",
+	ErrTooShort:              "Provided object is too short",
+	ErrTooLarge:              "Provided object is too large",
+	ErrNoObj:                 "Missing item in object",
+	ErrNotImplemented:        "Not implemented",
+	ErrConflict:              "Conflicting use",
+	ErrInvCipherMode:         "Invalid cipher mode",
+	ErrInvFlag:               "Invalid flag",
+	ErrInvHandle:             "Invalid handle",
+	ErrTruncated:             "Result truncated",
+	ErrIncompleteLine:        "Incomplete line",
+	ErrInvResponse:           "Invalid response",
+	ErrNoAgent:               "No agent running",
+	ErrAgent:                 "Agent error",
+	ErrInvData:               "Invalid data",
+	ErrAssuanServerFault:     "Unspecific Assuan server fault",
+	ErrAssuan:                "General Assuan error",
+	ErrInvSessionKey:         "Invalid session key",
+	ErrInvSexp:               "Invalid S-expression",
+	ErrUnsupportedAlgorithm:  "Unsupported algorithm",
+	ErrNoPinEntry:            "No pinentry",
+	ErrPinEntry:              "pinentry error",
+	ErrBadPIN:                "Bad PIN",
+	ErrInvName:               "Invalid name",
+	ErrBadData:               "Bad data",
+	ErrInvParameter:          "Invalid parameter",
+	ErrWrongCard:             "Wrong card",
+	ErrNoDirmngr:             "No dirmngr",
+	ErrDirmngr:               "dirmngr error",
+	ErrCertRevoked:           "Certificate revoked",
+	ErrNoCrlKnown:            "No CRL known",
+	ErrCrlTooOld:             "CRL too old",
+	ErrLineTooLong:           "Line too long",
+	ErrNotTrusted:            "Not trusted",
+	ErrCanceled:              "Operation cancelled",
+	ErrBadCaCert:             "Bad CA certificate",
+	ErrCertExpired:           "Certificate expired",
+	ErrCertTooYoung:          "Certificate too young",
+	ErrUnsupportedCert:       "Unsupported certificate",
+	ErrUnknownSexp:           "Unknown S-expression",
+	ErrUnsupportedProtection: "Unsupported protection",
+	ErrCorruptedProtection:   "Corrupted protection",
+	ErrAmbiguousName:         "Ambiguous name",
+	ErrCard:                  "Card error",
+	ErrCardReset:             "Card reset required",
+	ErrCardRemoved:           "Card removed",
+	ErrInvCard:               "Invalid card",
+	ErrCardNotPresent:        "Card not present",
+	ErrNoPkcs15App:           "No PKCS15 application",
+	ErrNotConfirmed:          "Not confirmed",
+	ErrConfiguration:         "Configuration error",
+	ErrNoPolicyMatch:         "No policy match",
+	ErrInvIndex:              "Invalid index",
+	ErrInvID:                 "Invalid ID",
+	ErrNoScdaemon:            "No SmartCard daemon",
+	ErrScdaemon:              "SmartCard daemon error",
+	ErrUnsupportedProtocol:   "Unsupported protocol",
+	ErrBadPinMethod:          "Bad PIN method",
+	ErrCardNotInitialized:    "Card not initialized",
+	ErrUnsupportedOperation:  "Unsupported operation",
+	ErrWrongKeyUsage:         "Wrong key usage",
+	ErrNothingFound:          "Nothing found",
+	ErrWrongBlobType:         "Wrong blob type",
+	ErrMissingValue:          "Missing value",
+	ErrHardware:              "Hardware problem",
+	ErrPinBlocked:            "PIN blocked",
+	ErrUseConditions:         "Conditions of use not satisfied",
+	ErrPinNotSynced:          "PINs are not synced",
+	ErrInvCrl:                "Invalid CRL",
+	ErrBadBer:                "BER error",
+	ErrInvBer:                "Invalid BER",
+	ErrElementNotFound:       "Element not found",
+	ErrIdentifierNotFound:    "Identifier not found",
+	ErrInvTag:                "Invalid tag",
+	ErrInvLength:             "Invalid length",
+	ErrInvKeyinfo:            "Invalid key info",
+	ErrUnexpectedTag:         "Unexpected tag",
+	ErrNotDerEncoded:         "Not DER encoded",
+	ErrNoCmsObj:              "No CMS object",
+	ErrInvCmsObj:             "Invalid CMS object",
+	ErrUnknownCmsObj:         "Unknown CMS object",
+	ErrUnsupportedCmsObj:     "Unsupported CMS object",
+	ErrUnsupportedEncoding:   "Unsupported encoding",
+	ErrUnsupportedCmsVersion: "Unsupported CMS version",
+	ErrUnknownAlgorithm:      "Unknown algorithm",
+	ErrInvEngine:             "Invalid crypto engine",
+	ErrPubkeyNotTrusted:      "Public key not trusted",
+	ErrDecryptFailed:         "Decryption failed",
+	ErrKeyExpired:            "Key expired",
+	ErrSigExpired:            "Signature expired",
+	ErrEncodingProblem:       "Encoding problem",
+	ErrInvState:              "Invalid state",
+	ErrDupValue:              "Duplicated value",
+	ErrMissingAction:         "Missing action",
+	ErrModuleNotFound:        "ASN.1 module not found",
+	ErrInvOidString:          "Invalid OID string",
+	ErrInvTime:               "Invalid time",
+	ErrInvCrlObj:             "Invalid CRL object",
+	ErrUnsupportedCrlVersion: "Unsupported CRL version",
+	ErrInvCertObj:            "Invalid certificate object",
+	ErrUnknownName:           "Unknown name",
+	ErrLocaleProblem:         "A locale function failed",
+	ErrNotLocked:             "Not locked",
+	ErrProtocolViolation:     "Protocol violation",
+	ErrInvMac:                "Invalid MAC",
+	ErrInvRequest:            "Invalid request",
+	ErrUnknownExtn:           "Unknown extension",
+	ErrUnknownCritExtn:       "Unknown critical extension",
+	ErrLocked:                "Locked",
+	ErrUnknownOption:         "Unknown option",
+	ErrUnknownCommand:        "Unknown command",
+	ErrNotOperational:        "Not operational",
+	ErrNoPassphrase:          "No passphrase given",
+	ErrNoPin:                 "No PIN given",
+	ErrNotEnabled:            "Not enabled",
+	ErrNoEngine:              "No crypto engine",
+	ErrMissingKey:            "Missing key",
+	ErrTooMany:               "Too many objects",
+	ErrLimitReached:          "Limit reached",
+	ErrNotInitialized:        "Not initialized",
+	ErrMissingIssuerCert:     "Missing issuer certificate",
+	ErrNoKeyserver:           "No keyserver available",
+	ErrInvCurve:              "Invalid elliptic curve",
+	ErrUnknownCurve:          "Unknown elliptic curve",
+	ErrDupKey:                "Duplicated key",
+	ErrAmbiguous:             "Ambiguous result",
+	ErrNoCryptCtx:            "No crypto context",
+	ErrWrongCryptCtx:         "Wrong crypto context",
+	ErrBadCryptCtx:           "Bad crypto context",
+	ErrCryptCtxConflict:      "Conflict in the crypto context",
+	ErrBrokenPubkey:          "Broken public key",
+	ErrBrokenSeckey:          "Broken secret key",
+	ErrMacAlgo:               "Invalid MAC algorithm",
+	ErrFullyCanceled:         "Operation fully cancelled",
+	ErrUnfinished:            "Operation not yet finished",
+	ErrBufferTooShort:        "Buffer too short",
+	ErrSEXPInvLenSpec:        "Invalid length specifier in S-expression",
+	ErrSEXPStringTooLong:     "String too long in S-expression",
+	ErrSEXPUnmatchedParen:    "Unmatched parentheses in S-expression",
+	ErrSEXPNotCanonical:      "S-expression not canonical",
+	ErrSEXPBadCharacter:      "Bad character in S-expression",
+	ErrSEXPBadQuotation:      "Bad quotation in S-expression",
+	ErrSEXPZeroPrefix:        "Zero prefix in S-expression",
+	ErrSEXPNestedDh:          "Nested display hints in S-expression",
+	ErrSEXPUnmatchedDh:       "Unmatched display hints",
+	ErrSEXPUnexpectedPunc:    "Unexpected reserved punctuation in S-expression",
+	ErrSEXPBadHexChar:        "Bad hexadecimal character in S-expression",
+	ErrSEXPOddHexNumbers:     "Odd hexadecimal numbers in S-expression",
+	ErrSEXPBadOctChar:        "Bad octal character in S-expression",
+	ErrSubkeysExpOrRev:       "All subkeys are expired or revoked",
+	ErrDBCorrupted:           "Database is corrupted",
+	ErrServerFailed:          "Server indicated a failure",
+	ErrNoName:                "No name",
+	ErrNoKey:                 "No key",
+	ErrLegacyKey:             "Legacy key",
+	ErrRequestTooShort:       "Request too short",
+	ErrRequestTooLong:        "Request too long",
+	ErrObjTermState:          "Object is in termination state",
+	ErrNoCertChain:           "No certificate chain",
+	ErrCertTooLarge:          "Certificate is too large",
+	ErrInvRecord:             "Invalid record",
+	ErrBadMAC:                "The MAC does not verify",
+	ErrUnexpectedMsg:         "Unexpected message",
+	ErrComprFailed:           "Compression or decompression failed",
+	ErrWouldWrap:             "A counter would wrap",
+	ErrFatalAlert:            "Fatal alert message received",
+	ErrNoCipher:              "No cipher algorithm",
+	ErrMissingClientCert:     "Missing client certificate",
+	ErrCloseNotify:           "Close notification received",
+	ErrTicketExpired:         "Ticket expired",
+	ErrBadTicket:             "Bad ticket",
+	ErrUnknownIdentity:       "Unknown identity",
+	ErrBadHSCert:             "Bad certificate message in handshake",
+	ErrBadHSCertReq:          "Bad certificate request message in handshake",
+	ErrBadHSCertVer:          "Bad certificate verify message in handshake",
+	ErrBadHSChangeCipher:     "Bad change cipher message in handshake",
+	ErrBadHSClientHello:      "Bad client hello message in handshake",
+	ErrBadHSServerHello:      "Bad server hello message in handshake",
+	ErrBadHSServerHelloDone:  "Bad server hello done message in handshake",
+	ErrBadHSFinished:         "Bad finished message in handshake",
+	ErrBadHSServerKex:        "Bad server key exchange message in handshake",
+	ErrBadHSClientKex:        "Bad client key exchange message in handshake",
+	ErrBogusString:           "Bogus string",
+	ErrForbidden:             "Forbidden",
+	ErrKeyDisabled:           "Key disabled",
+	ErrKeyOnCard:             "Not possible with a card based key",
+	ErrInvLockObj:            "Invalid lock object",
+	ErrTrue:                  "True",
+	ErrFalse:                 "False",
+	ErrAssGeneral:            "General IPC error",
+	ErrAssAcceptFailed:       "IPC accept call failed",
+	ErrAssConnectFailed:      "IPC connect call failed",
+	ErrAssInvResponse:        "Invalid IPC response",
+	ErrAssInvValue:           "Invalid value passed to IPC",
+	ErrAssIncompleteLine:     "Incomplete line passed to IPC",
+	ErrAssLineTooLong:        "Line passed to IPC too long",
+	ErrAssNestedCommands:     "Nested IPC commands",
+	ErrAssNoDataCb:           "No data callback in IPC",
+	ErrAssNoInquireCb:        "No inquire callback in IPC",
+	ErrAssNotAServer:         "Not an IPC server",
+	ErrAssNotAClient:         "Not an IPC client",
+	ErrAssServerStart:        "Problem starting IPC server",
+	ErrAssReadError:          "IPC read error",
+	ErrAssWriteError:         "IPC write error",
+	ErrAssTooMuchData:        "Too much data for IPC layer",
+	ErrAssUnexpectedCmd:      "Unexpected IPC command",
+	ErrAssUnknownCmd:         "Unknown IPC command",
+	ErrAssSyntax:             "IPC syntax error",
+	ErrAssCanceled:           "IPC call has been cancelled",
+	ErrAssNoInput:            "No input source for IPC",
+	ErrAssNoOutput:           "No output source for IPC",
+	ErrAssParameter:          "IPC parameter error",
+	ErrAssUnknownInquire:     "Unknown IPC inquire",
+	ErrEngineTooOld:          "Crypto engine too old",
+	ErrWindowTooSmall:        "Screen or window too small",
+	ErrWindowTooLarge:        "Screen or window too large",
+	ErrMissingEnvvar:         "Required environment variable not set",
+	ErrUserIDExists:          "User ID already exists",
+	ErrNameExists:            "Name already exists",
+	ErrDupName:               "Duplicated name",
+	ErrTooYoung:              "Object is too young",
+	ErrTooOld:                "Object is too old",
+	ErrUnknownFlag:           "Unknown flag",
+	ErrInvOrder:              "Invalid execution order",
+	ErrAlreadyFetched:        "Already fetched",
+	ErrTryLater:              "Try again later",
+	ErrWrongName:             "Wrong name",
+	ErrSystemBug:             "System bug detected",
+	ErrDNSUnknown:            "Unknown DNS error",
+	ErrDNSSection:            "Invalid DNS section",
+	ErrDNSAddress:            "Invalid textual address form",
+	ErrDNSNoQuery:            "Missing DNS query packet",
+	ErrDNSNoAnswer:           "Missing DNS answer packet",
+	ErrDNSClosed:             "Connection closed in DNS",
+	ErrDNSVerify:             "Verification failed in DNS",
+	ErrDNSTimeout:            "DNS Timeout",
+	ErrLDAPGeneral:           "General LDAP error",
+	ErrLDAPAttrGeneral:       "General LDAP attribute error",
+	ErrLDAPNameGeneral:       "General LDAP name error",
+	ErrLDAPSecurityGeneral:   "General LDAP security error",
+	ErrLDAPServiceGeneral:    "General LDAP service error",
+	ErrLDAPUpdateGeneral:     "General LDAP update error",
+	ErrLDAPEGeneral:          "Experimental LDAP error code",
+	ErrLDAPXGeneral:          "Private LDAP error code",
+	ErrLDAPOtherGeneral:      "Other general LDAP error",
+	ErrLDAPXConnecting:       "LDAP connecting failed (X)",
+	ErrLDAPReferralLimit:     "LDAP referral limit exceeded",
+	ErrLDAPClientLoop:        "LDAP client loop",
+	ErrLDAPNoResults:         "No LDAP results returned",
+	ErrLDAPControlNotFound:   "LDAP control not found",
+	ErrLDAPNotSupported:      "Not supported by LDAP",
+	ErrLDAPConnect:           "LDAP connect error",
+	ErrLDAPNoMemory:          "Out of memory in LDAP",
+	ErrLDAPParam:             "Bad parameter to an LDAP routine",
+	ErrLDAPUserCancelled:     "User cancelled LDAP operation",
+	ErrLDAPFilter:            "Bad LDAP search filter",
+	ErrLDAPAuthUnknown:       "Unknown LDAP authentication method",
+	ErrLDAPTimeout:           "Timeout in LDAP",
+	ErrLDAPDecoding:          "LDAP decoding error",
+	ErrLDAPEncoding:          "LDAP encoding error",
+	ErrLDAPLocal:             "LDAP local error",
+	ErrLDAPServerDown:        "Cannot contact LDAP server",
+	ErrLDAPSuccess:           "LDAP success",
+	ErrLDAPOperations:        "LDAP operations error",
+	ErrLDAPProtocol:          "LDAP protocol error",
+	ErrLDAPTimelimit:         "Time limit exceeded in LDAP",
+	ErrLDAPSizelimit:         "Size limit exceeded in LDAP",
+	ErrLDAPCompareFalse:      "LDAP compare false",
+	ErrLDAPCompareTrue:       "LDAP compare true",
+	ErrLDAPUnsupportedAuth:   "LDAP authentication method not supported",
+	ErrLDAPStrongAuthRqrd:    "Strong(er) LDAP authentication required",
+	ErrLDAPPartialResults:    "Partial LDAP results+referral received",
+	ErrLDAPReferral:          "LDAP referral",
+	ErrLDAPAdminlimit:        "Administrative LDAP limit exceeded",
+	ErrLDAPUnavailCritExtn:   "Critical LDAP extension is unavailable",
+	ErrLDAPConfidentRqrd:     "Confidentiality required by LDAP",
+	ErrLDAPSaslBindInprog:    "LDAP SASL bind in progress",
+	ErrLDAPNoSuchAttribute:   "No such LDAP attribute",
+	ErrLDAPUndefinedType:     "Undefined LDAP attribute type",
+	ErrLDAPBadMatching:       "Inappropriate matching in LDAP",
+	ErrLDAPConstViolation:    "Constraint violation in LDAP",
+	ErrLDAPTypeValueExists:   "LDAP type or value exists",
+	ErrLDAPInvSyntax:         "Invalid syntax in LDAP",
+	ErrLDAPNoSuchObj:         "No such LDAP object",
+	ErrLDAPAliasProblem:      "LDAP alias problem",
+	ErrLDAPInvDnSyntax:       "Invalid DN syntax in LDAP",
+	ErrLDAPIsLeaf:            "LDAP entry is a leaf",
+	ErrLDAPAliasDeref:        "LDAP alias dereferencing problem",
+	ErrLDAPXProxyAuthFail:    "LDAP proxy authorization failure (X)",
+	ErrLDAPBadAuth:           "Inappropriate LDAP authentication",
+	ErrLDAPInvCredentials:    "Invalid LDAP credentials",
+	ErrLDAPInsufficientAcc:   "Insufficient access for LDAP",
+	ErrLDAPBusy:              "LDAP server is busy",
+	ErrLDAPUnavailable:       "LDAP server is unavailable",
+	ErrLDAPUnwillToPerform:   "LDAP server is unwilling to perform",
+	ErrLDAPLoopDetect:        "Loop detected by LDAP",
+	ErrLDAPNamingViolation:   "LDAP naming violation",
+	ErrLDAPObjClsViolation:   "LDAP object class violation",
+	ErrLDAPNotAllowNonleaf:   "LDAP operation not allowed on non-leaf",
+	ErrLDAPNotAllowOnRdn:     "LDAP operation not allowed on RDN",
+	ErrLDAPAlreadyExists:     "Already exists (LDAP)",
+	ErrLDAPNoObjClassMods:    "Cannot modify LDAP object class",
+	ErrLDAPResultsTooLarge:   "LDAP results too large",
+	ErrLDAPAffectsMultDsas:   "LDAP operation affects multiple DSAs",
+	ErrLDAPVlv:               "Virtual LDAP list view error",
+	ErrLDAPOther:             "Other LDAP error",
+	ErrLDAPCupResourceLimit:  "Resources exhausted in LCUP",
+	ErrLDAPCupSecViolation:   "Security violation in LCUP",
+	ErrLDAPCupInvData:        "Invalid data in LCUP",
+	ErrLDAPCupUnsupScheme:    "Unsupported scheme in LCUP",
+	ErrLDAPCupReload:         "Reload required in LCUP",
+	ErrLDAPCancelled:         "LDAP cancelled",
+	ErrLDAPNoSuchOperation:   "No LDAP operation to cancel",
+	ErrLDAPTooLate:           "Too late to cancel LDAP",
+	ErrLDAPCannotCancel:      "Cannot cancel LDAP",
+	ErrLDAPAssertionFailed:   "LDAP assertion failed",
+	ErrLDAPProxAuthDenied:    "Proxied authorization denied by LDAP",
+	ErrUser1:                 "User defined error code 1",
+	ErrUser2:                 "User defined error code 2",
+	ErrUser3:                 "User defined error code 3",
+	ErrUser4:                 "User defined error code 4",
+	ErrUser5:                 "User defined error code 5",
+	ErrUser6:                 "User defined error code 6",
+	ErrUser7:                 "User defined error code 7",
+	ErrUser8:                 "User defined error code 8",
+	ErrUser9:                 "User defined error code 9",
+	ErrUser10:                "User defined error code 10",
+	ErrUser11:                "User defined error code 11",
+	ErrUser12:                "User defined error code 12",
+	ErrUser13:                "User defined error code 13",
+	ErrUser14:                "User defined error code 14",
+	ErrUser15:                "User defined error code 15",
+	ErrUser16:                "User defined error code 16",
+	ErrMissingErrno:          "System error w/o errno",
+	ErrUnknownErrno:          "Unknown system error",
+	ErrEOF:                   "End of file",
+}
@@ -16,7 +16,7 @@ const (
 	ErrSrcKSBA     ErrorSource = 9
 	ErrSrcDirmngr  ErrorSource = 10
 	ErrSrcGSTA     ErrorSource = 11
-	ErrSrcGPA      ErrorSource = 21
+	ErrSrcGPA      ErrorSource = 12
 	ErrSrcKleo     ErrorSource = 13
 	ErrSrcG13      ErrorSource = 14
 	ErrSrcAssuan   ErrorSource = 15
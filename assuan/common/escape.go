@@ -21,3 +21,77 @@ func UnescapeParameters(encoded string) (string, error) {
 	// Percent-encoding used in Assuan is same as percent-encoding used in path part of URL.
 	return url.PathUnescape(encoded)
 }
+
+// PlusEscape encodes parameter the way GnuPG passes passphrases in inquiries
+// (see percent_plus_escape in GnuPG sources): space becomes + while +, %, :,
+// backslash and control characters are percent-encoded. Result is ready to be
+// sent and must not be escaped again, see Pipe.WriteEncodedLine.
+func PlusEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == ' ':
+			b.WriteByte('+')
+		case c < 0x20 || c == '+' || c == '%' || c == ':' || c == '\\':
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0xf])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// PlusUnescape decodes plus-escaped parameter GnuPG uses for passphrases in
+// inquiries (see percent_plus_escape in GnuPG sources): + is space and
+// percent escapes are decoded. It must be applied to parameters as they were
+// received (see Pipe.ReadRawLine), after percent-decoding literal + could not
+// be told apart from space.
+func PlusUnescape(raw string) (string, error) {
+	return url.PathUnescape(strings.ReplaceAll(raw, "+", " "))
+}
+
+const hexDigits = "0123456789ABCDEF"
+
+// needsEscape reports if byte has to be percent-encoded in data lines, set
+// of bytes matches EscapeParameters.
+func needsEscape(c byte) bool {
+	return c == '\r' || c == '\n' || c == '%' || c == '\\'
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// unescapeData appends decoded payload of single D line to dst. Unlike
+// UnescapeParameters it works on bytes and never interprets anything but
+// percent escapes.
+func unescapeData(dst []byte, encoded string) ([]byte, error) {
+	for i := 0; i < len(encoded); i++ {
+		c := encoded[i]
+		if c != '%' {
+			dst = append(dst, c)
+			continue
+		}
+		if i+2 >= len(encoded) {
+			return dst, Error{Src: ErrSrcAssuan, Code: ErrAssSyntax, SrcName: "assuan", Message: "truncated escape sequence in data"}
+		}
+		hi, ok1 := unhex(encoded[i+1])
+		lo, ok2 := unhex(encoded[i+2])
+		if !ok1 || !ok2 {
+			return dst, Error{Src: ErrSrcAssuan, Code: ErrAssSyntax, SrcName: "assuan", Message: "invalid escape sequence in data"}
+		}
+		dst = append(dst, hi<<4|lo)
+		i += 2
+	}
+	return dst, nil
+}
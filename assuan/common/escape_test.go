@@ -31,3 +31,22 @@ func TestUnescapeParams(t *testing.T) {
 		t.Error("common.UnescapeParameters removes + from output")
 	}
 }
+
+func TestPlusEscape(t *testing.T) {
+	if res := PlusEscape("a+b c%d:\n"); res != "a%2Bb+c%25d%3A%0A" {
+		t.Errorf("a+b c%%d:\\n should be escaped to a%%2Bb+c%%25d%%3A%%0A, got %q", res)
+	}
+	if res, err := PlusUnescape(PlusEscape("a+b c%d\\")); err != nil || res != "a+b c%d\\" {
+		t.Errorf("Round trip failed: %q (%v)", res, err)
+	}
+}
+
+func TestPlusUnescape(t *testing.T) {
+	res, err := PlusUnescape("a%2Bb+c%25d")
+	if err != nil || res != "a+b c%d" {
+		t.Errorf("a%%2Bb+c%%25d should be de-escaped to a+b c%%d, got %q (%v)", res, err)
+	}
+	if _, err := PlusUnescape("bad%2"); err == nil {
+		t.Error("Expected error for truncated escape")
+	}
+}
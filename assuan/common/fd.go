@@ -0,0 +1,66 @@
+package common
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// ErrNoFDPassing is returned when underlying stream can not pass file
+// descriptors.
+var ErrNoFDPassing = errors.New("stream does not support descriptor passing")
+
+// ErrNoFD is returned by ReceiveFD when peer has not passed any descriptor.
+var ErrNoFD = errors.New("no file descriptor received")
+
+// FDPasser is implemented by streams able to pass file descriptors together
+// with data (see FDConn).
+type FDPasser interface {
+	// SendFD passes descriptor to peer, f could be closed after that.
+	SendFD(f *os.File) error
+	// ReceiveFD returns the oldest descriptor received from peer which was
+	// not yet claimed. Caller is responsible for closing it.
+	ReceiveFD() (*os.File, error)
+}
+
+type fdCloser interface {
+	closeFDs()
+}
+
+// WithFDPassing wraps stream so it could pass file descriptors if underlying
+// transport supports it (unix domain sockets), otherwise stream is returned
+// as is.
+func WithFDPassing(stream io.ReadWriter) io.ReadWriter {
+	return withFDPassing(stream)
+}
+
+func (p *Pipe) fdPasser() FDPasser {
+	if fp, ok := p.w.(FDPasser); ok {
+		return fp
+	}
+	if fp, ok := p.r.(FDPasser); ok {
+		return fp
+	}
+	return nil
+}
+
+// SendFD passes file descriptor to peer, it is usually followed by command
+// referring to it (for example "INPUT FD"). ErrNoFDPassing is returned if
+// underlying stream is not FDPasser.
+func (p *Pipe) SendFD(f *os.File) error {
+	fp := p.fdPasser()
+	if fp == nil {
+		return ErrNoFDPassing
+	}
+	return fp.SendFD(f)
+}
+
+// ReceiveFD returns the oldest file descriptor passed by peer and not yet
+// claimed. ErrNoFDPassing is returned if underlying stream is not FDPasser.
+func (p *Pipe) ReceiveFD() (*os.File, error) {
+	fp := p.fdPasser()
+	if fp == nil {
+		return nil, ErrNoFDPassing
+	}
+	return fp.ReceiveFD()
+}
@@ -0,0 +1,123 @@
+//go:build !windows
+// +build !windows
+
+package common
+
+import (
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// maxFDsPerRead limits number of descriptors accepted with single read.
+const maxFDsPerRead = 16
+
+// fdMessage is sent together with descriptor, peers ignore comment lines.
+var fdMessage = []byte("# descriptor\n")
+
+// FDConn is unix socket connection which passes file descriptors
+// (SCM_RIGHTS) the same way libassuan does. Descriptors received with data
+// are queued until claimed with ReceiveFD.
+type FDConn struct {
+	*net.UnixConn
+
+	mu     sync.Mutex
+	fds    []*os.File
+	closed bool
+}
+
+// NewFDConn wraps unix socket connection.
+func NewFDConn(c *net.UnixConn) *FDConn {
+	return &FDConn{UnixConn: c}
+}
+
+func withFDPassing(stream io.ReadWriter) io.ReadWriter {
+	if c, ok := stream.(*net.UnixConn); ok {
+		return NewFDConn(c)
+	}
+	return stream
+}
+
+// Read reads data from connection queuing received descriptors.
+func (c *FDConn) Read(b []byte) (int, error) {
+	oob := make([]byte, syscall.CmsgSpace(maxFDsPerRead*4))
+	n, oobn, _, _, err := c.ReadMsgUnix(b, oob)
+	if oobn != 0 {
+		c.queue(oob[:oobn])
+	}
+	return n, err
+}
+
+func (c *FDConn) queue(oob []byte) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		log.Println("Unable to parse socket control message:", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range msgs {
+		fds, err := syscall.ParseUnixRights(&msgs[i])
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			syscall.CloseOnExec(fd)
+			f := os.NewFile(uintptr(fd), "fd"+strconv.Itoa(fd))
+			if c.closed {
+				f.Close()
+				continue
+			}
+			c.fds = append(c.fds, f)
+		}
+	}
+}
+
+// SendFD passes descriptor to peer with a comment line.
+func (c *FDConn) SendFD(f *os.File) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var werr error
+	if err := rc.Control(func(fd uintptr) {
+		_, _, werr = c.WriteMsgUnix(fdMessage, syscall.UnixRights(int(fd)), nil)
+	}); err != nil {
+		return err
+	}
+	return werr
+}
+
+// ReceiveFD returns the oldest received descriptor which was not claimed yet.
+func (c *FDConn) ReceiveFD() (*os.File, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.fds) == 0 {
+		return nil, ErrNoFD
+	}
+	f := c.fds[0]
+	c.fds = c.fds[1:]
+	return f, nil
+}
+
+// Close closes connection and all unclaimed descriptors.
+func (c *FDConn) Close() error {
+	c.closeFDs()
+	return c.UnixConn.Close()
+}
+
+// closeFDs closes unclaimed descriptors, descriptors received later are
+// closed immediately.
+func (c *FDConn) closeFDs() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range c.fds {
+		f.Close()
+	}
+	c.fds, c.closed = nil, true
+}
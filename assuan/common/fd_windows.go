@@ -0,0 +1,7 @@
+package common
+
+import "io"
+
+func withFDPassing(stream io.ReadWriter) io.ReadWriter {
+	return stream
+}
@@ -2,11 +2,13 @@ package common
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -15,6 +17,9 @@ const (
 	MaxLineLen = 1000
 )
 
+// ErrNoDeadline is returned when underlying stream does not support deadlines.
+var ErrNoDeadline = errors.New("stream does not support deadlines")
+
 // ReadWriter ties arbitrary io.Reader and io.Writer to get a struct that
 // satisfies io.ReadWriter requirements.
 type ReadWriter struct {
@@ -22,30 +27,213 @@ type ReadWriter struct {
 	io.Writer
 }
 
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// SetReadDeadline passes deadline to Reader if it supports deadlines (for
+// example *os.File returned by exec.Cmd.StdoutPipe).
+func (rw ReadWriter) SetReadDeadline(t time.Time) error {
+	if d, ok := rw.Reader.(readDeadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return ErrNoDeadline
+}
+
+// SetWriteDeadline passes deadline to Writer if it supports deadlines.
+func (rw ReadWriter) SetWriteDeadline(t time.Time) error {
+	if d, ok := rw.Writer.(writeDeadliner); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return ErrNoDeadline
+}
+
+// StatusHandler is called for every status line ("S KEYWORD parameters")
+// received from peer. Parameters are already unescaped.
+type StatusHandler func(keyword, params string)
+
 // Pipe is a wrapper for Assuan command stream.
 type Pipe struct {
-	scnr *bufio.Scanner
-	r    io.Reader
-	w    io.Writer
+	lr       *lineReader
+	r        io.Reader
+	w        io.Writer
+	status   map[string]StatusHandler
+	logger   Logger
+	redactor Redactor
+	dl       *deadlines
+}
+
+// deadlines remembers deadlines set on pipe, so they could be restored when
+// context watcher which had to expire them stops.
+type deadlines struct {
+	mu          sync.Mutex
+	read, write time.Time
+	// expired is number of active watchers with done context.
+	expired int
 }
 
 // New crreates and initializes Pipe using biderectional stream.
 func New(stream io.ReadWriter) Pipe {
-	p := Pipe{bufio.NewScanner(stream), stream, stream}
-	p.scnr.Buffer(make([]byte, 0, MaxLineLen), MaxLineLen)
-	return p
+	return Pipe{lr: newLineReader(stream, MaxLineLen), r: stream, w: stream, status: make(map[string]StatusHandler), redactor: NewRedactor(), dl: &deadlines{}}
 }
 
 // NewPipe crreates and initializes Pipe using 2 streams.
 func NewPipe(in io.Reader, out io.Writer) Pipe {
-	p := Pipe{bufio.NewScanner(in), in, out}
-	p.scnr.Buffer(make([]byte, 0, MaxLineLen), MaxLineLen)
-	return p
+	return Pipe{lr: newLineReader(in, MaxLineLen), r: in, w: out, status: make(map[string]StatusHandler), redactor: NewRedactor(), dl: &deadlines{}}
 }
 
-// Close closes Pipe.
+// SetLogger sets logger for pipe diagnostics and transcript, nil disables
+// logging. By default messages up to LogInfo level are written with standard
+// log package.
+func (p *Pipe) SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	p.logger = l
+}
+
+// SetRedactor replaces redaction policy applied to protocol lines before they
+// are logged, nil disables redaction. By default NewRedactor is used.
+func (p *Pipe) SetRedactor(r Redactor) {
+	if r == nil {
+		r = noRedactor{}
+	}
+	p.redactor = r
+}
+
+type noRedactor struct{}
+
+func (noRedactor) Redact(_ bool, line string) string { return line }
+
+// Logf writes message to pipe logger, handlers could use it to keep their
+// diagnostics together with protocol transcript.
+func (p *Pipe) Logf(level LogLevel, format string, args ...interface{}) {
+	l := p.logger
+	if l == nil {
+		l = defaultLogger
+	}
+	l.Logf(level, format, args...)
+}
+
+// trace logs complete protocol line after redaction.
+func (p *Pipe) trace(out bool, line string) {
+	if p.redactor == nil {
+		p.redactor = NewRedactor()
+	}
+	dir := "<"
+	if out {
+		dir = ">"
+	}
+	p.Logf(LogTrace, "%s %s", dir, p.redactor.Redact(out, strings.TrimSuffix(line, "\n")))
+}
+
+// lineReader splits input stream into lines. Unlike bufio.Scanner it could
+// be used after I/O errors (timeouts), so pipe stays usable when deadline
+// expires. Incomplete line read before error is kept and completed by
+// subsequent reads.
+type lineReader struct {
+	rd      *bufio.Reader
+	limit   int
+	partial []byte
+	skip    bool
+}
+
+func newLineReader(r io.Reader, limit int) *lineReader {
+	return &lineReader{rd: bufio.NewReaderSize(r, limit), limit: limit}
+}
+
+func (lr *lineReader) readLine() (string, error) {
+	for {
+		chunk, err := lr.rd.ReadSlice('\n')
+		switch {
+		case lr.skip:
+			// Discarding the rest of the line which was too long.
+			if err == nil {
+				lr.skip = false
+				continue
+			}
+		case len(lr.partial)+len(chunk) > lr.limit:
+			lr.partial = lr.partial[:0]
+			lr.skip = err != nil
+			return "", Error{Src: ErrSrcAssuan, Code: ErrAssLineTooLong, SrcName: "assuan", Message: "line too long"}
+		default:
+			lr.partial = append(lr.partial, chunk...)
+			if err == nil {
+				return lr.take(), nil
+			}
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if errors.Is(err, io.EOF) && len(lr.partial) != 0 {
+			// Last line without line feed.
+			return lr.take(), nil
+		}
+		return "", err
+	}
+}
+
+func (lr *lineReader) take() string {
+	line := strings.TrimSuffix(strings.TrimSuffix(string(lr.partial), "\n"), "\r")
+	lr.partial = lr.partial[:0]
+	return line
+}
+
+// OnStatus registers handler to be called when status line with specified
+// keyword is read from pipe. Empty keyword registers catch-all handler which
+// receives status lines without dedicated handler. Passing nil handler
+// removes previous registration.
+//
+// Status lines never reach callers of ReadLine, so if there is no handler for
+// keyword status line is silently discarded.
+func (p *Pipe) OnStatus(keyword string, handler StatusHandler) {
+	if p.status == nil {
+		p.status = make(map[string]StatusHandler)
+	}
+	keyword = strings.ToUpper(keyword)
+	if handler == nil {
+		delete(p.status, keyword)
+		return
+	}
+	p.status[keyword] = handler
+}
+
+func (p *Pipe) handleStatus(line string) error {
+	keyword, params := line, ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		keyword, params = line[:i], strings.TrimLeft(line[i+1:], " ")
+	}
+	keyword = strings.ToUpper(keyword)
+
+	p.Logf(LogInfo, "< S %s", keyword)
+
+	handler, prs := p.status[keyword]
+	if !prs {
+		if handler, prs = p.status[""]; !prs {
+			return nil
+		}
+	}
+	params, err := UnescapeParameters(params)
+	if err != nil {
+		return err
+	}
+	handler(keyword, params)
+	return nil
+}
+
+// Close closes Pipe. Underlying stream is not closed, but file descriptors
+// received from peer and not claimed with ReceiveFD are.
 func (p *Pipe) Close() error {
-	// Reserved for future use, no-op now.
+	for _, s := range []interface{}{p.w, p.r} {
+		if fc, ok := s.(fdCloser); ok {
+			fc.closeFDs()
+			break
+		}
+	}
 	return nil
 }
 
@@ -59,31 +247,152 @@ func (p *Pipe) Close() error {
 //
 // This function MUST be called before any I/O, otherwise it will panic.
 func (p *Pipe) RestrictInputLen(restrict bool) {
+	if p.lr.rd.Buffered() != 0 || len(p.lr.partial) != 0 {
+		panic("RestrictInputLen called after I/O")
+	}
 	if restrict {
-		p.scnr.Buffer(make([]byte, 0, MaxLineLen), MaxLineLen)
+		p.lr = newLineReader(p.r, MaxLineLen)
 	} else {
-		p.scnr.Buffer([]byte{}, bufio.MaxScanTokenSize)
+		p.lr = newLineReader(p.r, bufio.MaxScanTokenSize)
+	}
+}
+
+// SetReadDeadline sets deadline for future and pending reads from pipe. Zero
+// value of t disables deadline. ErrNoDeadline is returned if underlying
+// stream does not support deadlines. Expired deadline does not break pipe, it
+// could be used again after deadline is moved.
+func (p *Pipe) SetReadDeadline(t time.Time) error {
+	p.dl.mu.Lock()
+	defer p.dl.mu.Unlock()
+	p.dl.read = t
+	return p.setReadDeadline(p.dl.effective(t))
+}
+
+// SetWriteDeadline sets deadline for future and pending writes to pipe, see
+// SetReadDeadline.
+func (p *Pipe) SetWriteDeadline(t time.Time) error {
+	p.dl.mu.Lock()
+	defer p.dl.mu.Unlock()
+	p.dl.write = t
+	return p.setWriteDeadline(p.dl.effective(t))
+}
+
+// SetDeadline sets both read and write deadlines.
+func (p *Pipe) SetDeadline(t time.Time) error {
+	rerr, werr := p.SetReadDeadline(t), p.SetWriteDeadline(t)
+	if rerr != nil {
+		return rerr
+	}
+	return werr
+}
+
+// effective returns deadline to be set on stream: while any watcher context
+// is done pipe I/O must keep failing.
+func (dl *deadlines) effective(t time.Time) time.Time {
+	if dl.expired > 0 {
+		return time.Unix(1, 0)
+	}
+	return t
+}
+
+func (p *Pipe) setReadDeadline(t time.Time) error {
+	if d, ok := p.r.(readDeadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return ErrNoDeadline
+}
+
+func (p *Pipe) setWriteDeadline(t time.Time) error {
+	if d, ok := p.w.(writeDeadliner); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return ErrNoDeadline
+}
+
+// expire makes pipe I/O fail until restore is called.
+func (p *Pipe) expire() {
+	p.dl.mu.Lock()
+	defer p.dl.mu.Unlock()
+	p.dl.expired++
+	_ = p.setReadDeadline(p.dl.effective(p.dl.read))
+	_ = p.setWriteDeadline(p.dl.effective(p.dl.write))
+}
+
+// restore undoes expire, deadlines set with SetDeadline are back in effect
+// when no other watcher keeps pipe expired.
+func (p *Pipe) restore() {
+	p.dl.mu.Lock()
+	defer p.dl.mu.Unlock()
+	p.dl.expired--
+	_ = p.setReadDeadline(p.dl.effective(p.dl.read))
+	_ = p.setWriteDeadline(p.dl.effective(p.dl.write))
+}
+
+// WatchContext makes pending and future pipe I/O fail when context is done,
+// either cancelled or past its deadline. Returned function must be called when
+// operation is finished, it stops watching and restores deadlines set with
+// SetDeadline. Watchers could be nested, pipe I/O keeps failing while any of
+// them has done context. When underlying stream does not support deadlines
+// cancellation has effect only after pending I/O completes.
+func (p *Pipe) WatchContext(ctx context.Context) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	var expired bool
+	done, finished := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case <-ctx.Done():
+			// Unblock any pending I/O.
+			p.expire()
+			expired = true
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+		if expired {
+			p.restore()
+		}
 	}
 }
 
 // ReadLine reads raw request/response in following format: command <parameters>
 //
 // Empty lines and lines starting with # are ignored as specified by protocol.
-// Additionally, status information is silently discarded for now.
+// Status information (S lines) is passed to handlers registered with OnStatus.
 func (p *Pipe) ReadLine() (cmd string, params string, err error) {
+	cmd, params, err = p.ReadRawLine()
+	if err != nil {
+		return "", "", err
+	}
+	if params, err = UnescapeParameters(params); err != nil {
+		return "", "", err
+	}
+	return cmd, params, nil
+}
+
+// ReadRawLine is the same as ReadLine but parameters are returned as they
+// were received, without unescaping.
+func (p *Pipe) ReadRawLine() (cmd string, params string, err error) {
 	var line string
 	for {
-		if ok := p.scnr.Scan(); !ok {
-			err := p.scnr.Err()
-			if err == nil {
-				err = io.EOF
-			}
+		if line, err = p.lr.readLine(); err != nil {
 			return "", "", err
 		}
-		line = p.scnr.Text()
+		p.trace(false, line)
+
+		if strings.HasPrefix(line, "S ") {
+			if err := p.handleStatus(strings.TrimLeft(line[2:], " ")); err != nil {
+				return "", "", err
+			}
+			continue
+		}
 
 		// We got something that looks like a message. Let's parse it.
-		if !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "S ") && len(strings.TrimSpace(line)) != 0 {
+		if !strings.HasPrefix(line, "#") && len(strings.TrimSpace(line)) != 0 {
 			break
 		}
 	}
@@ -96,115 +405,73 @@ func (p *Pipe) ReadLine() (cmd string, params string, err error) {
 		return strings.ToUpper(parts[0]), "", nil
 	}
 
-	log.Println("<", parts[0])
-
-	params, err = UnescapeParameters(parts[1])
-	if err != nil {
-		return "", "", err
-	}
+	p.Logf(LogInfo, "< %s", parts[0])
 
 	// Command is "normalized" to upper case since peer can send
 	// commands in any case.
-	return strings.ToUpper(parts[0]), params, nil
+	return strings.ToUpper(parts[0]), parts[1], nil
 }
 
 // WriteLine writes request/response to pipe.
 // Contents of params is escaped according to requirements of Assuan protocol.
 func (p *Pipe) WriteLine(cmd string, params string) error {
 	if len(cmd)+len(params)+2 > MaxLineLen {
-		log.Println("Refusing to send - command too long")
+		p.Logf(LogError, "Refusing to send - command too long")
 		// 2 is for whitespace after command and LF
 		return errors.New("command or parameters are too log")
 	}
 
-	log.Println(">", cmd)
+	return p.writeLine(cmd, EscapeParameters(params))
+}
+
+// WriteEncodedLine is the same as WriteLine but params are sent as is, they
+// must be already escaped (for example with PlusEscape).
+func (p *Pipe) WriteEncodedLine(cmd string, params string) error {
+	if len(cmd)+len(params)+2 > MaxLineLen {
+		p.Logf(LogError, "Refusing to send - command too long")
+		return errors.New("command or parameters are too log")
+	}
+	return p.writeLine(cmd, params)
+}
+
+func (p *Pipe) writeLine(cmd string, params string) error {
+	p.Logf(LogInfo, "> %s", cmd)
 
 	var line []byte
 	if params != "" {
-		line = []byte(strings.ToUpper(cmd) + " " + EscapeParameters(params) + "\n")
+		line = []byte(strings.ToUpper(cmd) + " " + params + "\n")
 	} else {
 		line = []byte(strings.ToUpper(cmd) + "\n")
 	}
+	p.trace(true, string(line))
 	_, err := p.w.Write(line)
 	return err
 }
 
-func min(a, b int) int {
-	if a <= b {
-		return a
-	}
-	return b
-}
-
 // WriteData sends passed byte slice using one or more D commands.
 // Note: Error may occur even after some data is written so it's better
 // to just CAN transaction after WriteData error.
 func (p *Pipe) WriteData(input []byte) error {
-	encoded := []byte(EscapeParameters(string(input)))
-	chunkLen := MaxLineLen - 3 // 3 is for 'D ' and line feed.
-	for i := 0; i < len(encoded); i += chunkLen {
-		chunk := encoded[i:min(i+chunkLen, len(encoded))]
-		chunk = append([]byte{'D', ' '}, chunk...)
-		chunk = append(chunk, '\n')
-
-		if _, err := p.w.Write(chunk); err != nil {
-			return err
-		}
+	w := p.DataWriter()
+	if _, err := w.Write(input); err != nil {
+		return err
 	}
-	return nil
+	return w.Close()
 }
 
 // WriteDataReader is similar to WriteData but sends data from input Reader
 // until EOF.
 func (p *Pipe) WriteDataReader(input io.Reader) error {
-	chunkLen := MaxLineLen - 3 // 3 is for 'D ' and line feed.
-	buf := make([]byte, chunkLen)
-
-	for {
-		n, err := input.Read(buf)
-		if errors.Is(err, io.EOF) {
-			return nil
-		}
-		if err != nil {
-			return err
-		}
-
-		chunk := []byte(EscapeParameters(string(buf[:n])))
-		chunk = append([]byte{'D', ' '}, chunk...)
-		chunk = append(chunk, '\n')
-		if _, err := p.w.Write(chunk); err != nil {
-			return err
-		}
+	w := p.DataWriter()
+	if _, err := io.Copy(w, input); err != nil {
+		return err
 	}
+	return w.Close()
 }
 
 // ReadData reads sequence of D commands and joins data together.
 func (p *Pipe) ReadData() (data []byte, err error) {
-	for {
-		cmd, chunk, err := p.ReadLine()
-		if err != nil {
-			return nil, err
-		}
-
-		if cmd == "END" {
-			return data, nil
-		}
-
-		if cmd == "CAN" {
-			return nil, Error{Src: ErrSrcAssuan, Code: ErrUnexpected, SrcName: "assuan", Message: "IPC call has been cancelled"}
-		}
-
-		if cmd != "D" {
-			return nil, Error{Src: ErrSrcAssuan, Code: ErrUnexpected, SrcName: "assuan", Message: "unexpected IPC command"}
-		}
-
-		unescaped, err := UnescapeParameters(chunk)
-		if err != nil {
-			return nil, err
-		}
-
-		data = append(data, []byte(unescaped)...)
-	}
+	return io.ReadAll(p.DataReader(0))
 }
 
 // WriteComment is special case of WriteLine. "Command" is # and text is parameter.
@@ -212,7 +479,24 @@ func (p *Pipe) WriteComment(text string) error {
 	return p.WriteLine("#", text)
 }
 
-// WriteError is a special case of WriteLine. It writes command.
+// WriteStatus is special case of WriteLine. It sends status line with
+// specified keyword and parameters: S KEYWORD parameters.
+func (p *Pipe) WriteStatus(keyword, params string) error {
+	if params != "" {
+		return p.WriteLine("S", strings.ToUpper(keyword)+" "+params)
+	}
+	return p.WriteLine("S", strings.ToUpper(keyword))
+}
+
+// WriteError is a special case of WriteLine. It writes command. Empty message
+// and source name are replaced with canonical descriptions.
 func (p *Pipe) WriteError(err Error) error {
-	return p.WriteLine("ERR", fmt.Sprintf("%d %s <%s>", MakeErrCode(err.Src, err.Code), err.Message, err.SrcName))
+	msg, src := err.Message, err.SrcName
+	if msg == "" {
+		msg = err.Code.Description()
+	}
+	if src == "" {
+		src = err.Src.Description()
+	}
+	return p.WriteLine("ERR", fmt.Sprintf("%d %s <%s>", MakeErrCode(err.Src, err.Code), msg, src))
 }
@@ -2,8 +2,13 @@ package common_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rupor-github/win-gpg-agent/assuan/common"
 )
@@ -58,6 +63,58 @@ CMD F!_)`
 			t.Errorf("Params mismatch: wanted %s, got %s", "F!_)", params)
 		}
 	})
+	t.Run("status lines", func(t *testing.T) {
+		sample := `S PROGRESS starting 1 2
+S PINENTRY_LAUNCHED 1234 w32%20pinentry
+S UNHANDLED
+CMD params`
+		rdr := strings.NewReader(sample)
+		pipe := common.NewPipe(rdr, nil)
+		defer pipe.Close()
+
+		var progress, launched []string
+		pipe.OnStatus("progress", func(keyword, params string) {
+			progress = append(progress, keyword+"|"+params)
+		})
+		pipe.OnStatus("PINENTRY_LAUNCHED", func(keyword, params string) {
+			launched = append(launched, keyword+"|"+params)
+		})
+
+		cmd, params, err := pipe.ReadLine()
+		if err != nil {
+			t.Error("Unexpected error on pipe.ReadLine:", err)
+		}
+		if cmd != "CMD" || params != "params" {
+			t.Errorf("Status lines were not skipped: got %s %s", cmd, params)
+		}
+		if len(progress) != 1 || progress[0] != "PROGRESS|starting 1 2" {
+			t.Error("Wrong PROGRESS status received:", progress)
+		}
+		if len(launched) != 1 || launched[0] != "PINENTRY_LAUNCHED|1234 w32 pinentry" {
+			t.Error("Wrong PINENTRY_LAUNCHED status received:", launched)
+		}
+	})
+	t.Run("catch-all status handler", func(t *testing.T) {
+		sample := `S KEY_CREATED B ABCDEF
+S PROGRESS x
+OK`
+		rdr := strings.NewReader(sample)
+		pipe := common.NewPipe(rdr, nil)
+		defer pipe.Close()
+
+		var all []string
+		pipe.OnStatus("", func(keyword, params string) {
+			all = append(all, keyword)
+		})
+		pipe.OnStatus("PROGRESS", func(keyword, params string) {})
+
+		if _, _, err := pipe.ReadLine(); err != nil {
+			t.Error("Unexpected error on pipe.ReadLine:", err)
+		}
+		if len(all) != 1 || all[0] != "KEY_CREATED" {
+			t.Error("Catch-all handler received wrong status lines:", all)
+		}
+	})
 }
 
 func TestPipe_WriteLine(t *testing.T) {
@@ -76,6 +133,23 @@ func TestPipe_WriteLine(t *testing.T) {
 			t.Errorf("pipe.WriteLine wrote incorrect line: '%s'", buf.String())
 		}
 	})
+	t.Run("status line", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		pipe := common.NewPipe(nil, &buf)
+		defer pipe.Close()
+
+		if err := pipe.WriteStatus("pin_repeated", ""); err != nil {
+			t.Error("Unexpected error on pipe.WriteStatus:", err)
+			t.FailNow()
+		}
+		if err := pipe.WriteStatus("PROGRESS", "need_entropy X 30 100"); err != nil {
+			t.Error("Unexpected error on pipe.WriteStatus:", err)
+			t.FailNow()
+		}
+		if buf.String() != "S PIN_REPEATED\nS PROGRESS need_entropy X 30 100\n" {
+			t.Errorf("pipe.WriteStatus wrote incorrect lines: '%s'", buf.String())
+		}
+	})
 	t.Run("too long line", func(t *testing.T) {
 		buf := bytes.Buffer{}
 		pipe := common.NewPipe(nil, &buf)
@@ -141,7 +215,6 @@ func TestPipe_WriteData(t *testing.T) {
 			t.Errorf("pipe.WriteData wrote wrong line: '%s'", buf.String())
 		}
 	})
-	// TODO: Test that wrapping is done correctly and no data is corrupted.
 }
 
 func TestPipe_ReadData(t *testing.T) {
@@ -179,6 +252,23 @@ END
 			t.Error("pipe.ReadData read incorrect data:", string(data))
 		}
 	})
+	t.Run("escaped percent is not unescaped twice", func(t *testing.T) {
+		sample := `D 100%2541
+END
+`
+		pipe := common.NewPipe(strings.NewReader(sample), nil)
+		defer pipe.Close()
+
+		data, err := pipe.ReadData()
+
+		if err != nil {
+			t.Error("Unexpected error on pipe.ReadData:", err)
+			t.FailNow()
+		}
+		if string(data) != "100%41" {
+			t.Error("pipe.ReadData read incorrect data:", string(data))
+		}
+	})
 	t.Run("escaped", func(t *testing.T) {
 		sample := `D %41BCDEF
 END
@@ -197,3 +287,102 @@ END
 		}
 	})
 }
+
+func TestPipe_WatchContext(t *testing.T) {
+	t.Run("pipe is usable after cancelled read", func(t *testing.T) {
+		cl, srv := net.Pipe()
+		defer cl.Close()
+		defer srv.Close()
+
+		pipe := common.NewPipe(srv, srv)
+
+		go func() { _, _ = cl.Write([]byte("CMD par")) }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		stop := pipe.WatchContext(ctx)
+		_, _, err := pipe.ReadLine()
+		stop()
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Error("Expected deadline error, got:", err)
+		}
+
+		go func() { _, _ = cl.Write([]byte("ams\n")) }()
+
+		cmd, params, err := pipe.ReadLine()
+		if err != nil {
+			t.Error("Unexpected error on pipe.ReadLine:", err)
+		}
+		if cmd != "CMD" || params != "params" {
+			t.Errorf("Line mismatch: wanted %s, got %s %s", "CMD params", cmd, params)
+		}
+	})
+
+	t.Run("previous deadline is restored", func(t *testing.T) {
+		cl, srv := net.Pipe()
+		defer cl.Close()
+		defer srv.Close()
+
+		pipe := common.NewPipe(srv, srv)
+		if err := pipe.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+			t.Fatal("Unable to set deadline:", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		stop := pipe.WatchContext(ctx)
+		if _, _, err := pipe.ReadLine(); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Error("Expected deadline error, got:", err)
+		}
+		stop()
+
+		// Caller's deadline still applies, read does not block forever.
+		errc := make(chan error, 1)
+		go func() {
+			_, _, err := pipe.ReadLine()
+			errc <- err
+		}()
+		select {
+		case err := <-errc:
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Error("Expected deadline error, got:", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Deadline was cleared by watcher")
+		}
+	})
+
+	t.Run("nested watcher keeps outer cancellation", func(t *testing.T) {
+		cl, srv := net.Pipe()
+		defer cl.Close()
+		defer srv.Close()
+
+		pipe := common.NewPipe(srv, srv)
+
+		outer, cancel := context.WithCancel(context.Background())
+		stopOuter := pipe.WatchContext(outer)
+		defer stopOuter()
+		inner, cancelInner := context.WithCancel(outer)
+		defer cancelInner()
+		stopInner := pipe.WatchContext(inner)
+		cancel()
+		if _, _, err := pipe.ReadLine(); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Error("Expected deadline error, got:", err)
+		}
+		stopInner()
+
+		errc := make(chan error, 1)
+		go func() {
+			_, _, err := pipe.ReadLine()
+			errc <- err
+		}()
+		select {
+		case err := <-errc:
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Error("Expected deadline error, got:", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Inner watcher cleared outer cancellation")
+		}
+	})
+}
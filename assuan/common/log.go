@@ -0,0 +1,171 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is a verbosity level of pipe logging.
+type LogLevel int
+
+// Log levels, each level includes all previous ones.
+const (
+	// I/O problems and protocol violations.
+	LogError LogLevel = iota
+	// Names of commands sent and received.
+	LogInfo
+	// Additional diagnostics, for example handler state.
+	LogDebug
+	// Full protocol lines (after redaction).
+	LogTrace
+)
+
+// Logger receives pipe diagnostics. Logger could be shared by several pipes
+// so it should be safe for concurrent use.
+type Logger interface {
+	Logf(level LogLevel, format string, args ...interface{})
+}
+
+var defaultLogger = NewStdLogger(LogInfo)
+
+// stdLogger writes messages up to its level using standard log package.
+type stdLogger LogLevel
+
+// NewStdLogger returns Logger which writes messages up to specified level
+// with standard log package. Pipes use NewStdLogger(LogInfo) by default.
+func NewStdLogger(level LogLevel) Logger {
+	return stdLogger(level)
+}
+
+func (l stdLogger) Logf(level LogLevel, format string, args ...interface{}) {
+	if level <= LogLevel(l) {
+		log.Printf(format, args...)
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Logf(LogLevel, string, ...interface{}) {}
+
+// TranscriptLogger writes complete (redacted) conversation with all
+// diagnostics to a writer, one line per message prefixed with time stamp.
+type TranscriptLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewTranscriptLogger creates transcript logger writing to w.
+func NewTranscriptLogger(w io.Writer) *TranscriptLogger {
+	return &TranscriptLogger{w: w}
+}
+
+// Logf implements Logger.
+func (l *TranscriptLogger) Logf(_ LogLevel, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.w, "%s %s\n", time.Now().Format("15:04:05.000000"), strings.TrimSuffix(msg, "\n"))
+}
+
+// Redactor decides how protocol lines appear in logs. Redactors are usually
+// stateful (for example they need to know which command data belongs to), so
+// every pipe needs its own instance.
+type Redactor interface {
+	// Redact returns line as it should be logged. out is true for lines
+	// sent to peer.
+	Redact(out bool, line string) string
+}
+
+const redacted = "[redacted]"
+
+// DefaultRedactor hides secrets known to appear in GnuPG protocols:
+//
+//   - D lines sent in response to commands listed in DataCommands (for
+//     example PIN returned by GETPIN) and parameters of their OK (passphrase
+//     returned by GET_PASSPHRASE without --data);
+//   - D lines answering inquiries listed in Inquiries;
+//   - parameters of inquiries listed in InquiryParams (pinentry sends
+//     passphrase with INQUIRE QUALITY);
+//   - passphrase of PRESET_PASSPHRASE;
+//   - values of environment variables set with OPTION putenv.
+type DefaultRedactor struct {
+	DataCommands  []string
+	Inquiries     []string
+	InquiryParams []string
+
+	cmdSecret bool
+	inquiring bool
+	inqSecret bool
+}
+
+// NewRedactor creates DefaultRedactor with GnuPG defaults.
+func NewRedactor() *DefaultRedactor {
+	return &DefaultRedactor{
+		DataCommands:  []string{"GETPIN", "GET_PASSPHRASE", "PKDECRYPT"},
+		Inquiries:     []string{"PASSPHRASE", "NEEDPIN", "NEW_PASSPHRASE"},
+		InquiryParams: []string{"QUALITY", "CHECKPIN"},
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact implements Redactor.
+func (r *DefaultRedactor) Redact(_ bool, line string) string {
+	cmd, params := line, ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		cmd, params = line[:i], line[i+1:]
+	}
+
+	switch strings.ToUpper(cmd) {
+	case "D":
+		if (r.inquiring && r.inqSecret) || (!r.inquiring && r.cmdSecret) {
+			return "D " + redacted
+		}
+	case "OK", "ERR":
+		secret := r.cmdSecret && len(params) != 0 && strings.ToUpper(cmd) == "OK"
+		r.cmdSecret, r.inquiring, r.inqSecret = false, false, false
+		if secret {
+			return cmd + " " + redacted
+		}
+	case "END", "CAN":
+		r.inquiring, r.inqSecret = false, false
+	case "INQUIRE":
+		keyword := params
+		if i := strings.IndexByte(params, ' '); i >= 0 {
+			keyword = params[:i]
+		}
+		r.inquiring, r.inqSecret = true, containsFold(r.Inquiries, keyword)
+		if keyword != params && containsFold(r.InquiryParams, keyword) {
+			return cmd + " " + keyword + " " + redacted
+		}
+	case "PRESET_PASSPHRASE":
+		// PRESET_PASSPHRASE <keygrip> <timeout> [<hexstring>]
+		if fields := strings.Fields(params); len(fields) > 2 {
+			return cmd + " " + fields[0] + " " + fields[1] + " " + redacted
+		}
+	case "OPTION":
+		// OPTION putenv=<name>=<value>
+		if strings.HasPrefix(strings.ToLower(params), "putenv") {
+			env := strings.TrimLeft(params[len("putenv"):], " =")
+			if i := strings.IndexByte(env, '='); i >= 0 {
+				return cmd + " " + params[:len("putenv")] + "=" + env[:i+1] + redacted
+			}
+		}
+	default:
+		if containsFold(r.DataCommands, cmd) {
+			r.cmdSecret = true
+		}
+	}
+	return line
+}
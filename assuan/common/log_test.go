@@ -0,0 +1,109 @@
+package common_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+)
+
+func TestPipe_Transcript(t *testing.T) {
+	in := strings.NewReader("GETPIN\nOPTION putenv=GPG_TTY=/dev/pts/1\nPRESET_PASSPHRASE grip -1 414243\n")
+	var out, transcript bytes.Buffer
+	pipe := common.NewPipe(in, &out)
+	pipe.SetLogger(common.NewTranscriptLogger(&transcript))
+
+	if cmd, _, err := pipe.ReadLine(); err != nil || cmd != "GETPIN" {
+		t.Fatal("Unexpected ReadLine result:", cmd, err)
+	}
+	if err := pipe.WriteData([]byte("very secret")); err != nil {
+		t.Fatal("Unexpected WriteData error:", err)
+	}
+	if err := pipe.WriteLine("OK", ""); err != nil {
+		t.Fatal("Unexpected WriteLine error:", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := pipe.ReadLine(); err != nil {
+			t.Fatal("Unexpected ReadLine error:", err)
+		}
+	}
+
+	if out.String() != "D very secret\nOK\n" {
+		t.Errorf("Redaction must not affect output: %q", out.String())
+	}
+	for _, s := range []string{
+		"< GETPIN\n",
+		"> D [redacted]\n",
+		"> OK\n",
+		"< OPTION putenv=GPG_TTY=[redacted]\n",
+		"< PRESET_PASSPHRASE grip -1 [redacted]\n",
+	} {
+		if !strings.Contains(transcript.String(), s) {
+			t.Errorf("Transcript does not contain %q:\n%s", s, transcript.String())
+		}
+	}
+	for _, s := range []string{"very secret", "/dev/pts/1", "414243"} {
+		if strings.Contains(transcript.String(), s) {
+			t.Errorf("Transcript contains secret %q:\n%s", s, transcript.String())
+		}
+	}
+}
+
+func TestDefaultRedactor(t *testing.T) {
+	type step struct {
+		out            bool
+		line, expected string
+	}
+	cases := []struct {
+		name  string
+		steps []step
+	}{
+		{"plain data", []step{
+			{true, "GETINFO version", "GETINFO version"},
+			{false, "D 2.2.27", "D 2.2.27"},
+			{false, "OK", "OK"},
+		}},
+		{"get passphrase", []step{
+			{true, "GET_PASSPHRASE --data cache X X X", "GET_PASSPHRASE --data cache X X X"},
+			{false, "D secret", "D [redacted]"},
+			{false, "OK", "OK"},
+			{false, "D public", "D public"},
+		}},
+		{"get passphrase without data", []step{
+			{true, "GET_PASSPHRASE cache X X X", "GET_PASSPHRASE cache X X X"},
+			{false, "OK 736563726574", "OK [redacted]"},
+			{false, "OK Pleased to meet you", "OK Pleased to meet you"},
+		}},
+		{"inquiry inside getpin", []step{
+			{false, "GETPIN", "GETPIN"},
+			{true, "INQUIRE QUALITY secret", "INQUIRE QUALITY [redacted]"},
+			{false, "D 42", "D 42"},
+			{false, "END", "END"},
+			{true, "D secret", "D [redacted]"},
+			{true, "OK", "OK"},
+		}},
+		{"passphrase inquiry", []step{
+			{true, "PRESET_PASSPHRASE grip -1", "PRESET_PASSPHRASE grip -1"},
+			{false, "INQUIRE PASSPHRASE", "INQUIRE PASSPHRASE"},
+			{true, "D secret", "D [redacted]"},
+			{true, "END", "END"},
+			{false, "OK", "OK"},
+		}},
+		{"option", []step{
+			{true, "OPTION putenv=DISPLAY=:0", "OPTION putenv=DISPLAY=[redacted]"},
+			{true, "OPTION putenv=DISPLAY", "OPTION putenv=DISPLAY"},
+			{true, "OPTION ttyname=/dev/tty1", "OPTION ttyname=/dev/tty1"},
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := common.NewRedactor()
+			for _, s := range c.steps {
+				if res := r.Redact(s.out, s.line); res != s.expected {
+					t.Errorf("Redact(%q): wanted %q, got %q", s.line, s.expected, res)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sync"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+)
+
+const cmdWatchKey ctxKey = fdStateKey + 1
+
+// cmdWatch lets peer cancel command being handled. Server keeps reading
+// while handler runs and CAN following command line cancels context passed to
+// handler. Real pinentries close the dialog the same way when gpg-agent gives
+// up.
+//
+// Lines are matched by their numbers, so CAN cancels only command sent right
+// before it, no matter whether handler of that command is already running.
+// CAN sent in reply to INQUIRE is read by handler and cancels inquiry only.
+type cmdWatch struct {
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	canceled bool
+	// cur is number of line with command being handled.
+	cur uint64
+	// last is number of the last line received which is not a reply to
+	// inquiry.
+	last uint64
+	// target is number of line with command which was cancelled before
+	// its handler started.
+	target uint64
+	// inquiring is set while handler waits for reply to INQUIRE.
+	inquiring bool
+}
+
+// watches maps pipes of active sessions to their command watchers, so
+// inquiries could tell peer replies from commands.
+var watches sync.Map
+
+func watchOf(pipe *common.Pipe) *cmdWatch {
+	w, _ := watches.Load(pipe)
+	cw, _ := w.(*cmdWatch)
+	return cw
+}
+
+// inquire must be called before INQUIRE is sent to peer: lines received till
+// END or CAN are reply to it.
+func (w *cmdWatch) inquire() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.inquiring = true
+}
+
+// inquireFailed is called when INQUIRE could not be sent.
+func (w *cmdWatch) inquireFailed() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.inquiring = false
+}
+
+// begin returns context for command read from line n, which is cancelled by
+// CAN from peer until end is called.
+func (w *cmdWatch) begin(ctx context.Context, n uint64) context.Context {
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, cmdWatchKey, w))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.cancel, w.canceled, w.cur = cancel, false, n
+	if w.target == n {
+		log.Println("... CAN received, cancelling command")
+		w.canceled = true
+		cancel()
+	}
+	return ctx
+}
+
+func (w *cmdWatch) end() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cancel != nil {
+		w.cancel()
+		w.cancel = nil
+	}
+}
+
+// line is called by reader for every line received from peer.
+func (w *cmdWatch) line(line []byte, n uint64) {
+	if len(bytes.TrimSpace(line)) == 0 || line[0] == '#' {
+		return
+	}
+	cmd := line
+	if i := bytes.IndexByte(line, ' '); i >= 0 {
+		cmd = line[:i]
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.inquiring {
+		if bytes.EqualFold(cmd, []byte("END")) || bytes.EqualFold(cmd, []byte("CAN")) {
+			w.inquiring = false
+		}
+		return
+	}
+	prev := w.last
+	w.last = n
+	if !bytes.EqualFold(cmd, []byte("CAN")) {
+		return
+	}
+	switch {
+	case prev == w.cur && w.cancel != nil && !w.canceled:
+		log.Println("... CAN received, cancelling command")
+		w.canceled = true
+		w.cancel()
+	case prev > w.cur:
+		// Handler of cancelled command has not started yet.
+		w.target = prev
+	}
+}
+
+// canceledByPeer reports whether command with ctx was cancelled by peer.
+func canceledByPeer(ctx context.Context) bool {
+	w, _ := ctx.Value(cmdWatchKey).(*cmdWatch)
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.canceled
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+)
+
+// Command describes protocol command together with its documentation.
+type Command struct {
+	// Command name, converted to uppercase by Register.
+	Name string
+	// One line usage summary, for example "GETINFO <what>".
+	Synopsis string
+	// Help text, may contain several lines.
+	Help string
+	// Options command understands without leading dashes, reported by
+	// GETINFO cmd_has_option.
+	Options []string
+	Handler CommandHandler
+}
+
+// GetInfoFunc returns value for GETINFO subcommand.
+type GetInfoFunc func(state interface{}) (string, error)
+
+// Register adds commands to protocol definition: handlers are stored in
+// Handlers, help text (synopsis followed by help lines) in Help. Previously
+// registered commands with the same name are replaced.
+func (pi *ProtoInfo) Register(cmds ...Command) {
+	if pi.Handlers == nil {
+		pi.Handlers = make(map[string]CommandHandler)
+	}
+	if pi.Help == nil {
+		pi.Help = make(map[string][]string)
+	}
+	if pi.Commands == nil {
+		pi.Commands = make(map[string]Command)
+	}
+	for _, cmd := range cmds {
+		cmd.Name = strings.ToUpper(cmd.Name)
+		pi.Commands[cmd.Name] = cmd
+		pi.Handlers[cmd.Name] = cmd.Handler
+		pi.Help[cmd.Name] = cmd.helpLines()
+	}
+}
+
+// Clone returns copy of protocol definition which could be changed (for
+// example with Register) without affecting the original.
+func (pi ProtoInfo) Clone() ProtoInfo {
+	if pi.Handlers != nil {
+		handlers := make(map[string]CommandHandler, len(pi.Handlers))
+		for k, v := range pi.Handlers {
+			handlers[k] = v
+		}
+		pi.Handlers = handlers
+	}
+	if pi.Help != nil {
+		help := make(map[string][]string, len(pi.Help))
+		for k, v := range pi.Help {
+			help[k] = v
+		}
+		pi.Help = help
+	}
+	if pi.Commands != nil {
+		commands := make(map[string]Command, len(pi.Commands))
+		for k, v := range pi.Commands {
+			commands[k] = v
+		}
+		pi.Commands = commands
+	}
+	if pi.GetInfo != nil {
+		getInfo := make(map[string]GetInfoFunc, len(pi.GetInfo))
+		for k, v := range pi.GetInfo {
+			getInfo[k] = v
+		}
+		pi.GetInfo = getInfo
+	}
+	pi.Options = append([]string(nil), pi.Options...)
+	pi.RawOptions = append([]string(nil), pi.RawOptions...)
+	pi.Middleware = append([]Middleware(nil), pi.Middleware...)
+	return pi
+}
+
+func (cmd Command) helpLines() []string {
+	var lines []string
+	if cmd.Synopsis != "" {
+		lines = append(lines, cmd.Synopsis)
+	} else {
+		lines = append(lines, cmd.Name)
+	}
+	if cmd.Help != "" {
+		lines = append(lines, "")
+		lines = append(lines, strings.Split(strings.TrimRight(cmd.Help, "\n"), "\n")...)
+	}
+	return lines
+}
+
+// builtinCommands are handled by server itself unless protocol defines
+// handler with the same name.
+var builtinCommands = map[string]Command{
+	"BYE":     {Name: "BYE", Synopsis: "BYE", Help: "Close the connection."},
+	"HELP":    {Name: "HELP", Synopsis: "HELP [<command>]", Help: "List known commands or show help for specified command."},
+	"NOP":     {Name: "NOP", Synopsis: "NOP", Help: "No operation."},
+	"OPTION":  {Name: "OPTION", Synopsis: "OPTION <name> [[=] <value>]", Help: "Set option for this session."},
+	"RESET":   {Name: "RESET", Synopsis: "RESET", Help: "Reset the connection."},
+	"GETINFO": {Name: "GETINFO", Synopsis: "GETINFO <what>", Help: getInfoHelp},
+	"INPUT":   {Name: "INPUT", Synopsis: "INPUT FD[=<n>]", Help: "Set input descriptor, without number descriptor passed by client is used."},
+	"OUTPUT":  {Name: "OUTPUT", Synopsis: "OUTPUT FD[=<n>]", Help: "Set output descriptor, without number descriptor passed by client is used."},
+}
+
+const getInfoHelp = `Multi purpose command to return certain information.
+Supported values of WHAT are:
+
+version     - Return the version of the program.
+pid         - Return the process id of the server.
+cmd_has_option CMD OPT
+            - Return OK if command CMD implements option OPT.`
+
+// helpFor returns help lines for command, registered commands take
+// precedence over built-ins.
+func (pi ProtoInfo) helpFor(cmd string) ([]string, bool) {
+	if lines, prs := pi.Help[cmd]; prs {
+		return lines, true
+	}
+	if _, prs := pi.Handlers[cmd]; prs {
+		return []string{cmd}, true
+	}
+	if c, prs := builtinCommands[cmd]; prs {
+		return c.helpLines(), true
+	}
+	return nil, false
+}
+
+// commandList returns sorted list of all commands with their synopses.
+func (pi ProtoInfo) commandList() []string {
+	names := make(map[string]struct{})
+	for name := range builtinCommands {
+		names[name] = struct{}{}
+	}
+	for name := range pi.Handlers {
+		names[name] = struct{}{}
+	}
+	list := make([]string, 0, len(names))
+	for name := range names {
+		if lines, _ := pi.helpFor(name); len(lines) != 0 && lines[0] != "" {
+			list = append(list, lines[0])
+		} else {
+			list = append(list, name)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// optionAllowed checks OPTION name against ProtoInfo.Options, names ending
+// with * match any option with that prefix.
+func (pi ProtoInfo) optionAllowed(key string) bool {
+	if pi.Options == nil {
+		return true
+	}
+	key = strings.TrimLeft(key, "-")
+	for _, opt := range pi.Options {
+		if strings.HasSuffix(opt, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(opt, "*")) {
+				return true
+			}
+		} else if key == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// rawOption reports whether value of option should be passed to SetOption
+// as it was received.
+func (pi ProtoInfo) rawOption(key string) bool {
+	key = strings.TrimLeft(key, "-")
+	for _, opt := range pi.RawOptions {
+		if key == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// getInfoCmd implements built-in GETINFO command.
+func getInfoCmd(_ context.Context, pipe *common.Pipe, proto ProtoInfo, state interface{}, params string) error {
+	fields := strings.Fields(params)
+	if len(fields) == 0 {
+		return &common.Error{
+			Src: common.ErrSrcAssuan, Code: common.ErrAssParameter,
+			SrcName: "assuan", Message: "missing value for WHAT",
+		}
+	}
+	what := fields[0]
+
+	if f, prs := proto.GetInfo[what]; prs {
+		res, err := f(state)
+		if err != nil {
+			return err
+		}
+		if res == "" {
+			return nil
+		}
+		return pipe.WriteData([]byte(res))
+	}
+
+	switch what {
+	case "version":
+		if proto.Version == "" {
+			break
+		}
+		return pipe.WriteData([]byte(proto.Version))
+	case "pid":
+		return pipe.WriteData([]byte(strconv.Itoa(os.Getpid())))
+	case "cmd_has_option":
+		if len(fields) != 3 {
+			return &common.Error{
+				Src: common.ErrSrcAssuan, Code: common.ErrAssParameter,
+				SrcName: "assuan", Message: "command and option are required",
+			}
+		}
+		cmd, prs := proto.Commands[strings.ToUpper(fields[1])]
+		if !prs {
+			return &common.Error{
+				Src: common.ErrSrcAssuan, Code: common.ErrAssParameter,
+				SrcName: "assuan", Message: "unknown command",
+			}
+		}
+		opt := strings.TrimLeft(fields[2], "-")
+		for _, o := range cmd.Options {
+			if strings.TrimLeft(o, "-") == opt {
+				return nil
+			}
+		}
+		return &common.Error{
+			Src: common.ErrSrcAssuan, Code: common.ErrFalse,
+			SrcName: "assuan", Message: "false",
+		}
+	}
+
+	log.Println("GETINFO unknown parameter value:", what)
+	return &common.Error{
+		Src: common.ErrSrcAssuan, Code: common.ErrAssParameter,
+		SrcName: "assuan", Message: "unknown value for WHAT",
+	}
+}
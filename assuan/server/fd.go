@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+)
+
+// fdState keeps descriptors set by INPUT and OUTPUT commands.
+type fdState struct {
+	pipe *common.Pipe
+	// passing is set when peer passes descriptors over connection.
+	passing bool
+	allow   func(fd uintptr) bool
+	input   fdSlot
+	output  fdSlot
+}
+
+// fdSlot is descriptor set by INPUT or OUTPUT, only descriptors received from
+// peer are owned by session and closed.
+type fdSlot struct {
+	f     *os.File
+	owned bool
+}
+
+func (fs *fdSlot) set(f *os.File, owned bool) {
+	if fs.f != nil && fs.owned {
+		fs.f.Close()
+	}
+	fs.f, fs.owned = f, owned
+}
+
+const fdStateKey ctxKey = cmdNameKey + 1
+
+func fdStateFrom(ctx context.Context) *fdState {
+	st, _ := ctx.Value(fdStateKey).(*fdState)
+	return st
+}
+
+// InputFD returns descriptor set by INPUT command or nil. Descriptor passed by
+// client is closed by RESET and when session ends.
+func InputFD(ctx context.Context) *os.File {
+	if st := fdStateFrom(ctx); st != nil {
+		return st.input.f
+	}
+	return nil
+}
+
+// OutputFD returns descriptor set by OUTPUT command or nil. Descriptor passed
+// by client is closed by RESET and when session ends.
+func OutputFD(ctx context.Context) *os.File {
+	if st := fdStateFrom(ctx); st != nil {
+		return st.output.f
+	}
+	return nil
+}
+
+// ReceiveFD returns the oldest descriptor passed by client (over unix socket)
+// which was not claimed yet, caller is responsible for closing it.
+func ReceiveFD(ctx context.Context) (*os.File, error) {
+	st := fdStateFrom(ctx)
+	if st == nil {
+		return nil, common.ErrNoFDPassing
+	}
+	return st.pipe.ReceiveFD()
+}
+
+func (st *fdState) reset() {
+	if st == nil {
+		return
+	}
+	st.input.set(nil, false)
+	st.output.set(nil, false)
+}
+
+var (
+	inheritedMu sync.Mutex
+	inherited   = make(map[uintptr]*os.File)
+)
+
+// inheritedFile returns file for descriptor allowed by ProtoInfo.AllowFD. It
+// is never closed and stays referenced, so finalizer does not close it
+// either.
+func inheritedFile(fd uintptr) *os.File {
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	f, ok := inherited[fd]
+	if !ok {
+		f = os.NewFile(fd, "fd"+strconv.FormatUint(uint64(fd), 10))
+		inherited[fd] = f
+	}
+	return f
+}
+
+// parseFD handles "FD" (descriptor passed by client) and "FD=<n>" (descriptor
+// number in server process, if allowed) parameters of INPUT and OUTPUT
+// commands. owned is set for descriptors passed by client.
+func (st *fdState) parseFD(params string) (f *os.File, owned bool, err error) {
+	arg := strings.Fields(params)
+	if len(arg) == 0 || !strings.HasPrefix(strings.ToUpper(arg[0]), "FD") {
+		return nil, false, errors.New("FD parameter expected")
+	}
+	switch v := arg[0][2:]; {
+	case v == "":
+		f, err := st.pipe.ReceiveFD()
+		return f, err == nil, err
+	case strings.HasPrefix(v, "="):
+		n, err := strconv.ParseUint(v[1:], 10, 0)
+		if err != nil {
+			return nil, false, errors.New("invalid descriptor number")
+		}
+		if st.passing || st.allow == nil || !st.allow(uintptr(n)) {
+			return nil, false, errors.New("descriptor " + v[1:] + " is not allowed")
+		}
+		return inheritedFile(uintptr(n)), false, nil
+	}
+	return nil, false, errors.New("FD parameter expected")
+}
+
+// fdCmd implements built-in INPUT and OUTPUT commands.
+func fdCmd(ctx context.Context, _ *common.Pipe, _ interface{}, params string) error {
+	cmd := CommandName(ctx)
+	code := common.ErrAssNoInput
+	if cmd == "OUTPUT" {
+		code = common.ErrAssNoOutput
+	}
+
+	st := fdStateFrom(ctx)
+	if st == nil {
+		return &common.Error{
+			Src: common.ErrSrcAssuan, Code: code,
+			SrcName: "assuan", Message: "descriptors are not supported",
+		}
+	}
+	f, owned, err := st.parseFD(params)
+	if err != nil {
+		log.Printf("... %s: %v", cmd, err)
+		return &common.Error{
+			Src: common.ErrSrcAssuan, Code: code,
+			SrcName: "assuan", Message: "no descriptor",
+		}
+	}
+
+	if cmd == "OUTPUT" {
+		st.output.set(f, owned)
+	} else {
+		st.input.set(f, owned)
+	}
+	return nil
+}
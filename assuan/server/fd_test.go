@@ -0,0 +1,197 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/client"
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+)
+
+func TestFDPassing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "S.fd")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal("Unexpected listen error:", err)
+	}
+	defer l.Close()
+
+	proto := ProtoInfo{
+		Handlers: map[string]CommandHandler{
+			"CAT": func(ctx context.Context, _ *common.Pipe, _ interface{}, _ string) error {
+				in, out := InputFD(ctx), OutputFD(ctx)
+				if in == nil || out == nil {
+					return &common.Error{
+						Src: common.ErrSrcAssuan, Code: common.ErrAssNoInput,
+						SrcName: "assuan", Message: "no input or output",
+					}
+				}
+				_, err := io.Copy(out, in)
+				return err
+			},
+			"HELLO": func(_ context.Context, pipe *common.Pipe, _ interface{}, _ string) error {
+				r, w, err := os.Pipe()
+				if err != nil {
+					return err
+				}
+				defer r.Close()
+				_, _ = w.Write([]byte("hello"))
+				w.Close()
+				return pipe.SendFD(r)
+			},
+		},
+		GetDefaultState: func() interface{} { return nil },
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		done <- ServeContext(context.Background(), conn, proto)
+	}()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal("Unexpected dial error:", err)
+	}
+	defer conn.Close()
+	ses, err := client.Init(conn)
+	if err != nil {
+		t.Fatal("Unexpected error on client.Init:", err)
+	}
+	ctx := context.Background()
+
+	if _, err := ses.SimpleCmd("CAT", ""); err == nil {
+		t.Error("Expected error without descriptors")
+	}
+	if _, err := ses.SimpleCmd("INPUT", "FD"); err == nil {
+		t.Error("Expected error for INPUT without passed descriptor")
+	}
+	// Descriptors of server process are never reachable over socket.
+	if _, err := ses.SimpleCmd("INPUT", "FD=2"); err == nil {
+		t.Error("Expected error for INPUT with descriptor number")
+	}
+
+	in, inw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outr, out, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inw.Write([]byte("bulk data")); err != nil {
+		t.Fatal(err)
+	}
+	inw.Close()
+
+	if err := ses.InputFD(ctx, in); err != nil {
+		t.Fatal("Unexpected error on InputFD:", err)
+	}
+	if err := ses.OutputFD(ctx, out); err != nil {
+		t.Fatal("Unexpected error on OutputFD:", err)
+	}
+	in.Close()
+	out.Close()
+	if _, err := ses.SimpleCmd("CAT", ""); err != nil {
+		t.Fatal("Unexpected error on CAT:", err)
+	}
+	// Server keeps output open until RESET.
+	if err := ses.Reset(); err != nil {
+		t.Fatal("Unexpected error on RESET:", err)
+	}
+	if data, err := ioutil.ReadAll(outr); err != nil || string(data) != "bulk data" {
+		t.Errorf("Unexpected output: %q (%v)", data, err)
+	}
+	outr.Close()
+
+	if _, err := ses.SimpleCmd("HELLO", ""); err != nil {
+		t.Fatal("Unexpected error on HELLO:", err)
+	}
+	f, err := ses.ReceiveFD()
+	if err != nil {
+		t.Fatal("Unexpected error on ReceiveFD:", err)
+	}
+	if data, err := ioutil.ReadAll(f); err != nil || string(data) != "hello" {
+		t.Errorf("Unexpected data from received descriptor: %q (%v)", data, err)
+	}
+	f.Close()
+
+	if err := ses.Close(); err != nil {
+		t.Error("Unexpected error on Close:", err)
+	}
+	if err := <-done; err != nil {
+		t.Error("Unexpected serve error:", err)
+	}
+}
+
+func TestFDInherited(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	allowed := w.Fd()
+
+	proto := ProtoInfo{
+		Handlers: map[string]CommandHandler{
+			"HELLO": func(ctx context.Context, _ *common.Pipe, _ interface{}, _ string) error {
+				_, err := OutputFD(ctx).Write([]byte("hello"))
+				return err
+			},
+		},
+		AllowFD:         func(fd uintptr) bool { return fd == allowed },
+		GetDefaultState: func() interface{} { return nil },
+	}
+
+	cl, srv := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		defer srv.Close()
+		done <- ServeContext(context.Background(), srv, proto)
+	}()
+	ses, err := client.Init(cl)
+	if err != nil {
+		t.Fatal("Unexpected error on client.Init:", err)
+	}
+
+	if _, err := ses.SimpleCmd("OUTPUT", "FD=0"); err == nil {
+		t.Error("Expected error for descriptor which is not allowed")
+	}
+	if _, err := ses.SimpleCmd("OUTPUT", "FD="+strconv.Itoa(int(allowed))); err != nil {
+		t.Fatal("Unexpected error on OUTPUT:", err)
+	}
+	if _, err := ses.SimpleCmd("HELLO", ""); err != nil {
+		t.Fatal("Unexpected error on HELLO:", err)
+	}
+	if err := ses.Reset(); err != nil {
+		t.Fatal("Unexpected error on RESET:", err)
+	}
+	// Unbuffered pipe, nobody reads response to BYE.
+	_ = ses.Close()
+	cl.Close()
+	<-done
+
+	// Descriptor still belongs to us.
+	if _, err := w.Write([]byte(" again")); err != nil {
+		t.Fatal("Allowed descriptor was closed:", err)
+	}
+	w.Close()
+	if data, err := ioutil.ReadAll(r); err != nil || string(data) != "hello again" {
+		t.Errorf("Unexpected output: %q (%v)", data, err)
+	}
+}
@@ -1,7 +1,8 @@
 package server
 
 import (
-	"errors"
+	"context"
+	"io"
 	"log"
 
 	"github.com/rupor-github/win-gpg-agent/assuan/common"
@@ -37,23 +38,14 @@ func Inquire(pipe *common.Pipe, keywords []string) (res map[string][]byte, err e
 
 	log.Println("Sending inquire group:", keywords)
 	for _, keyword := range keywords {
-		if err := pipe.WriteLine("INQUIRE", keyword); err != nil {
-			log.Println("... I/O error:", err)
+		rdr, err := InquireReader(pipe, keyword, 0)
+		if err != nil {
 			return nil, err
 		}
 
-		data, err := pipe.ReadData()
+		data, err := io.ReadAll(rdr)
 		if err != nil {
-			var perr *common.Error
-			if ok := errors.As(err, &perr); ok {
-				if err := pipe.WriteError(*perr); err != nil {
-					log.Println("... I/O error:", err)
-					return nil, err
-				}
-				return nil, err
-			}
-
-			log.Println("... I/O error:", err)
+			log.Println("... inquire failed:", err)
 			return nil, err
 		}
 
@@ -61,3 +53,68 @@ func Inquire(pipe *common.Pipe, keywords []string) (res map[string][]byte, err e
 	}
 	return res, nil
 }
+
+// InquireContext is the same as Inquire but waiting for client's data is
+// aborted when ctx is done. In this case *common.Error with ErrCanceled or
+// ErrTimeout code is returned and handler is expected to return it, so client
+// receives ERR.
+func InquireContext(ctx context.Context, pipe *common.Pipe, keywords []string) (res map[string][]byte, err error) {
+	stop := pipe.WatchContext(ctx)
+	res, err = Inquire(pipe, keywords)
+	stop()
+
+	if err != nil && ctx.Err() != nil {
+		return nil, common.ContextError(ctx.Err())
+	}
+	return res, err
+}
+
+// InquireEncoded is the same as InquireContext for single keyword, but args
+// are sent as is. They must be already escaped, for example passphrase
+// encoded with common.PlusEscape.
+func InquireEncoded(ctx context.Context, pipe *common.Pipe, keyword string, args string) ([]byte, error) {
+	stop := pipe.WatchContext(ctx)
+	data, err := inquireEncoded(pipe, keyword, args)
+	stop()
+
+	if err != nil && ctx.Err() != nil {
+		return nil, common.ContextError(ctx.Err())
+	}
+	return data, err
+}
+
+func inquireEncoded(pipe *common.Pipe, keyword string, args string) ([]byte, error) {
+	log.Println("Sending inquire:", keyword)
+	w := watchOf(pipe)
+	w.inquire()
+	if err := pipe.WriteEncodedLine("INQUIRE", common.EscapeParameters(keyword)+" "+args); err != nil {
+		w.inquireFailed()
+		log.Println("... I/O error:", err)
+		return nil, err
+	}
+	data, err := io.ReadAll(pipe.DataReader(0))
+	if err != nil {
+		log.Println("... inquire failed:", err)
+		return nil, err
+	}
+	return data, nil
+}
+
+// InquireReader requests data with specified keyword from client and returns
+// reader to consume client's response as a stream. Read returns io.EOF when
+// client finishes sending data with END. If limit is positive client cannot
+// send more than limit bytes, see common.Pipe.DataReader.
+//
+// Keyword may be followed by parameters separated by space, for example
+// "QUALITY passphrase". Reader must be consumed till the end before any other
+// I/O on the pipe, error handling is the same as for Inquire.
+func InquireReader(pipe *common.Pipe, keyword string, limit int64) (io.Reader, error) {
+	w := watchOf(pipe)
+	w.inquire()
+	if err := pipe.WriteLine("INQUIRE", keyword); err != nil {
+		w.inquireFailed()
+		log.Println("... I/O error:", err)
+		return nil, err
+	}
+	return pipe.DataReader(limit), nil
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+)
+
+// Middleware wraps command handler to run code before and after it. It may
+// also decide not to call next handler at all, in which case returned error
+// is handled the same way as handler error (see CommandHandler doc).
+type Middleware func(next CommandHandler) CommandHandler
+
+type ctxKey int
+
+const cmdNameKey ctxKey = iota
+
+// CommandName returns name of command being handled (in uppercase), it is
+// available from ctx passed to handlers and middleware.
+func CommandName(ctx context.Context) string {
+	name, _ := ctx.Value(cmdNameKey).(string)
+	return name
+}
+
+// chain wraps handler with middleware, first one is outermost.
+func chain(h CommandHandler, mws []Middleware) CommandHandler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// commandSet returns predicate matching commands from the list, empty list
+// matches any command.
+func commandSet(cmds []string) func(string) bool {
+	if len(cmds) == 0 {
+		return func(string) bool { return true }
+	}
+	set := make(map[string]struct{}, len(cmds))
+	for _, cmd := range cmds {
+		set[strings.ToUpper(cmd)] = struct{}{}
+	}
+	return func(cmd string) bool {
+		_, prs := set[cmd]
+		return prs
+	}
+}
+
+// Deny rejects listed commands with ErrForbidden without calling handler.
+func Deny(cmds ...string) Middleware {
+	match := commandSet(cmds)
+	return func(next CommandHandler) CommandHandler {
+		return func(ctx context.Context, pipe *common.Pipe, state interface{}, params string) error {
+			if cmd := CommandName(ctx); match(cmd) {
+				log.Println("... command denied by policy:", cmd)
+				return &common.Error{
+					Src: common.ErrSrcAssuan, Code: common.ErrForbidden,
+					SrcName: "assuan", Message: "forbidden",
+				}
+			}
+			return next(ctx, pipe, state, params)
+		}
+	}
+}
+
+// Audit logs listed commands (or all commands if list is empty) with their
+// outcome. Parameters are not logged as they may contain secrets.
+func Audit(cmds ...string) Middleware {
+	match := commandSet(cmds)
+	return func(next CommandHandler) CommandHandler {
+		return func(ctx context.Context, pipe *common.Pipe, state interface{}, params string) error {
+			cmd := CommandName(ctx)
+			if !match(cmd) {
+				return next(ctx, pipe, state, params)
+			}
+			err := next(ctx, pipe, state, params)
+			var perr *common.Error
+			switch {
+			case err == nil:
+				log.Printf("Audit: %s -> OK", cmd)
+			case errors.As(err, &perr):
+				log.Printf("Audit: %s -> ERR %d %s", cmd, perr.Code, perr.Message)
+			default:
+				log.Printf("Audit: %s -> failed: %v", cmd, err)
+			}
+			return err
+		}
+	}
+}
+
+// Timing reports time spent in handler of every command. If report is nil
+// timing is logged.
+func Timing(report func(cmd string, elapsed time.Duration, err error)) Middleware {
+	if report == nil {
+		report = func(cmd string, elapsed time.Duration, _ error) {
+			log.Printf("Command %s took %v", cmd, elapsed)
+		}
+	}
+	return func(next CommandHandler) CommandHandler {
+		return func(ctx context.Context, pipe *common.Pipe, state interface{}, params string) error {
+			start := time.Now()
+			err := next(ctx, pipe, state, params)
+			report(CommandName(ctx), time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// RateLimit allows at most burst executions of cmd per interval across all
+// sessions sharing protocol definition, excessive requests are rejected with
+// ErrLimitReached. Allowance is replenished gradually (token bucket).
+func RateLimit(cmd string, burst int, interval time.Duration) Middleware {
+	cmd = strings.ToUpper(cmd)
+	rl := &rateLimiter{burst: float64(burst), rate: float64(burst) / float64(interval), tokens: float64(burst)}
+	return func(next CommandHandler) CommandHandler {
+		return func(ctx context.Context, pipe *common.Pipe, state interface{}, params string) error {
+			if CommandName(ctx) == cmd && !rl.allow(time.Now()) {
+				log.Println("... rate limit reached for", cmd)
+				return &common.Error{
+					Src: common.ErrSrcAssuan, Code: common.ErrLimitReached,
+					SrcName: "assuan", Message: "limit reached",
+				}
+			}
+			return next(ctx, pipe, state, params)
+		}
+	}
+}
+
+type rateLimiter struct {
+	mu     sync.Mutex
+	burst  float64
+	rate   float64 // tokens per nanosecond
+	tokens float64
+	last   time.Time
+}
+
+func (rl *rateLimiter) allow(now time.Time) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if !rl.last.IsZero() {
+		rl.tokens += float64(now.Sub(rl.last)) * rl.rate
+		if rl.tokens > rl.burst {
+			rl.tokens = rl.burst
+		}
+	}
+	rl.last = now
+	if rl.tokens < 1 {
+		return false
+	}
+	rl.tokens--
+	return true
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+)
+
+// asyncReader reads from the stream in separate goroutine, so end of stream
+// (peer disconnect) is noticed even while command handler is busy and does not
+// read anything. It also implements read deadlines for streams which do not
+// support them natively (like stdin).
+//
+// Data is passed to Read line by line, so line consumer is working on is
+// known, see lastLine.
+type asyncReader struct {
+	chunks chan chunk
+	quit   chan struct{}
+	buf    []byte
+	line   uint64 // number of line buf belongs to
+	err    error  // valid after chunks is closed
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	expired  chan struct{}
+	onceQuit sync.Once
+}
+
+// chunk is a complete line or a part of it.
+type chunk struct {
+	data []byte
+	line uint64
+}
+
+// newAsyncReader starts reading from r. onLine (if not nil) is called from
+// reader goroutine for every complete line with its number (starting with 1)
+// as soon as it is received, before it is available to Read, so lines could
+// be examined while nobody reads. onEOF is called from reader goroutine when
+// r returns an error (including io.EOF).
+func newAsyncReader(r io.Reader, onLine func(line []byte, n uint64), onEOF func(err error)) *asyncReader {
+	ar := &asyncReader{
+		chunks:  make(chan chunk),
+		quit:    make(chan struct{}),
+		expired: make(chan struct{}),
+	}
+	go func() {
+		defer close(ar.chunks)
+		send := func(c chunk) bool {
+			select {
+			case ar.chunks <- c:
+				return true
+			case <-ar.quit:
+				return false
+			}
+		}
+		ls := lineSplitter{f: onLine, send: send, line: 1}
+		for {
+			buf := make([]byte, 4096)
+			n, err := r.Read(buf)
+			if n > 0 && !ls.write(buf[:n]) {
+				return
+			}
+			if err != nil {
+				// Last line without line feed.
+				if !ls.flush() {
+					return
+				}
+				ar.err = err
+				if onEOF != nil {
+					onEOF(err)
+				}
+				return
+			}
+		}
+	}()
+	return ar
+}
+
+// lineSplitter cuts data into lines and passes them to send, f is called for
+// every complete line before it is sent. Lines too long for the protocol are
+// not examined and sent in parts as they come.
+type lineSplitter struct {
+	f       func(line []byte, n uint64)
+	send    func(c chunk) bool
+	line    uint64
+	partial []byte
+	skip    bool // rest of too long line
+}
+
+// write returns false when reading should stop.
+func (ls *lineSplitter) write(data []byte) bool {
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		line := append(ls.partial, data[:i+1]...)
+		if !ls.skip && ls.f != nil {
+			ls.f(bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'}), ls.line)
+		}
+		if !ls.send(chunk{data: line, line: ls.line}) {
+			return false
+		}
+		ls.partial, ls.skip = nil, false
+		ls.line++
+		data = data[i+1:]
+	}
+	if len(data) == 0 {
+		return true
+	}
+	if !ls.skip && len(ls.partial)+len(data) <= common.MaxLineLen {
+		ls.partial = append(ls.partial, data...)
+		return true
+	}
+	ls.skip = true
+	line := append(ls.partial, data...)
+	ls.partial = nil
+	return ls.send(chunk{data: line, line: ls.line})
+}
+
+// flush sends incomplete line, if any.
+func (ls *lineSplitter) flush() bool {
+	if len(ls.partial) == 0 {
+		return true
+	}
+	line := ls.partial
+	ls.partial = nil
+	return ls.send(chunk{data: line, line: ls.line})
+}
+
+// lastLine returns number of line data most recently returned by Read belongs
+// to. Reader of the pipe never asks for more data while it has complete line
+// buffered, so after command is read this is the number of command line.
+func (ar *asyncReader) lastLine() uint64 {
+	return ar.line
+}
+
+// Read implements io.Reader.
+func (ar *asyncReader) Read(b []byte) (int, error) {
+	if len(ar.buf) == 0 {
+		ar.mu.Lock()
+		expired := ar.expired
+		ar.mu.Unlock()
+
+		select {
+		case <-expired:
+			return 0, os.ErrDeadlineExceeded
+		default:
+		}
+
+		select {
+		case c, ok := <-ar.chunks:
+			if !ok {
+				return 0, ar.err
+			}
+			ar.buf, ar.line = c.data, c.line
+		case <-expired:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+	n := copy(b, ar.buf)
+	ar.buf = ar.buf[n:]
+	return n, nil
+}
+
+// SetReadDeadline implements deadline for pending and future Read calls.
+func (ar *asyncReader) SetReadDeadline(t time.Time) error {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	if ar.timer != nil && !ar.timer.Stop() {
+		// Timer already fired, deadline channel is closed.
+		ar.expired = make(chan struct{})
+	}
+	ar.timer = nil
+
+	select {
+	case <-ar.expired:
+		ar.expired = make(chan struct{})
+	default:
+	}
+
+	if t.IsZero() {
+		return nil
+	}
+	if d := time.Until(t); d > 0 {
+		expired := ar.expired
+		ar.timer = time.AfterFunc(d, func() { close(expired) })
+		return nil
+	}
+	close(ar.expired)
+	return nil
+}
+
+// Close stops reading goroutine as soon as possible. It does not close
+// underlying stream, so goroutine may be blocked in Read until stream is
+// closed by caller.
+func (ar *asyncReader) Close() error {
+	ar.onceQuit.Do(func() { close(ar.quit) })
+	return nil
+}
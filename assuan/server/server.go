@@ -1,24 +1,34 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"io"
 	"log"
 	"net"
 	"os"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/rupor-github/win-gpg-agent/assuan/common"
 )
 
 // CommandHandler is an alias for command handler function type.
 //
+// ctx is cancelled when session ends: peer disconnected or context passed to
+// ServeContext is done. It is also cancelled when peer sends CAN right after
+// command while it is being handled (CAN in reply to INQUIRE cancels inquiry
+// only). Long running handlers should watch it and give up,
+// if handler returns an error which is not *common.Error after peer cancelled
+// command ERR with ErrCanceled code is sent to peer.
+//
 // state object is useful to store arbitrary data between transactions in
 // single connection, it initialized from object returned by ProtoInfo.GetDefaultState.
 //
 // If handler returns *common.Error then this error will be sent to client. Otherwise error will be
 // logged and connection will be terminated.
-type CommandHandler func(pipe *common.Pipe, state interface{}, params string) error
+type CommandHandler func(ctx context.Context, pipe *common.Pipe, state interface{}, params string) error
 
 // ProtoInfo describes how to handle commands sent from client on server.
 // Usually there is only one instance of this structure per protocol (i.e. in global variable).
@@ -29,6 +39,33 @@ type ProtoInfo struct {
 	Handlers map[string]CommandHandler
 	// Help strings for commands, spitted by \n.
 	Help map[string][]string
+	// Commands registered with Register, used by HELP and GETINFO.
+	Commands map[string]Command
+	// Options accepted by OPTION command, names ending with * match any
+	// option with that prefix. If nil all options are passed to SetOption.
+	Options []string
+	// Options which values are passed to SetOption as they were received,
+	// without percent-decoding, so protocol could decode them itself (for
+	// example plus-escaped ones).
+	RawOptions []string
+	// Version reported by built-in GETINFO version.
+	Version string
+	// Additional GETINFO subcommands (or replacements for built-in ones)
+	// used when protocol does not define its own GETINFO handler.
+	GetInfo map[string]GetInfoFunc
+	// Logger for session pipes (see common.Pipe.SetLogger), shared by all
+	// sessions. If nil default logger is used.
+	Logger common.Logger
+	// Middleware wrapping every command dispatched to handler (including
+	// RESET and built-in GETINFO), first one is outermost. Command name is
+	// available with CommandName.
+	Middleware []Middleware
+	// AllowFD reports whether INPUT and OUTPUT FD=<n> may refer to descriptor
+	// n of server process (for example inherited from parent by pipe server).
+	// If nil FD=<n> is rejected. It is always rejected on connections which
+	// pass descriptors (unix sockets). Such descriptors belong to application
+	// and are never closed by server.
+	AllowFD func(fd uintptr) bool
 	// Function that should return newly allocated state object for protocol.
 	GetDefaultState func() interface{}
 	// Function that should set option passed via OPTION command or return an error.
@@ -57,8 +94,68 @@ func splitOption(params string) (key string, val string, err *common.Error) {
 // Serve returns only I/O errors or "other" errors returned by command handlers
 // (see CommandHandler doc).
 func Serve(stream io.ReadWriter, proto ProtoInfo) error {
+	return ServeContext(context.Background(), stream, proto)
+}
+
+// ServeContext is the same as Serve but session is terminated when ctx is
+// done, in which case *common.Error with ErrCanceled or ErrTimeout code is
+// returned. Context passed to command handlers is derived from ctx and is
+// cancelled as soon as peer disconnects.
+//
+// Stream is read by separate goroutine which exits when stream returns an
+// error, so caller should close stream after ServeContext returns.
+func ServeContext(ctx context.Context, stream io.ReadWriter, proto ProtoInfo) error {
+	return serve(ctx, stream, proto, sessionHooks{})
+}
+
+// sessionHooks let Server control session life cycle.
+type sessionHooks struct {
+	// Session is closed if client does not send next command in time.
+	idleTimeout time.Duration
+	// waiting is called with true before reading next command and with false
+	// when command is received. Returning false from waiting(true) ends
+	// session.
+	waiting func(idle bool) bool
+}
+
+func serve(ctx context.Context, stream io.ReadWriter, proto ProtoInfo, hooks sessionHooks) error {
 	log.Println("Accepted session")
-	pipe := common.New(stream)
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	// Unix socket connections can pass file descriptors.
+	stream = common.WithFDPassing(stream)
+	_, passing := stream.(common.FDPasser)
+
+	watch := &cmdWatch{}
+	rdr := newAsyncReader(stream, watch.line, func(err error) {
+		log.Println("Peer disconnected:", err)
+		cancel()
+	})
+	defer rdr.Close()
+	pipe := common.NewPipe(rdr, stream)
+	defer pipe.Close()
+	watches.Store(&pipe, watch)
+	defer watches.Delete(&pipe)
+	if proto.Logger != nil {
+		pipe.SetLogger(proto.Logger)
+	}
+
+	fds := &fdState{pipe: &pipe, passing: passing, allow: proto.AllowFD}
+	defer fds.reset()
+	ctx = context.WithValue(ctx, fdStateKey, fds)
+
+	stop := pipe.WatchContext(ctx)
+	defer stop()
+
+	// Idle timer cancels session context, so pending read fails.
+	var idle *time.Timer
+	if hooks.idleTimeout > 0 {
+		idle = time.AfterFunc(hooks.idleTimeout, cancel)
+		defer idle.Stop()
+	}
 
 	state := proto.GetDefaultState()
 	if err := pipe.WriteLine("OK", proto.Greeting); err != nil {
@@ -67,19 +164,57 @@ func Serve(stream io.ReadWriter, proto ProtoInfo) error {
 	}
 
 	for {
-		cmd, params, err := pipe.ReadLine()
+		if hooks.waiting != nil && !hooks.waiting(true) {
+			log.Println("Server is shutting down, closing session")
+			return nil
+		}
+
+		cmd, raw, err := pipe.ReadRawLine()
+		if idle != nil && !idle.Stop() {
+			log.Println("Idle timeout, dropping session")
+			return common.ContextError(context.DeadlineExceeded)
+		}
+		var params string
+		if err == nil {
+			params, err = common.UnescapeParameters(raw)
+		}
 		if err != nil {
+			if parent.Err() != nil {
+				err = common.ContextError(parent.Err())
+			}
 			log.Println("I/O error, dropping session:", err)
 			return err
 		}
+		if hooks.waiting != nil {
+			hooks.waiting(false)
+		}
+
+		if cmd == "CAN" {
+			// Late cancellation of command already completed, client
+			// does not expect any response.
+			log.Println("Ignoring CAN outside of command")
+			if idle != nil {
+				idle.Reset(hooks.idleTimeout)
+			}
+			continue
+		}
 
-		if err := handleCmd(&pipe, cmd, params, proto, state); err != nil {
+		cmdCtx := context.WithValue(watch.begin(ctx, rdr.lastLine()), rawParamsKey, raw)
+		err = handleCmd(cmdCtx, &pipe, cmd, params, proto, state)
+		watch.end()
+		if err != nil {
 			return err
 		}
+		if cmd == "BYE" {
+			return nil
+		}
+		if idle != nil {
+			idle.Reset(hooks.idleTimeout)
+		}
 	}
 }
 
-func handleCmd(pipe *common.Pipe, cmd string, params string, proto ProtoInfo, state interface{}) error {
+func handleCmd(ctx context.Context, pipe *common.Pipe, cmd string, params string, proto ProtoInfo, state interface{}) error {
 	switch cmd {
 	case "BYE":
 		if err := pipe.WriteLine("OK", ""); err != nil {
@@ -93,7 +228,7 @@ func handleCmd(pipe *common.Pipe, cmd string, params string, proto ProtoInfo, st
 			return err
 		}
 	case "OPTION":
-		if err := optionCmd(pipe, state, proto, params); err != nil {
+		if err := optionCmd(ctx, pipe, state, proto, params); err != nil {
 			log.Println("... IO error, dropping session:", err)
 			return err
 		}
@@ -103,16 +238,25 @@ func handleCmd(pipe *common.Pipe, cmd string, params string, proto ProtoInfo, st
 			return err
 		}
 	case "RESET":
-		if proto.Handlers == nil {
-			proto.Handlers = make(map[string]CommandHandler)
-		}
-		if _, prs := proto.Handlers["RESET"]; !prs {
-			proto.Handlers["RESET"] = defaultResetCmd
-		}
+		fdStateFrom(ctx).reset()
 		fallthrough
 	default:
 		log.Println("Protocol command received:", cmd)
 		hndlr, prs := proto.Handlers[cmd]
+		if !prs {
+			switch cmd {
+			case "GETINFO":
+				hndlr = func(ctx context.Context, pipe *common.Pipe, state interface{}, params string) error {
+					return getInfoCmd(ctx, pipe, proto, state, params)
+				}
+				prs = true
+			case "INPUT", "OUTPUT":
+				hndlr, prs = fdCmd, true
+			case "RESET":
+				// ProtoInfo is shared by sessions, it is never modified.
+				hndlr, prs = defaultResetCmd, true
+			}
+		}
 		if !prs {
 			log.Println("... unknown command:", cmd)
 			if err := pipe.WriteError(common.Error{
@@ -125,11 +269,14 @@ func handleCmd(pipe *common.Pipe, cmd string, params string, proto ProtoInfo, st
 			return nil
 		}
 
-		err := hndlr(pipe, state, params)
+		err := chain(hndlr, proto.Middleware)(context.WithValue(ctx, cmdNameKey, cmd), pipe, state, params)
 		if err != nil {
 			log.Println("... handler error:", err)
 
 			var perr *common.Error
+			if !errors.As(err, &perr) && canceledByPeer(ctx) {
+				err = common.ContextError(context.Canceled)
+			}
 			if ok := errors.As(err, &perr); ok {
 				if err := pipe.WriteError(*perr); err != nil {
 					log.Println("... IO error, dropping session:", err)
@@ -150,52 +297,41 @@ func handleCmd(pipe *common.Pipe, cmd string, params string, proto ProtoInfo, st
 func helpCmd(pipe *common.Pipe, proto ProtoInfo, params string) error {
 	log.Println("Help request")
 
-	if len(params) != 0 {
+	var lines []string
+	if params = strings.ToUpper(strings.TrimSpace(params)); len(params) != 0 {
 		// Help requested for command.
-		helpStrs, prs := proto.Help[params]
+		helpStrs, prs := proto.helpFor(params)
 		if !prs {
 			log.Println("Help requested for unknown command:", params)
-			if err := pipe.WriteError(common.Error{
+			return pipe.WriteError(common.Error{
 				Src: common.ErrSrcAssuan, Code: common.ErrNotFound,
 				SrcName: "assuan", Message: "not found",
-			}); err != nil {
-				return err
-			}
-		} else {
-			for _, helpStr := range helpStrs {
-				if err := pipe.WriteComment(helpStr); err != nil {
-					return err
-				}
-			}
-			if err := pipe.WriteLine("OK", ""); err != nil {
-				return err
-			}
+			})
 		}
+		lines = helpStrs
 	} else {
 		// Just HELP, print commands.
-		for _, cmd := range [8]string{"NOP", "OPTION", "CANCEL", "BYE", "RESET", "END", "HELP"} {
-			if err := pipe.WriteComment(cmd); err != nil {
-				return err
-			}
-		}
-		for k := range proto.Handlers {
-			if err := pipe.WriteComment(k); err != nil {
-				return err
-			}
-		}
-		if err := pipe.WriteLine("OK", ""); err != nil {
+		lines = proto.commandList()
+	}
+
+	for _, line := range lines {
+		if err := pipe.WriteComment(line); err != nil {
 			return err
 		}
 	}
-	return nil
+	return pipe.WriteLine("OK", "")
 }
 
-func defaultResetCmd(pipe *common.Pipe, _ interface{}, _ string) error {
+func defaultResetCmd(_ context.Context, pipe *common.Pipe, _ interface{}, _ string) error {
 	log.Println("Session reset")
 	return nil
 }
 
-func optionCmd(pipe *common.Pipe, state interface{}, proto ProtoInfo, params string) error {
+// rawParamsKey holds parameters of command being handled as they were
+// received.
+const rawParamsKey ctxKey = cmdWatchKey + 1
+
+func optionCmd(ctx context.Context, pipe *common.Pipe, state interface{}, proto ProtoInfo, params string) error {
 	log.Println("Option set request:", params)
 	if proto.SetOption == nil {
 		log.Println("... no options supported in this protocol")
@@ -215,17 +351,26 @@ func optionCmd(pipe *common.Pipe, state interface{}, proto ProtoInfo, params str
 		}
 		return nil
 	}
+	if !proto.optionAllowed(key) {
+		log.Println("... unknown option:", key)
+		return pipe.WriteError(common.Error{
+			Src: common.ErrSrcAssuan, Code: common.ErrUnknownOption,
+			SrcName: "assuan", Message: "unknown option",
+		})
+	}
+	if raw, ok := ctx.Value(rawParamsKey).(string); ok && proto.rawOption(key) {
+		if _, rawValue, serr := splitOption(raw); serr == nil {
+			value = rawValue
+		}
+	}
 	err := proto.SetOption(state, key, value)
 	if err != nil {
 		log.Println("... handler error:", err)
 		var perr *common.Error
 		if ok := errors.As(err, &perr); ok {
-			if err := pipe.WriteError(*perr); err != nil {
-				return err
-			}
-		} else {
-			return err
+			return pipe.WriteError(*perr)
 		}
+		return err
 	}
 	if err := pipe.WriteLine("OK", ""); err != nil {
 		return err
@@ -233,6 +378,18 @@ func optionCmd(pipe *common.Pipe, state interface{}, proto ProtoInfo, params str
 	return nil
 }
 
+// SendStatus sends status line to client. It is supposed to be used from
+// command handlers to report progress or additional information to the peer
+// before command completes, so write errors are converted to *common.Error
+// ready to be returned from handler.
+func SendStatus(pipe *common.Pipe, keyword, params string) *common.Error {
+	if err := pipe.WriteStatus(keyword, params); err != nil {
+		log.Println("... IO error, unable to send status:", err)
+		return common.WriteError(err)
+	}
+	return nil
+}
+
 // ServeStdin is same as Serve but uses stdin and stdout as communication channel.
 func ServeStdin(proto ProtoInfo) error {
 	return Serve(common.ReadWriter{Reader: os.Stdin, Writer: os.Stdout}, proto)
@@ -245,13 +402,14 @@ type Listener interface {
 
 // ServeNet is same as Server but accepts connections (net.Conn) using passed
 // listener and launches goroutine to serve each.
-// This function will return if Accept() fails.
+// This function will return if Accept() fails. See Server for more control
+// over served sessions.
 func ServeNet(listener Listener, proto ProtoInfo) error {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
 			log.Println("Listener fail:", err)
-			continue
+			return err
 		}
 		log.Println("Received remote connection on", conn.LocalAddr(), "from", conn.RemoteAddr())
 		go func() {
@@ -2,10 +2,21 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
 	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/rupor-github/win-gpg-agent/assuan/client"
 	"github.com/rupor-github/win-gpg-agent/assuan/common"
 )
 
@@ -38,12 +49,59 @@ END
 	}
 }
 
+func TestInquireReader(t *testing.T) {
+	sample := `D 100%2541%0A
+END
+`
+	buf := bytes.Buffer{}
+	pipe := common.NewPipe(strings.NewReader(sample), &buf)
+	rdr, err := InquireReader(&pipe, "QUALITY pass%word", 0)
+	if err != nil {
+		t.Error("Unexpected InquireReader error:", err)
+		t.FailNow()
+	}
+	data, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		t.Error("Unexpected read error:", err)
+		t.FailNow()
+	}
+	if buf.String() != "INQUIRE QUALITY pass%25word\n" {
+		t.Error("Mismatched output:", buf.String())
+	}
+	if string(data) != "100%41\n" {
+		t.Errorf("Missing or incorrect data read: %q", data)
+	}
+}
+
+func TestSendStatus(t *testing.T) {
+	buf := bytes.Buffer{}
+	pipe := common.NewPipe(nil, &buf)
+
+	proto := ProtoInfo{}
+	proto.Handlers = make(map[string]CommandHandler)
+	proto.Handlers["CCMD"] = func(_ context.Context, pipe *common.Pipe, _ interface{}, _ string) error {
+		if err := SendStatus(pipe, "PROGRESS", "step 1 2"); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if err := handleCmd(context.Background(), &pipe, "CCMD", "", proto, nil); err != nil {
+		t.Error("Unexpected handleCmd error:", err)
+		t.FailNow()
+	}
+	if buf.String() != "S PROGRESS step 1 2\nOK\n" {
+		t.Error("Mismatched output:")
+		t.Error(buf.String())
+	}
+}
+
 func TestHandleCmd(t *testing.T) {
 	t.Run("BYE cmd", func(t *testing.T) {
 		buf := bytes.Buffer{}
 		pipe := common.NewPipe(nil, &buf)
 
-		if err := handleCmd(&pipe, "BYE", "", ProtoInfo{}, nil); err != nil {
+		if err := handleCmd(context.Background(), &pipe, "BYE", "", ProtoInfo{}, nil); err != nil {
 			t.Error("Unexpected handleCmd error:", err)
 			t.FailNow()
 		}
@@ -57,7 +115,7 @@ func TestHandleCmd(t *testing.T) {
 
 		state := interface{}("foobar")
 
-		if err := handleCmd(&pipe, "RESET", "", ProtoInfo{}, &state); err != nil {
+		if err := handleCmd(context.Background(), &pipe, "RESET", "", ProtoInfo{}, &state); err != nil {
 			t.Error("Unexpected handleCmd error:", err)
 			t.FailNow()
 		}
@@ -65,6 +123,26 @@ func TestHandleCmd(t *testing.T) {
 			t.Error("Response to RESET is not OK:", buf.String())
 		}
 	})
+	t.Run("RESET cmd does not modify shared handlers", func(t *testing.T) {
+		proto := ProtoInfo{Handlers: map[string]CommandHandler{}}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				pipe := common.NewPipe(nil, io.Discard)
+				state := interface{}(nil)
+				if err := handleCmd(context.Background(), &pipe, "RESET", "", proto, &state); err != nil {
+					t.Error("Unexpected handleCmd error:", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if len(proto.Handlers) != 0 {
+			t.Error("RESET registered handler in shared protocol definition")
+		}
+	})
 	t.Run("HELP cmd", helpTest)
 	t.Run("OPTION cmd", optionsTest)
 	t.Run("custom cmd", customCmdTest)
@@ -75,7 +153,7 @@ func helpTest(t *testing.T) {
 		buf := bytes.Buffer{}
 		pipe := common.NewPipe(nil, &buf)
 
-		if err := handleCmd(&pipe, "HELP", "", ProtoInfo{}, nil); err != nil {
+		if err := handleCmd(context.Background(), &pipe, "HELP", "", ProtoInfo{}, nil); err != nil {
 			t.Error("Unexpected handleCmd error:", err)
 			t.FailNow()
 		}
@@ -95,7 +173,7 @@ func helpTest(t *testing.T) {
 		buf := bytes.Buffer{}
 		pipe := common.NewPipe(nil, &buf)
 
-		if err := handleCmd(&pipe, "HELP", "CCMD", ProtoInfo{}, nil); err != nil {
+		if err := handleCmd(context.Background(), &pipe, "HELP", "CCMD", ProtoInfo{}, nil); err != nil {
 			t.Error("Unexpected handleCmd error:", err)
 			t.FailNow()
 		}
@@ -111,7 +189,7 @@ func helpTest(t *testing.T) {
 
 		proto.Help = make(map[string][]string)
 		proto.Help["CCMD"] = []string{"help string"}
-		if err := handleCmd(&pipe, "HELP", "CCMD", proto, nil); err != nil {
+		if err := handleCmd(context.Background(), &pipe, "HELP", "CCMD", proto, nil); err != nil {
 			t.Error("Unexpected handleCmd error:", err)
 			t.FailNow()
 		}
@@ -127,7 +205,7 @@ func customCmdTest(t *testing.T) {
 		buf := bytes.Buffer{}
 		pipe := common.NewPipe(nil, &buf)
 
-		if err := handleCmd(&pipe, "CCMD", "test", ProtoInfo{}, nil); err != nil {
+		if err := handleCmd(context.Background(), &pipe, "CCMD", "test", ProtoInfo{}, nil); err != nil {
 			t.Error("Unexpected handleCmd error:", err)
 			t.FailNow()
 		}
@@ -142,14 +220,14 @@ func customCmdTest(t *testing.T) {
 
 		proto := ProtoInfo{}
 		proto.Handlers = make(map[string]CommandHandler)
-		proto.Handlers["CCMD"] = func(_ *common.Pipe, _ interface{}, _ string) error {
+		proto.Handlers["CCMD"] = func(_ context.Context, _ *common.Pipe, _ interface{}, _ string) error {
 			return &common.Error{
 				Src: common.ErrSrcAssuan, Code: common.ErrAssUnknownCmd,
 				SrcName: "assuan", Message: "TEST ERROR",
 			}
 		}
 
-		if err := handleCmd(&pipe, "CCMD", "", proto, nil); err != nil {
+		if err := handleCmd(context.Background(), &pipe, "CCMD", "", proto, nil); err != nil {
 			t.Error("Unexpected handleCmd error:", err)
 			t.FailNow()
 		}
@@ -165,7 +243,7 @@ func optionsTest(t *testing.T) {
 		buf := bytes.Buffer{}
 		pipe := common.NewPipe(nil, &buf)
 
-		if err := handleCmd(&pipe, "OPTION", "a 2", ProtoInfo{}, nil); err != nil {
+		if err := handleCmd(context.Background(), &pipe, "OPTION", "a 2", ProtoInfo{}, nil); err != nil {
 			t.Error("Unexpected handleCmd error:", err)
 			t.FailNow()
 		}
@@ -186,7 +264,7 @@ func optionsTest(t *testing.T) {
 			}
 		}
 
-		if err := handleCmd(&pipe, "OPTION", "a 2", proto, nil); err != nil {
+		if err := handleCmd(context.Background(), &pipe, "OPTION", "a 2", proto, nil); err != nil {
 			t.Error("Unexpected handleCmd error:", err)
 			t.FailNow()
 		}
@@ -207,7 +285,7 @@ func optionsTest(t *testing.T) {
 			return nil
 		}
 
-		if err := handleCmd(&pipe, "OPTION", "a 2", proto, nil); err != nil {
+		if err := handleCmd(context.Background(), &pipe, "OPTION", "a 2", proto, nil); err != nil {
 			t.Error("Unexpected handleCmd error:", err)
 			t.FailNow()
 		}
@@ -221,3 +299,635 @@ func optionsTest(t *testing.T) {
 		}
 	})
 }
+
+func TestServeContext(t *testing.T) {
+	t.Run("handler context cancelled on disconnect", func(t *testing.T) {
+		cl, srv := net.Pipe()
+		defer srv.Close()
+
+		started, cancelled := make(chan struct{}), make(chan struct{})
+		proto := ProtoInfo{
+			GetDefaultState: func() interface{} { return nil },
+			Handlers: map[string]CommandHandler{
+				"WAIT": func(ctx context.Context, _ *common.Pipe, _ interface{}, _ string) error {
+					close(started)
+					<-ctx.Done()
+					close(cancelled)
+					return ctx.Err()
+				},
+			},
+		}
+
+		done := make(chan error, 1)
+		go func() { done <- Serve(srv, proto) }()
+
+		pipe := common.New(cl)
+		if _, _, err := pipe.ReadLine(); err != nil {
+			t.Error("Unexpected error reading greeting:", err)
+			t.FailNow()
+		}
+		if err := pipe.WriteLine("WAIT", ""); err != nil {
+			t.Error("Unexpected write error:", err)
+			t.FailNow()
+		}
+		<-started
+		cl.Close()
+
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Error("Handler context was not cancelled on disconnect")
+		}
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("Serve did not return")
+		}
+	})
+	t.Run("parent context", func(t *testing.T) {
+		cl, srv := net.Pipe()
+		defer cl.Close()
+		defer srv.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- ServeContext(ctx, srv, ProtoInfo{GetDefaultState: func() interface{} { return nil }})
+		}()
+
+		pipe := common.New(cl)
+		if _, _, err := pipe.ReadLine(); err != nil {
+			t.Error("Unexpected error reading greeting:", err)
+			t.FailNow()
+		}
+		cancel()
+
+		select {
+		case err := <-done:
+			var perr *common.Error
+			if !errors.As(err, &perr) || perr.Code != common.ErrCanceled {
+				t.Error("Expected cancellation error, got:", err)
+			}
+		case <-time.After(time.Second):
+			t.Error("ServeContext did not return after cancellation")
+		}
+	})
+	t.Run("cancelled by peer", func(t *testing.T) {
+		cl, srv := net.Pipe()
+		defer cl.Close()
+		defer srv.Close()
+
+		proto := ProtoInfo{
+			GetDefaultState: func() interface{} { return nil },
+			Handlers: map[string]CommandHandler{
+				"WAIT": func(ctx context.Context, _ *common.Pipe, _ interface{}, _ string) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			},
+		}
+		go Serve(srv, proto)
+
+		pipe := common.New(cl)
+		if _, _, err := pipe.ReadLine(); err != nil {
+			t.Fatal("Unexpected error reading greeting:", err)
+		}
+		if err := pipe.WriteLine("WAIT", ""); err != nil {
+			t.Fatal("Unexpected write error:", err)
+		}
+		if err := pipe.WriteLine("CAN", ""); err != nil {
+			t.Fatal("Unexpected write error:", err)
+		}
+		cmd, params, err := pipe.ReadLine()
+		if err != nil || cmd != "ERR" || !errors.Is(common.DecodeErrCmd(params), common.ErrCanceled) {
+			t.Errorf("Expected ERR Canceled, got: %s %s %v", cmd, params, err)
+		}
+
+		// CAN is consumed, session goes on.
+		if err := pipe.WriteLine("NOP", ""); err != nil {
+			t.Fatal("Unexpected write error:", err)
+		}
+		if cmd, params, err := pipe.ReadLine(); err != nil || cmd != "OK" {
+			t.Errorf("Expected OK, got: %s %s %v", cmd, params, err)
+		}
+	})
+	t.Run("pipelined BYE", func(t *testing.T) {
+		cl, srv := net.Pipe()
+		defer cl.Close()
+		defer srv.Close()
+
+		proto := ProtoInfo{
+			GetDefaultState: func() interface{} { return nil },
+			Handlers: map[string]CommandHandler{
+				"SLOW": func(ctx context.Context, pipe *common.Pipe, _ interface{}, _ string) error {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-time.After(50 * time.Millisecond):
+					}
+					return nil
+				},
+			},
+		}
+		done := make(chan error, 1)
+		go func() { done <- Serve(srv, proto) }()
+
+		pipe := common.New(cl)
+		if _, _, err := pipe.ReadLine(); err != nil {
+			t.Fatal("Unexpected error reading greeting:", err)
+		}
+		// BYE is sent while SLOW is handled, it ends session afterwards.
+		if _, err := cl.Write([]byte("SLOW\nBYE\n")); err != nil {
+			t.Fatal("Unexpected write error:", err)
+		}
+		for _, expected := range []string{"SLOW", "BYE"} {
+			if cmd, params, err := pipe.ReadLine(); err != nil || cmd != "OK" {
+				t.Errorf("%s: expected OK, got: %s %s %v", expected, cmd, params, err)
+			}
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Error("Unexpected Serve error:", err)
+			}
+		case <-time.After(time.Second):
+			t.Error("Serve did not return after BYE")
+		}
+	})
+	t.Run("CAN in reply to inquiry", func(t *testing.T) {
+		cl, srv := net.Pipe()
+		defer cl.Close()
+		defer srv.Close()
+
+		proto := ProtoInfo{
+			GetDefaultState: func() interface{} { return nil },
+			Handlers: map[string]CommandHandler{
+				"ASK": func(ctx context.Context, pipe *common.Pipe, _ interface{}, _ string) error {
+					if _, err := InquireContext(ctx, pipe, []string{"FIRST"}); !errors.Is(err, common.ErrAssCanceled) {
+						return errors.New("first inquiry was not cancelled")
+					}
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					res, err := InquireContext(ctx, pipe, []string{"SECOND"})
+					if err != nil {
+						return err
+					}
+					return pipe.WriteData(res["SECOND"])
+				},
+			},
+		}
+		go Serve(srv, proto)
+
+		pipe := common.New(cl)
+		if _, _, err := pipe.ReadLine(); err != nil {
+			t.Fatal("Unexpected error reading greeting:", err)
+		}
+		if err := pipe.WriteLine("ASK", ""); err != nil {
+			t.Fatal("Unexpected write error:", err)
+		}
+		if cmd, params, err := pipe.ReadLine(); err != nil || cmd != "INQUIRE" || params != "FIRST" {
+			t.Fatal("Expected INQUIRE FIRST, got:", cmd, params, err)
+		}
+		if err := pipe.WriteLine("CAN", ""); err != nil {
+			t.Fatal("Unexpected write error:", err)
+		}
+		if cmd, params, err := pipe.ReadLine(); err != nil || cmd != "INQUIRE" || params != "SECOND" {
+			t.Fatal("Expected INQUIRE SECOND, got:", cmd, params, err)
+		}
+		if err := pipe.WriteData([]byte("answer")); err != nil {
+			t.Fatal("Unexpected write error:", err)
+		}
+		if err := pipe.WriteLine("END", ""); err != nil {
+			t.Fatal("Unexpected write error:", err)
+		}
+		for _, expected := range []string{"D", "OK"} {
+			if cmd, params, err := pipe.ReadLine(); err != nil || cmd != expected {
+				t.Errorf("Expected %s, got: %s %s %v", expected, cmd, params, err)
+			}
+		}
+		if err := pipe.WriteLine("NOP", ""); err != nil {
+			t.Fatal("Unexpected write error:", err)
+		}
+		if cmd, params, err := pipe.ReadLine(); err != nil || cmd != "OK" {
+			t.Errorf("Expected OK, got: %s %s %v", cmd, params, err)
+		}
+	})
+	t.Run("inquire timeout", func(t *testing.T) {
+		cl, srv := net.Pipe()
+		defer cl.Close()
+		defer srv.Close()
+
+		proto := ProtoInfo{
+			GetDefaultState: func() interface{} { return nil },
+			Handlers: map[string]CommandHandler{
+				"ASK": func(ctx context.Context, pipe *common.Pipe, _ interface{}, _ string) error {
+					ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+					defer cancel()
+					_, err := InquireContext(ctx, pipe, []string{"NEVER"})
+					return err
+				},
+			},
+		}
+		go Serve(srv, proto)
+
+		pipe := common.New(cl)
+		if _, _, err := pipe.ReadLine(); err != nil {
+			t.Error("Unexpected error reading greeting:", err)
+			t.FailNow()
+		}
+		if err := pipe.WriteLine("ASK", ""); err != nil {
+			t.Error("Unexpected write error:", err)
+			t.FailNow()
+		}
+		if cmd, params, err := pipe.ReadLine(); err != nil || cmd != "INQUIRE" || params != "NEVER" {
+			t.Error("Expected INQUIRE, got:", cmd, params, err)
+			t.FailNow()
+		}
+		cmd, params, err := pipe.ReadLine()
+		if err != nil || cmd != "ERR" {
+			t.Error("Expected ERR, got:", cmd, params, err)
+			t.FailNow()
+		}
+		var perr common.Error
+		if !errors.As(common.DecodeErrCmd(params), &perr) || perr.Code != common.ErrTimeout {
+			t.Error("Expected timeout error, got:", params)
+		}
+	})
+}
+
+func TestListenAssuanSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "S.test")
+	l, err := ListenAssuanSocket(path)
+	if err != nil {
+		t.Fatal("Unexpected error on ListenAssuanSocket:", err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = Serve(conn, ProtoInfo{GetDefaultState: func() interface{} { return nil }})
+			}()
+		}
+	}()
+
+	t.Run("client round trip", func(t *testing.T) {
+		conn, err := client.Dial(path)
+		if err != nil {
+			t.Fatal("Unexpected error on client.Dial:", err)
+		}
+		defer conn.Close()
+
+		ses, err := client.Init(conn)
+		if err != nil {
+			t.Fatal("Unexpected error on client.Init:", err)
+		}
+		if _, err := ses.SimpleCmd("NOP", ""); err != nil {
+			t.Error("Unexpected error on NOP:", err)
+		}
+		ses.Close()
+	})
+	t.Run("invalid nonce", func(t *testing.T) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal("Unexpected dial error:", err)
+		}
+		defer conn.Close()
+
+		if _, err := conn.Write(make([]byte, 16)); err != nil {
+			t.Fatal("Unexpected write error:", err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 64)); !errors.Is(err, io.EOF) {
+			t.Error("Connection with invalid nonce was not rejected:", err)
+		}
+	})
+	t.Run("silent client does not delay others", func(t *testing.T) {
+		silent, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal("Unexpected dial error:", err)
+		}
+		defer silent.Close()
+
+		start := time.Now()
+		conn, err := client.Dial(path)
+		if err != nil {
+			t.Fatal("Unexpected error on client.Dial:", err)
+		}
+		defer conn.Close()
+		ses, err := client.Init(conn)
+		if err != nil {
+			t.Fatal("Unexpected error on client.Init:", err)
+		}
+		ses.Close()
+		if elapsed := time.Since(start); elapsed >= nonceTimeout/2 {
+			t.Error("Connection was held up by silent client for", elapsed)
+		}
+	})
+	t.Run("close removes socket file", func(t *testing.T) {
+		if err := l.Close(); err != nil {
+			t.Error("Unexpected error on Close:", err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Error("Socket file was not removed:", err)
+		}
+	})
+}
+
+func TestServer(t *testing.T) {
+	listen := func(t *testing.T) net.Listener {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal("Unexpected listen error:", err)
+		}
+		return l
+	}
+	connect := func(t *testing.T, l net.Listener) (net.Conn, *common.Pipe) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal("Unexpected dial error:", err)
+		}
+		pipe := common.New(conn)
+		return conn, &pipe
+	}
+	expect := func(t *testing.T, pipe *common.Pipe, expected string) {
+		t.Helper()
+		cmd, _, err := pipe.ReadLine()
+		if err != nil || cmd != expected {
+			t.Fatalf("Expected %s, got: %s %v", expected, cmd, err)
+		}
+	}
+
+	t.Run("shutdown drains sessions", func(t *testing.T) {
+		started, release := make(chan struct{}), make(chan struct{})
+		srv := &Server{Proto: ProtoInfo{
+			GetDefaultState: func() interface{} { return nil },
+			Handlers: map[string]CommandHandler{
+				"WORK": func(context.Context, *common.Pipe, interface{}, string) error {
+					close(started)
+					<-release
+					return nil
+				},
+			},
+		}}
+		l := listen(t)
+		served := make(chan error, 1)
+		go func() { served <- srv.Serve(context.Background(), l) }()
+
+		busy, busyPipe := connect(t, l)
+		defer busy.Close()
+		expect(t, busyPipe, "OK")
+		idle, idlePipe := connect(t, l)
+		defer idle.Close()
+		expect(t, idlePipe, "OK")
+
+		if err := busyPipe.WriteLine("WORK", ""); err != nil {
+			t.Fatal("Unexpected write error:", err)
+		}
+		<-started
+
+		shutdown := make(chan error, 1)
+		go func() { shutdown <- srv.Shutdown(context.Background()) }()
+
+		if _, _, err := idlePipe.ReadLine(); !errors.Is(err, io.EOF) {
+			t.Error("Idle session was not closed:", err)
+		}
+		if err := <-served; !errors.Is(err, ErrServerClosed) {
+			t.Error("Expected ErrServerClosed, got:", err)
+		}
+		select {
+		case err := <-shutdown:
+			t.Fatal("Shutdown returned before busy session finished:", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		expect(t, busyPipe, "OK")
+		if _, _, err := busyPipe.ReadLine(); !errors.Is(err, io.EOF) {
+			t.Error("Busy session was not closed after command:", err)
+		}
+		if err := <-shutdown; err != nil {
+			t.Error("Unexpected error on Shutdown:", err)
+		}
+	})
+	t.Run("shutdown deadline", func(t *testing.T) {
+		started, cancelled := make(chan struct{}), make(chan struct{})
+		srv := &Server{Proto: ProtoInfo{
+			GetDefaultState: func() interface{} { return nil },
+			Handlers: map[string]CommandHandler{
+				"WORK": func(ctx context.Context, _ *common.Pipe, _ interface{}, _ string) error {
+					close(started)
+					<-ctx.Done()
+					close(cancelled)
+					return ctx.Err()
+				},
+			},
+		}}
+		l := listen(t)
+		go func() { _ = srv.Serve(context.Background(), l) }()
+
+		conn, pipe := connect(t, l)
+		defer conn.Close()
+		expect(t, pipe, "OK")
+		if err := pipe.WriteLine("WORK", ""); err != nil {
+			t.Fatal("Unexpected write error:", err)
+		}
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Error("Expected deadline error, got:", err)
+		}
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Error("Handler context was not cancelled")
+		}
+	})
+	t.Run("session limit", func(t *testing.T) {
+		srv := &Server{Proto: ProtoInfo{GetDefaultState: func() interface{} { return nil }}, MaxSessions: 1}
+		l := listen(t)
+		go func() { _ = srv.Serve(context.Background(), l) }()
+		defer srv.Close()
+
+		first, firstPipe := connect(t, l)
+		expect(t, firstPipe, "OK")
+
+		second, secondPipe := connect(t, l)
+		defer second.Close()
+		_ = second.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if _, _, err := secondPipe.ReadLine(); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Error("Second session should not be served:", err)
+		}
+		_ = second.SetReadDeadline(time.Time{})
+
+		first.Close()
+		expect(t, secondPipe, "OK")
+	})
+	t.Run("idle timeout and hooks", func(t *testing.T) {
+		closed := make(chan error, 1)
+		var rejected int32
+		srv := &Server{
+			Proto:       ProtoInfo{GetDefaultState: func() interface{} { return nil }},
+			IdleTimeout: 30 * time.Millisecond,
+			OnAccept: func(net.Conn) error {
+				if atomic.AddInt32(&rejected, 1) == 1 {
+					return errors.New("first connection is rejected")
+				}
+				return nil
+			},
+			OnClose: func(_ net.Conn, err error) { closed <- err },
+		}
+		l := listen(t)
+		go func() { _ = srv.Serve(context.Background(), l) }()
+		defer srv.Close()
+
+		conn, pipe := connect(t, l)
+		if _, _, err := pipe.ReadLine(); !errors.Is(err, io.EOF) {
+			t.Error("Rejected connection was not closed:", err)
+		}
+		conn.Close()
+
+		conn, pipe = connect(t, l)
+		defer conn.Close()
+		expect(t, pipe, "OK")
+		if err := pipe.WriteLine("NOP", ""); err != nil {
+			t.Fatal("Unexpected write error:", err)
+		}
+		expect(t, pipe, "OK")
+		if _, _, err := pipe.ReadLine(); !errors.Is(err, io.EOF) {
+			t.Error("Idle session was not closed:", err)
+		}
+
+		var perr *common.Error
+		if err := <-closed; !errors.As(err, &perr) || perr.Code != common.ErrTimeout {
+			t.Error("Expected timeout error, got:", err)
+		}
+	})
+}
+
+func TestRegister(t *testing.T) {
+	proto := ProtoInfo{
+		Version:   "1.2.3",
+		Options:   []string{"ttyname", "default-*"},
+		SetOption: func(interface{}, string, string) error { return nil },
+		GetInfo: map[string]GetInfoFunc{
+			"flavor": func(interface{}) (string, error) { return "test", nil },
+		},
+	}
+	proto.Register(
+		Command{
+			Name:     "getpin",
+			Synopsis: "GETPIN [--repeat]",
+			Help:     "Ask for PIN.\nSecond line.",
+			Options:  []string{"repeat"},
+			Handler:  func(context.Context, *common.Pipe, interface{}, string) error { return nil },
+		},
+		Command{
+			Name:    "CONFIRM",
+			Handler: func(context.Context, *common.Pipe, interface{}, string) error { return nil },
+		},
+	)
+
+	cases := []struct {
+		name, cmd, params, expected string
+	}{
+		{"help list", "HELP", "", "# BYE\n# CONFIRM\n# GETINFO <what>\n# GETPIN [--repeat]\n# HELP [<command>]\n# INPUT FD[=<n>]\n# NOP\n# OPTION <name> [[=] <value>]\n# OUTPUT FD[=<n>]\n# RESET\nOK\n"},
+		{"help command", "HELP", "getpin", "# GETPIN [--repeat]\n#\n# Ask for PIN.\n# Second line.\nOK\n"},
+		{"help builtin", "HELP", "NOP", "# NOP\n#\n# No operation.\nOK\n"},
+		{"help unknown", "HELP", "FOO", "ERR 251658267 not found <assuan>\n"},
+		{"getinfo version", "GETINFO", "version", "D 1.2.3\nOK\n"},
+		{"getinfo extra", "GETINFO", "flavor", "D test\nOK\n"},
+		{"getinfo has option", "GETINFO", "cmd_has_option GETPIN repeat", "OK\n"},
+		{"getinfo has no option", "GETINFO", "cmd_has_option CONFIRM repeat", "ERR 251658496 false <assuan>\n"},
+		{"getinfo unknown", "GETINFO", "foo", "ERR 251658520 unknown value for WHAT <assuan>\n"},
+		{"option declared", "OPTION", "ttyname=/dev/tty", "OK\n"},
+		{"option prefix", "OPTION", "default-ok=Yes", "OK\n"},
+		{"option unknown", "OPTION", "foo=bar", "ERR 251658414 unknown option <assuan>\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			pipe := common.NewPipe(nil, &buf)
+			if err := handleCmd(context.Background(), &pipe, c.cmd, c.params, proto, nil); err != nil {
+				t.Fatal("Unexpected handleCmd error:", err)
+			}
+			if buf.String() != c.expected {
+				t.Errorf("Mismatched output: wanted %q, got %q", c.expected, buf.String())
+			}
+		})
+	}
+
+	buf := bytes.Buffer{}
+	pipe := common.NewPipe(nil, &buf)
+	if err := handleCmd(context.Background(), &pipe, "GETINFO", "pid", proto, nil); err != nil {
+		t.Fatal("Unexpected handleCmd error:", err)
+	}
+	if expected := "D " + strconv.Itoa(os.Getpid()) + "\nOK\n"; buf.String() != expected {
+		t.Errorf("Mismatched output: wanted %q, got %q", expected, buf.String())
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next CommandHandler) CommandHandler {
+			return func(ctx context.Context, pipe *common.Pipe, state interface{}, params string) error {
+				order = append(order, name+">"+CommandName(ctx))
+				err := next(ctx, pipe, state, params)
+				order = append(order, name+"<"+CommandName(ctx))
+				return err
+			}
+		}
+	}
+	var timed []string
+	ok := func(_ context.Context, _ *common.Pipe, _ interface{}, _ string) error {
+		order = append(order, "handler")
+		return nil
+	}
+	proto := ProtoInfo{
+		Handlers: map[string]CommandHandler{"GETPIN": ok, "PRESET_PASSPHRASE": ok},
+		Middleware: []Middleware{
+			trace("outer"),
+			Timing(func(cmd string, _ time.Duration, err error) { timed = append(timed, cmd) }),
+			Deny("preset_passphrase"),
+			RateLimit("GETPIN", 1, time.Hour),
+			Audit(),
+			trace("inner"),
+		},
+	}
+
+	cases := []struct {
+		cmd, expected string
+		order         []string
+	}{
+		{"GETPIN", "OK\n", []string{"outer>GETPIN", "inner>GETPIN", "handler", "inner<GETPIN", "outer<GETPIN"}},
+		{"GETPIN", "ERR 251658423 limit reached <assuan>\n", []string{"outer>GETPIN", "outer<GETPIN"}},
+		{"PRESET_PASSPHRASE", "ERR 251658491 forbidden <assuan>\n", []string{"outer>PRESET_PASSPHRASE", "outer<PRESET_PASSPHRASE"}},
+		{"RESET", "OK\n", []string{"outer>RESET", "inner>RESET", "inner<RESET", "outer<RESET"}},
+	}
+	for _, c := range cases {
+		order = nil
+		buf := bytes.Buffer{}
+		pipe := common.NewPipe(nil, &buf)
+		if err := handleCmd(context.Background(), &pipe, c.cmd, "", proto, nil); err != nil {
+			t.Fatal("Unexpected handleCmd error:", err)
+		}
+		if buf.String() != c.expected {
+			t.Errorf("%s: mismatched output: wanted %q, got %q", c.cmd, c.expected, buf.String())
+		}
+		if strings.Join(order, " ") != strings.Join(c.order, " ") {
+			t.Errorf("%s: mismatched call order: wanted %q, got %q", c.cmd, c.order, order)
+		}
+	}
+	if expected := "GETPIN GETPIN PRESET_PASSPHRASE RESET"; strings.Join(timed, " ") != expected {
+		t.Errorf("Mismatched timing reports: wanted %q, got %q", expected, timed)
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// nonceTimeout limits time client has to present nonce after connecting.
+var nonceTimeout = 5 * time.Second
+
+// SocketListener emulates libassuan sockets on Windows: it listens on
+// localhost TCP port, which is published in socket file together with random
+// nonce. Clients (see client.Dial) read the file, connect to the port and
+// send nonce first, connections without proper nonce are rejected.
+type SocketListener struct {
+	net.Listener
+
+	path      string
+	nonce     [16]byte
+	closeOnce sync.Once
+	closeErr  error
+
+	// Connections are accepted in background and checked concurrently, so
+	// client which does not send nonce cannot hold up others.
+	accepted chan accepted
+	failed   chan struct{}
+	err      error
+}
+
+type accepted struct {
+	conn net.Conn
+	err  error
+}
+
+// ListenAssuanSocket binds random localhost TCP port and atomically writes
+// socket file at path with port number and random nonce. Existing file is
+// replaced. Socket file is removed by Close.
+func ListenAssuanSocket(path string) (*SocketListener, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	sl := &SocketListener{Listener: l, path: path, accepted: make(chan accepted), failed: make(chan struct{})}
+	if _, err := rand.Read(sl.nonce[:]); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	content := append([]byte(strconv.Itoa(l.Addr().(*net.TCPAddr).Port)+"\n"), sl.nonce[:]...)
+	if err := writeFileAtomic(path, content); err != nil {
+		l.Close()
+		return nil, err
+	}
+	log.Printf("Listening on %s for assuan socket \"%s\"", l.Addr(), path)
+	go sl.acceptLoop()
+	return sl, nil
+}
+
+// Path returns name of socket file.
+func (l *SocketListener) Path() string {
+	return l.path
+}
+
+// Accept waits for next connection which presents valid nonce. Connections
+// with invalid nonce are closed and never returned.
+func (l *SocketListener) Accept() (net.Conn, error) {
+	select {
+	case a := <-l.accepted:
+		return a.conn, a.err
+	case <-l.failed:
+		return nil, l.err
+	}
+}
+
+func (l *SocketListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			var nerr net.Error
+			if !errors.As(err, &nerr) || !nerr.Timeout() {
+				l.err = err
+				close(l.failed)
+				return
+			}
+			// Let caller decide what to do with temporary failure.
+			select {
+			case l.accepted <- accepted{err: err}:
+			case <-l.failed:
+			}
+			continue
+		}
+		go l.verify(conn)
+	}
+}
+
+// verify passes connection to Accept if it presents valid nonce.
+func (l *SocketListener) verify(conn net.Conn) {
+	if err := l.checkNonce(conn); err != nil {
+		log.Println("Rejected connection from", conn.RemoteAddr(), "on assuan socket:", err)
+		conn.Close()
+		return
+	}
+	select {
+	case l.accepted <- accepted{conn: conn}:
+	case <-l.failed:
+		conn.Close()
+	}
+}
+
+func (l *SocketListener) checkNonce(conn net.Conn) error {
+	var nonce [16]byte
+	if err := conn.SetReadDeadline(time.Now().Add(nonceTimeout)); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, nonce[:]); err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(nonce[:], l.nonce[:]) != 1 {
+		return errors.New("invalid nonce")
+	}
+	return conn.SetReadDeadline(time.Time{})
+}
+
+// Close stops listening and removes socket file.
+func (l *SocketListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.closeErr = l.Listener.Close()
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) && l.closeErr == nil {
+			l.closeErr = err
+		}
+	})
+	return l.closeErr
+}
+
+// writeFileAtomic writes content to temporary file in the same directory and
+// renames it, so readers never see partially written file.
+func writeFileAtomic(path string, content []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
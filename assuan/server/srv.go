@@ -0,0 +1,274 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrServerClosed is returned by Server.Serve after Shutdown or Close.
+var ErrServerClosed = errors.New("assuan: server closed")
+
+// Server serves Assuan sessions on one or more listeners and keeps track of
+// them, so it could be stopped gracefully. Fields should not be changed
+// after Serve is called.
+type Server struct {
+	// Protocol definition used for all sessions.
+	Proto ProtoInfo
+	// Maximum number of concurrent sessions, 0 means no limit. When limit is
+	// reached new connections are not accepted until some session ends.
+	MaxSessions int
+	// Session is closed if client does not send next command within
+	// IdleTimeout, 0 means no timeout.
+	IdleTimeout time.Duration
+	// OnAccept is called for every accepted connection before session
+	// starts, if it returns error connection is closed.
+	OnAccept func(conn net.Conn) error
+	// OnClose is called when session ends with error returned by session
+	// (nil if session ended normally).
+	OnClose func(conn net.Conn, err error)
+
+	inShutdown int32
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	sessions   map[*srvSession]struct{}
+	sem        chan struct{}
+	done       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// srvSession tracks state of single session served by Server.
+type srvSession struct {
+	srv    *Server
+	conn   net.Conn
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	idle bool
+}
+
+func (s *Server) init() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done == nil {
+		s.done = make(chan struct{})
+		s.listeners = make(map[net.Listener]struct{})
+		s.sessions = make(map[*srvSession]struct{})
+		if s.MaxSessions > 0 {
+			s.sem = make(chan struct{}, s.MaxSessions)
+		}
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&s.inShutdown) != 0
+}
+
+// Serve accepts connections on listener and serves each in separate
+// goroutine. It returns when listener fails, ctx is done or server is shut
+// down, in the last case ErrServerClosed is returned. Listener is closed
+// when Serve returns. Sessions are not stopped when Serve returns unless ctx
+// is done, use Shutdown or Close to stop them.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	s.init()
+
+	s.mu.Lock()
+	if s.shuttingDown() {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	stopped := make(chan struct{})
+	defer func() {
+		close(stopped)
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Close()
+		case <-stopped:
+		}
+	}()
+
+	var delay time.Duration
+	for {
+		if s.sem != nil {
+			select {
+			case s.sem <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-s.done:
+				return ErrServerClosed
+			}
+		}
+
+		conn, err := l.Accept()
+		if err != nil {
+			s.release()
+			switch {
+			case s.shuttingDown():
+				return ErrServerClosed
+			case ctx.Err() != nil:
+				return ctx.Err()
+			}
+			var nerr net.Error
+			if errors.As(err, &nerr) && nerr.Timeout() {
+				// Back off the same way net/http does.
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				log.Printf("Listener fail: %v, retrying in %v", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			log.Println("Listener fail:", err)
+			return err
+		}
+		delay = 0
+
+		log.Println("Received remote connection on", conn.LocalAddr(), "from", conn.RemoteAddr())
+		if !s.startSession(ctx, conn) {
+			s.release()
+		}
+	}
+}
+
+func (s *Server) release() {
+	if s.sem != nil {
+		<-s.sem
+	}
+}
+
+func (s *Server) startSession(ctx context.Context, conn net.Conn) bool {
+	if s.OnAccept != nil {
+		if err := s.OnAccept(conn); err != nil {
+			log.Println("Connection rejected:", err)
+			conn.Close()
+			return false
+		}
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
+	ss := &srvSession{srv: s, conn: conn, cancel: cancel}
+
+	s.mu.Lock()
+	if s.shuttingDown() {
+		s.mu.Unlock()
+		cancel()
+		conn.Close()
+		return false
+	}
+	s.sessions[ss] = struct{}{}
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.wg.Done()
+		defer s.release()
+
+		err := serve(ctx, conn, s.Proto, sessionHooks{idleTimeout: s.IdleTimeout, waiting: ss.waiting})
+		if err != nil && ctx.Err() != nil && parent.Err() == nil && s.shuttingDown() {
+			// Idle session closed by Shutdown.
+			err = nil
+		}
+		if err != nil {
+			log.Println("Serve fail:", err)
+		}
+		cancel()
+		conn.Close()
+
+		s.mu.Lock()
+		delete(s.sessions, ss)
+		s.mu.Unlock()
+
+		if s.OnClose != nil {
+			s.OnClose(conn, err)
+		}
+	}()
+	return true
+}
+
+// waiting marks session idle while it waits for the next command. Idle
+// session ends when server is shutting down.
+func (ss *srvSession) waiting(idle bool) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.idle = idle
+	return !idle || !ss.srv.shuttingDown()
+}
+
+// closeIfIdle terminates session if it waits for the next command.
+func (ss *srvSession) closeIfIdle() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.idle {
+		ss.cancel()
+	}
+}
+
+func (s *Server) stop() []*srvSession {
+	s.init()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if atomic.CompareAndSwapInt32(&s.inShutdown, 0, 1) {
+		close(s.done)
+	}
+	for l := range s.listeners {
+		l.Close()
+	}
+	sessions := make([]*srvSession, 0, len(s.sessions))
+	for ss := range s.sessions {
+		sessions = append(sessions, ss)
+	}
+	return sessions
+}
+
+// Shutdown gracefully stops server: listeners are closed, idle sessions are
+// terminated and sessions executing commands are terminated as soon as
+// command is completed. Shutdown waits for all sessions to end or ctx to be
+// done, in which case remaining sessions are closed forcibly and ctx error is
+// returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	for _, ss := range s.stop() {
+		ss.closeIfIdle()
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+// Close immediately closes all listeners and sessions. Context passed to
+// command handlers is cancelled.
+func (s *Server) Close() error {
+	for _, ss := range s.stop() {
+		ss.cancel()
+		ss.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+	"github.com/rupor-github/win-gpg-agent/pinentry"
+	"github.com/rupor-github/win-gpg-agent/util"
+)
+
+// credUI is a prompter using Windows credentials dialog and message boxes.
+type credUI struct {
+	dlg util.DlgDetails
+}
+
+func repeatErrMsg(s *pinentry.Settings) string {
+	// we are repeating - passwords did not match
+	if len(s.RepeatError) > 0 {
+		return s.RepeatError
+	}
+	return "Does not match - try again"
+}
+
+// dismissedError is returned when dialog was closed without an answer, either
+// by user or because ctx is done (prompt timed out).
+func dismissedError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return createCommonError(common.ErrCanceled, "operation canceled")
+}
+
+func (p *credUI) GetPIN(ctx context.Context, s *pinentry.Settings) (pinentry.PIN, error) {
+
+	if s.GenPIN != nil {
+		passwd, ok := offerGeneratedPIN(ctx, s)
+		if err := ctx.Err(); err != nil {
+			return pinentry.PIN{}, err
+		}
+		if ok {
+			// Generated passphrase is shown to user, there is nothing to repeat.
+			return pinentry.PIN{Value: passwd, Repeated: true}, nil
+		}
+	}
+
+	var (
+		cancelOp, cachePasswd bool
+		passwd1, passwd2      string
+		errMsg                = s.Error
+	)
+
+	for {
+
+		cancelOp, passwd1, cachePasswd = util.PromptForWindowsCredentials(ctx,
+			p.dlg, errMsg, s.Desc, s.Prompt, s.Opts.AllowExtPasswdCache && len(s.KeyInfo) != 0)
+		if cancelOp {
+			return pinentry.PIN{}, dismissedError(ctx)
+		}
+		passwd1 = s.UnformatPassphrase(passwd1)
+
+		// Let gpg-agent check new passphrase before asking to repeat it.
+		if errMsg = s.CheckPassphrase(passwd1); len(errMsg) > 0 {
+			continue
+		}
+
+		if len(s.RepeatPrompt) == 0 {
+			return pinentry.PIN{Value: passwd1, Save: cachePasswd, Checked: true}, nil
+		}
+
+		// CredUI cannot show quality bar while passphrase is typed - show
+		// quality of entered passphrase when asking to repeat it.
+		qualityMsg := ""
+		if q, ok := s.Quality(passwd1); ok {
+			qualityMsg = s.QualityText(q)
+		}
+
+		cancelOp, passwd2, _ = util.PromptForWindowsCredentials(ctx, p.dlg, qualityMsg, s.Desc, s.RepeatPrompt, false)
+		if cancelOp {
+			return pinentry.PIN{}, dismissedError(ctx)
+		}
+
+		if passwd1 == s.UnformatPassphrase(passwd2) {
+			return pinentry.PIN{Value: passwd1, Repeated: true, Save: cachePasswd, Checked: true}, nil
+		}
+		errMsg = repeatErrMsg(s)
+	}
+}
+
+// offerGeneratedPIN shows generated passphrase and asks user to accept it, CredUI has no place for generate button.
+func offerGeneratedPIN(ctx context.Context, s *pinentry.Settings) (string, bool) {
+	passwd, err := s.GenPIN()
+	if err != nil {
+		log.Printf("Unable to generate passphrase: %s", err.Error())
+		return "", false
+	}
+
+	label, _ := pinentry.Mnemonic(s.GenPINLabel)
+	prompt := fmt.Sprintf("%s: \"%s\"", label, s.FormatPassphrase(passwd))
+	if len(s.GenPINToolTip) > 0 {
+		prompt += " (" + s.GenPINToolTip + ")"
+	}
+	if s.Opts.FormattedPassphrase && len(s.Opts.FormattedPassphraseHint) > 0 {
+		prompt += "\n" + s.Opts.FormattedPassphraseHint
+	}
+	prompt += "\n\nUse generated passphrase? Select \"No\" to enter passphrase yourself."
+	return passwd, util.PromptForConfirmaion(ctx, util.DlgDetails{}, s.Desc, prompt, false)
+}
+
+// Confirm dialog has Yes and No buttons only, No cancels operation.
+func (p *credUI) Confirm(ctx context.Context, s *pinentry.Settings) (pinentry.ConfirmResult, error) {
+	if util.PromptForConfirmaion(ctx, util.DlgDetails{}, s.Desc, s.Prompt, strings.Trim(s.CmdArgs, " ") == "--one-button") {
+		return pinentry.ConfirmOK, nil
+	}
+	// Message box dismissed on timeout looks like No.
+	if err := ctx.Err(); err != nil {
+		return pinentry.ConfirmCancel, err
+	}
+	return pinentry.ConfirmCancel, nil
+}
+
+func (p *credUI) Message(ctx context.Context, s *pinentry.Settings) error {
+	util.PromptForConfirmaion(ctx, util.DlgDetails{}, s.Desc, s.Prompt, true)
+	return ctx.Err()
+}
@@ -11,14 +11,12 @@ import (
 	"time"
 
 	"github.com/pborman/getopt/v2"
-	"golang.org/x/sys/windows"
 
 	"github.com/rupor-github/win-gpg-agent/assuan/common"
 	"github.com/rupor-github/win-gpg-agent/config"
 	"github.com/rupor-github/win-gpg-agent/misc"
 	"github.com/rupor-github/win-gpg-agent/pinentry"
 	"github.com/rupor-github/win-gpg-agent/util"
-	"github.com/rupor-github/win-gpg-agent/wincred"
 )
 
 var (
@@ -31,125 +29,75 @@ var (
 	aShowHelp   bool
 	aShowVer    bool
 	aDebug      bool
+	aTranscript string
 	aNoGrab     bool
 	aParent     uint64
 	aTimeout    int
-	// aDisplay, aTTYName, aTTYType, aLCType, aLCMessages string - not implemented.
+	aTTYName    string
+	aTTYType    string
+	// aDisplay, aLCType, aLCMessages string - not implemented.
 )
 
 func createCommonError(code common.ErrorCode, msg string) *common.Error {
 	return &common.Error{Src: common.ErrSrcPinentry, Code: code, SrcName: "pinentry", Message: msg}
 }
 
-func sendStatus(pipe *common.Pipe, cmd string) *common.Error {
-	if err := pipe.WriteLine("S", cmd); err != nil {
-		log.Println("... IO error, dropping session:", err)
-		return createCommonError(common.ErrAssWriteError, "unable to return status")
-	}
-	return nil
-}
-
-func getCachedCredential(pipe *common.Pipe, s *pinentry.Settings) (string, *common.Error) {
-	cred, err := wincred.GetGenericCredential(pinentry.CredentialName(s.KeyInfo))
-	if err != nil && !errors.Is(err, windows.ERROR_NOT_FOUND) {
-		log.Printf("GetGenericCredential cannot access vault: %s", err.Error())
-		s.Opts.AllowExtPasswdCache = false
-		return "", nil
-	}
-	if cred == nil {
-		// this should never happen, but just in case
-		return "", nil
-	}
-	if err := sendStatus(pipe, "PASSWORD_FROM_CACHE"); err != nil {
-		return "", err
-	}
-	return string(cred.CredentialBlob), nil
-}
-
-func addCachedCredential(name, passwd string) {
-	cred := wincred.NewGenericCredential(pinentry.CredentialName(name))
-	cred.CredentialBlob = []byte(passwd)
-	cred.Persist = wincred.PersistLocalMachine
-	if err := cred.Write(); err != nil {
-		log.Printf("Unable to store credential: %s", name)
-	}
-}
-
-func prepErrMsg(attempt int, s *pinentry.Settings) string {
-	if attempt == 0 {
-		if len(s.Error) > 0 {
-			return s.Error
+// makeCache creates external passphrase cache from configuration, nil if
+// caching is turned off.
+func makeCache(cfg *config.Config) (pinentry.PassphraseCache, error) {
+	switch strings.ToLower(cfg.GUI.PinCache.Backend) {
+	case "wincred", "":
+		return pinentry.WinCredCache{}, nil
+	case "file":
+		key, err := pinentry.LoadCacheKey(cfg.GUI.PinCache.KeyFile)
+		if err != nil {
+			return nil, err
 		}
-		return ""
+		return pinentry.NewFileCache(cfg.GUI.PinCache.Path, key)
+	case "memory":
+		return &pinentry.MemoryCache{}, nil
+	case "none":
+		return nil, nil
 	}
-	// we are repeating - passwords did not match
-	if len(s.RepeatError) > 0 {
-		return s.RepeatError
-	}
-	return "Does not match - try again"
+	return nil, fmt.Errorf("unknown passphrase cache backend \"%s\"", cfg.GUI.PinCache.Backend)
 }
 
-func (cbs *callbacksState) GetPIN(pipe *common.Pipe, s *pinentry.Settings) (string, *common.Error) {
-
-	if len(s.Error) == 0 && len(s.RepeatPrompt) == 0 && s.Opts.AllowExtPasswdCache && len(s.KeyInfo) != 0 {
-		// GnuPG calls it "reading from password cache" - let's try it
-		if passwd, err := getCachedCredential(pipe, s); err != nil {
-			return "", err
-		} else if len(passwd) > 0 {
-			return passwd, nil
-		}
-		// we never store enmpty pasword
-	}
-
-	var (
-		cancelOp, cachePasswd bool
-		passwd1, passwd2      string
-	)
-
-	for attempt := 0; ; attempt++ {
-
-		cancelOp, passwd1, cachePasswd = util.PromptForWindowsCredentials(
-			cbs.cfg.GUI.PinDlg, prepErrMsg(attempt, s), s.Desc, s.Prompt, s.Opts.AllowExtPasswdCache && len(s.KeyInfo) != 0)
-		if cancelOp {
-			return "", createCommonError(common.ErrCanceled, "operation canceled")
-		}
-
-		if len(s.RepeatPrompt) == 0 {
-			break
-		}
-
-		cancelOp, passwd2, _ = util.PromptForWindowsCredentials(cbs.cfg.GUI.PinDlg, "", s.Desc, s.RepeatPrompt, false)
-		if cancelOp {
-			return "", createCommonError(common.ErrCanceled, "operation canceled")
-		}
-
-		if passwd1 == passwd2 {
-			if err := sendStatus(pipe, "PIN_REPEATED"); err != nil {
-				return "", err
+// makePrompter creates prompt backends chain from configuration.
+func makePrompter(cfg *config.Config, cache pinentry.PassphraseCache) (pinentry.Prompter, error) {
+	var chain pinentry.Chain
+	for _, name := range cfg.GUI.Prompt.Backends {
+		switch strings.ToLower(name) {
+		case "credui":
+			chain = append(chain, &credUI{dlg: cfg.GUI.PinDlg})
+		case "tty":
+			chain = append(chain, &pinentry.TTY{})
+		case "external":
+			if len(cfg.GUI.Prompt.Program) == 0 {
+				return nil, errors.New("external prompt backend requires program")
+			}
+			chain = append(chain, &pinentry.External{Path: cfg.GUI.Prompt.Program, Args: cfg.GUI.Prompt.Args})
+		case "script":
+			f, err := os.Open(cfg.GUI.Prompt.Script)
+			if err != nil {
+				return nil, err
 			}
-			break
+			sc, err := pinentry.ReadScript(f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, sc)
+		default:
+			return nil, fmt.Errorf("unknown prompt backend \"%s\"", name)
 		}
 	}
-
-	// Everything went well - let's see if we could save password for later use.
-	if s.Opts.AllowExtPasswdCache && len(s.KeyInfo) != 0 && cachePasswd && len(passwd1) > 0 {
-		addCachedCredential(s.KeyInfo, passwd1)
+	if len(chain) == 0 {
+		return nil, errors.New("no prompt backends configured")
 	}
-	return passwd1, nil
-}
-
-func (cbs *callbacksState) Confirm(_ *common.Pipe, s *pinentry.Settings) (bool, *common.Error) {
-	return util.PromptForConfirmaion(util.DlgDetails{}, s.Desc, s.Prompt, strings.Trim(s.CmdArgs, " ") == "--one-button"), nil
-}
-
-func (cbs *callbacksState) Msg(_ *common.Pipe, s *pinentry.Settings) *common.Error {
-	util.PromptForConfirmaion(util.DlgDetails{}, s.Desc, s.Prompt, true)
-	return nil
-}
-
-// We may need to keep some additional state between calls - pinentry state machine is old...
-type callbacksState struct {
-	cfg *config.Config
+	if cache == nil {
+		return chain, nil
+	}
+	return &pinentry.Cached{Prompter: chain, Cache: cache}, nil
 }
 
 func main() {
@@ -171,12 +119,13 @@ func main() {
 	cli.FlagLong(&aShowVer, "version", 0, "Show version information")
 	cli.FlagLong(&aShowHelp, "help", 'h', "Show help")
 	cli.FlagLong(&aDebug, "debug", 'd', "Turn on debugging")
+	cli.FlagLong(&aTranscript, "transcript", 0, "Write protocol transcript (secrets are redacted) to file", "path")
 	// cli.FlagLong(&aNoGrab, "no-global-grab", 'g', "Grab the keyboard only when the window is focused")
 	// cli.FlagLong(&aParent, "parent-wid", 'W', "Use window handle as the parent window for positioning the window", "HWND")
-	// cli.FlagLong(&aTimeout, "timeout", 'o', "Give up waiting for input from the user after the specified number of seconds and return an error", "SECONDS")
+	cli.FlagLong(&aTimeout, "timeout", 'o', "Give up waiting for input from the user after the specified number of seconds and return an error", "SECONDS")
 	// cli.FlagLong(&aDisplay, "display", 'D', "console vs windows ?", "STRING")
-	// cli.FlagLong(&aTTYName, "ttyname", 'T', "", "STRING")
-	// cli.FlagLong(&aTTYType, "ttytype", 'N', "", "STRING")
+	cli.FlagLong(&aTTYName, "ttyname", 'T', "Terminal to use with tty prompt backend", "STRING")
+	cli.FlagLong(&aTTYType, "ttytype", 'N', "Type of terminal, \"dumb\" turns off highlighting", "STRING")
 	// cli.FlagLong(&aLCType, "lc-ctype", 'C', "", "STRING")
 	// cli.FlagLong(&aLCMessages, "lc-messages", 'M', "", "STRING")
 
@@ -207,6 +156,19 @@ func main() {
 	}
 	util.NewLogWriter(title, 0, cfg.GUI.Debug)
 
+	if cfg.GUI.Debug {
+		pinentry.Info.Logger = common.NewStdLogger(common.LogDebug)
+	}
+	if len(aTranscript) != 0 {
+		f, err := os.OpenFile(aTranscript, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Printf("Unable to open transcript file %s: %s", aTranscript, err.Error())
+		} else {
+			defer f.Close()
+			pinentry.Info.Logger = common.NewTranscriptLogger(f)
+		}
+	}
+
 	log.Println("Serving...")
 
 	// Save default state for this run - go-assuan's simple design is prone to initialization loop, Go does not like it and workaround looks ugly.
@@ -214,9 +176,28 @@ func main() {
 	pinentry.DefaultSettings.Timeout = time.Duration(aTimeout) * time.Second
 	pinentry.DefaultSettings.Opts.Grab = !aNoGrab
 	pinentry.DefaultSettings.Opts.ParentWID = fmt.Sprintf("0x%08X", aParent)
+	pinentry.DefaultSettings.Opts.TTYName = aTTYName
+	pinentry.DefaultSettings.Opts.TTYType = aTTYType
 
-	cbs := &callbacksState{cfg: cfg}
-	if err := pinentry.Serve(pinentry.Callbacks{GetPIN: cbs.GetPIN, Confirm: cbs.Confirm, Msg: cbs.Msg}, verStr); err != nil {
+	cache, err := makeCache(cfg)
+	if err != nil {
+		log.Printf("Unable to configure passphrase cache: %s", err.Error())
+		os.Exit(1)
+	}
+	prompter, err := makePrompter(cfg, cache)
+	if err != nil {
+		log.Printf("Unable to configure prompt: %s", err.Error())
+		os.Exit(1)
+	}
+	callbacks := pinentry.PrompterCallbacks(prompter)
+	callbacks.Cache = cache
+	callbacks.GenPIN = pinentry.Generator{
+		Words:     cfg.GUI.GenPIN.Words,
+		Separator: cfg.GUI.GenPIN.Separator,
+		Length:    cfg.GUI.GenPIN.Length,
+		Charset:   cfg.GUI.GenPIN.Charset,
+	}.Generate
+	if err := pinentry.Serve(callbacks, verStr); err != nil {
 		log.Printf("Pinentry Serve returned error: %s", err.Error())
 		os.Exit(1)
 	}
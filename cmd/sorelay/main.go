@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -41,8 +40,8 @@ func main() {
 	}
 
 	cli.SetProgram("sorelay.exe")
-	cli.SetParameters("path-to-socket")
-	cli.FlagLong(&aAssuan, "assuan", 'a', "Open Assuan socket instead of Unix one")
+	cli.SetParameters("socket-address")
+	cli.FlagLong(&aAssuan, "assuan", 'a', "Treat path as Assuan socket file (same as assuan-file: prefix)")
 	cli.FlagLong(&aConfigName, "config", 'c', "Configuration file", "path")
 	cli.FlagLong(&aShowVer, "version", 0, "Show version information")
 	cli.FlagLong(&aShowHelp, "help", 'h', "Show help")
@@ -82,12 +81,11 @@ func main() {
 
 	log.Printf("Dialing %s", socketName)
 
-	var conn net.Conn
+	addr := socketName
 	if aAssuan {
-		conn, err = client.Dial(socketName)
-	} else {
-		conn, err = net.Dial("unix", socketName)
+		addr = "assuan-file:" + socketName
 	}
+	conn, err := client.Dial(addr)
 	if err != nil {
 		log.Printf("Unable to dial socket \"%s\": %s", socketName, err.Error())
 		os.Exit(1)
@@ -37,6 +37,37 @@ type CLPConfig struct {
 	Keys []string `yaml:"public_keys,omitempty"`
 }
 
+// GenPINConfig wraps configuration values for passphrase generation in
+// pinentry: words from EFF wordlist when Words is positive, otherwise Length
+// random characters from Charset.
+type GenPINConfig struct {
+	Words     int    `yaml:"words,omitempty"`
+	Separator string `yaml:"separator,omitempty"`
+	Length    int    `yaml:"length,omitempty"`
+	Charset   string `yaml:"charset,omitempty"`
+}
+
+// PromptConfig selects pinentry prompt backends. Backends are tried in order
+// until one is available: "credui" (Windows credentials dialog), "tty"
+// (console), "external" (pinentry Program started with Args) and "script"
+// (answers from Script file, for tests).
+type PromptConfig struct {
+	Backends []string `yaml:"backends,omitempty"`
+	Program  string   `yaml:"program,omitempty"`
+	Args     []string `yaml:"args,omitempty"`
+	Script   string   `yaml:"script,omitempty"`
+}
+
+// PinCacheConfig selects external passphrase cache used by pinentry when
+// gpg-agent allows it: "wincred" (Windows credentials vault), "file" (file at
+// Path encrypted with key from KeyFile, key is generated if file does not
+// exist), "memory" (lives as long as pinentry process) or "none".
+type PinCacheConfig struct {
+	Backend string `yaml:"backend,omitempty"`
+	Path    string `yaml:"path,omitempty"`
+	KeyFile string `yaml:"key_file,omitempty"`
+}
+
 // GUIConfig wraps configuration values for agent-gui, pinentry and sorelay.
 type GUIConfig struct {
 	Debug             bool            `yaml:"debug,omitempty"`
@@ -49,6 +80,9 @@ type GUIConfig struct {
 	Deadline          time.Duration   `yaml:"deadline,omitempty"`
 	XAgentCookieSize  int             `yaml:"xagent_cookie_size,omitempty"`
 	PinDlg            util.DlgDetails `yaml:"pin_dialog,omitempty"`
+	GenPIN            GenPINConfig    `yaml:"gen_pin,omitempty"`
+	Prompt            PromptConfig    `yaml:"prompt,omitempty"`
+	PinCache          PinCacheConfig  `yaml:"pin_cache,omitempty"`
 	Clp               CLPConfig       `yaml:"gclpr,omitempty"`
 }
 
@@ -68,6 +102,15 @@ gui:
     delay: 300ms
     name: Windows Security
     class: Credential Dialog Xaml Host
+  gen_pin:
+    words: 6
+    separator: " "
+  prompt:
+    backends: [credui]
+  pin_cache:
+    backend: wincred
+    path: "${LOCALAPPDATA}\\gnupg\\pinentry.cache"
+    key_file: "${LOCALAPPDATA}\\gnupg\\pinentry.key"
 `
 
 // Config keeps all configuration values.
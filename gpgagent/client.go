@@ -0,0 +1,313 @@
+// Package gpgagent implements client for gpg-agent Assuan protocol on top of
+// assuan/client.Session.
+//
+// Client is not safe for concurrent use: Assuan session executes one command
+// at a time. Pool hands out clients to concurrent callers.
+package gpgagent
+
+import (
+	"context"
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/client"
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+)
+
+// Client for gpg-agent Assuan session.
+type Client struct {
+	Session *client.Session
+
+	conn io.Closer
+}
+
+// New initializes Client using passed stream, which must be already connected
+// to gpg-agent.
+func New(stream io.ReadWriter) (*Client, error) {
+	ses, err := client.Init(stream)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Session: ses}, nil
+}
+
+// Dial connects to gpg-agent endpoint (see client.Dial for supported
+// addresses) and initializes Client.
+func Dial(sockPath string) (*Client, error) {
+	conn, err := client.Dial(sockPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to dial assuan socket \"%s\": %w", sockPath, err)
+	}
+	c, err := New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to init assuan session on \"%s\": %w", sockPath, err)
+	}
+	c.conn = conn
+	return c, nil
+}
+
+// Close ends session and closes connection if it was established by Dial.
+func (c *Client) Close() error {
+	err := c.Session.Close()
+	if c.conn != nil {
+		if cerr := c.conn.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Reset sends RESET command.
+func (c *Client) Reset(ctx context.Context) error {
+	_, err := c.Session.SimpleCmdContext(ctx, "RESET", "")
+	return err
+}
+
+// getInfo sends GETINFO command and returns data sent by agent.
+func (c *Client) getInfo(ctx context.Context, what string) (string, error) {
+	data, err := c.Session.SimpleCmdContext(ctx, "GETINFO", what)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Version returns gpg-agent version.
+func (c *Client) Version(ctx context.Context) (string, error) {
+	return c.getInfo(ctx, "version")
+}
+
+// PID returns gpg-agent process id.
+func (c *Client) PID(ctx context.Context) (int, error) {
+	res, err := c.getInfo(ctx, "pid")
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(res))
+	if err != nil {
+		return 0, fmt.Errorf("bad pid \"%s\": %w", res, err)
+	}
+	return pid, nil
+}
+
+// SocketName returns name of gpg-agent standard socket.
+func (c *Client) SocketName(ctx context.Context) (string, error) {
+	return c.getInfo(ctx, "socket_name")
+}
+
+// SSHSocketName returns name of gpg-agent ssh socket.
+func (c *Client) SSHSocketName(ctx context.Context) (string, error) {
+	return c.getInfo(ctx, "ssh_socket_name")
+}
+
+// statusCmd executes command collecting parameters of status lines with
+// specified keyword.
+func (c *Client) statusCmd(ctx context.Context, keyword, cmd, params string) ([]string, error) {
+	var lines []string
+	c.Session.OnStatus(keyword, func(_, params string) {
+		lines = append(lines, params)
+	})
+	defer c.Session.OnStatus(keyword, nil)
+
+	if _, err := c.Session.SimpleCmdContext(ctx, cmd, params); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+func (c *Client) keyInfoList(ctx context.Context, params string) ([]KeyInfo, error) {
+	lines, err := c.statusCmd(ctx, "KEYINFO", "KEYINFO", params)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]KeyInfo, 0, len(lines))
+	for _, l := range lines {
+		ki, err := ParseKeyInfo(l)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ki)
+	}
+	return res, nil
+}
+
+// KeyInfo returns information about key with specified keygrip.
+func (c *Client) KeyInfo(ctx context.Context, keygrip string) (KeyInfo, error) {
+	list, err := c.keyInfoList(ctx, keygrip)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	if len(list) == 0 {
+		return KeyInfo{}, errors.New("no key information returned for " + keygrip)
+	}
+	return list[0], nil
+}
+
+// KeyInfoList returns information about all keys known to gpg-agent.
+func (c *Client) KeyInfoList(ctx context.Context) ([]KeyInfo, error) {
+	return c.keyInfoList(ctx, "--list")
+}
+
+// SSHKeyInfoList returns information about keys listed in sshcontrol file,
+// KeyInfo.Fingerprint is set to ssh fingerprint of the key.
+func (c *Client) SSHKeyInfoList(ctx context.Context) ([]KeyInfo, error) {
+	return c.keyInfoList(ctx, "--ssh-list --ssh-fpr")
+}
+
+// HaveKey checks if secret key for any of specified keygrips is available.
+func (c *Client) HaveKey(ctx context.Context, keygrips ...string) (bool, error) {
+	_, err := c.Session.SimpleCmdContext(ctx, "HAVEKEY", strings.Join(keygrips, " "))
+	if err != nil {
+		if errors.Is(err, common.ErrNoSeckey) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ReadKey returns public key with specified keygrip as canonical encoded
+// S-expression.
+func (c *Client) ReadKey(ctx context.Context, keygrip string) ([]byte, error) {
+	return c.Session.SimpleCmdContext(ctx, "READKEY", keygrip)
+}
+
+// PassphraseRequest describes GET_PASSPHRASE command parameters.
+type PassphraseRequest struct {
+	// CacheID is used to look up passphrase in agent cache, empty value
+	// disables caching.
+	CacheID     string
+	Error       string
+	Prompt      string
+	Description string
+	// Repeat is number of times passphrase must be repeated by user.
+	Repeat int
+	// Check requests passphrase constraints check.
+	Check bool
+	// NoAsk returns error instead of asking user when passphrase is not in
+	// cache.
+	NoAsk bool
+	// QualityBar requests quality bar to be shown.
+	QualityBar bool
+}
+
+// GetPassphrase asks gpg-agent for passphrase, which may be taken from cache
+// or requested from user with pinentry.
+func (c *Client) GetPassphrase(ctx context.Context, req PassphraseRequest) (string, error) {
+	args := []string{"--data"}
+	if req.Repeat > 0 {
+		args = append(args, "--repeat="+strconv.Itoa(req.Repeat))
+	}
+	if req.Check {
+		args = append(args, "--check")
+	}
+	if req.NoAsk {
+		args = append(args, "--no-ask")
+	}
+	if req.QualityBar {
+		args = append(args, "--qualitybar")
+	}
+	for _, v := range []string{req.CacheID, req.Error, req.Prompt, req.Description} {
+		args = append(args, passphraseArg(v))
+	}
+
+	data, err := c.Session.SimpleCmdEncoded(ctx, "GET_PASSPHRASE", strings.Join(args, " "))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ClearPassphrase removes passphrase with specified cache id from agent cache.
+func (c *Client) ClearPassphrase(ctx context.Context, cacheID string) error {
+	_, err := c.Session.SimpleCmdEncoded(ctx, "CLEAR_PASSPHRASE", passphraseArg(cacheID))
+	return err
+}
+
+// PresetPassphrase puts passphrase for key with specified keygrip into agent
+// cache. Negative timeout uses agent default, zero means forever. Agent must
+// be started with --allow-preset-passphrase.
+func (c *Client) PresetPassphrase(ctx context.Context, keygrip string, timeout int, passphrase string) error {
+	_, err := c.Session.SimpleCmdContext(ctx, "PRESET_PASSPHRASE",
+		fmt.Sprintf("%s %d %s", keygrip, timeout, strings.ToUpper(hex.EncodeToString([]byte(passphrase)))))
+	return err
+}
+
+// hashAlgos maps hashes to libgcrypt algorithm identifiers used by SETHASH.
+var hashAlgos = map[crypto.Hash]int{
+	crypto.MD5:       1,
+	crypto.SHA1:      2,
+	crypto.RIPEMD160: 3,
+	crypto.SHA256:    8,
+	crypto.SHA384:    9,
+	crypto.SHA512:    10,
+	crypto.SHA224:    11,
+}
+
+// Sign signs digest computed with specified hash using key with specified
+// keygrip. Signature is returned as canonical encoded S-expression.
+func (c *Client) Sign(ctx context.Context, keygrip string, hash crypto.Hash, digest []byte) ([]byte, error) {
+	algo, ok := hashAlgos[hash]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm: %v", hash)
+	}
+	if _, err := c.Session.SimpleCmdContext(ctx, "SIGKEY", keygrip); err != nil {
+		return nil, err
+	}
+	if _, err := c.Session.SimpleCmdContext(ctx, "SETHASH", fmt.Sprintf("%d %X", algo, digest)); err != nil {
+		return nil, err
+	}
+	return c.Session.SimpleCmdContext(ctx, "PKSIGN", "")
+}
+
+// Decrypt decrypts ciphertext (canonical encoded S-expression) using key with
+// specified keygrip. Result is returned as canonical encoded S-expression.
+func (c *Client) Decrypt(ctx context.Context, keygrip string, ciphertext []byte) ([]byte, error) {
+	if _, err := c.Session.SimpleCmdContext(ctx, "SETKEY", keygrip); err != nil {
+		return nil, err
+	}
+	return c.Session.TransactWith(ctx, "PKDECRYPT", "", client.InquireData{"CIPHERTEXT": ciphertext})
+}
+
+// ReloadAgent makes gpg-agent reload its configuration and flush caches.
+func (c *Client) ReloadAgent(ctx context.Context) error {
+	_, err := c.Session.SimpleCmdContext(ctx, "RELOADAGENT", "")
+	return err
+}
+
+// KillAgent tells gpg-agent to exit.
+func (c *Client) KillAgent(ctx context.Context) error {
+	_, err := c.Session.SimpleCmdContext(ctx, "KILLAGENT", "")
+	return err
+}
+
+// ScdSerialNo returns serial number of smart card currently inserted.
+func (c *Client) ScdSerialNo(ctx context.Context) (string, error) {
+	lines, err := c.statusCmd(ctx, "SERIALNO", "SCD", "SERIALNO")
+	if err != nil {
+		return "", err
+	}
+	if len(lines) == 0 {
+		return "", errors.New("no serial number returned")
+	}
+	serial := lines[len(lines)-1]
+	if i := strings.IndexByte(serial, ' '); i >= 0 {
+		serial = serial[:i]
+	}
+	return serial, nil
+}
+
+// passphraseArg prepares GET_PASSPHRASE and CLEAR_PASSPHRASE argument: empty
+// value is sent as X, otherwise it is plus-escaped (see common.PlusEscape).
+func passphraseArg(s string) string {
+	if len(s) == 0 {
+		return "X"
+	}
+	return common.PlusEscape(s)
+}
@@ -0,0 +1,240 @@
+package gpgagent_test
+
+import (
+	"context"
+	"crypto"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+	"github.com/rupor-github/win-gpg-agent/assuan/server"
+	"github.com/rupor-github/win-gpg-agent/gpgagent"
+	"github.com/rupor-github/win-gpg-agent/gpgagent/gpgagenttest"
+)
+
+const (
+	testGrip    = "0123456789ABCDEF0123456789ABCDEF01234567"
+	otherGrip   = "76543210FEDCBA9876543210FEDCBA9876543210"
+	missingGrip = "00000000000000000000000000000000000000FF"
+)
+
+var testKeys = []gpgagenttest.Key{
+	{
+		Info: gpgagent.KeyInfo{
+			Keygrip: testGrip, Type: gpgagent.KeyTypeDisk, Cached: true, Protection: "P",
+			Fingerprint: "SHA256:AbCdEf", TTL: 600, SSHControl: true, Confirm: true,
+		},
+		PublicKey: []byte("(10:public-key(3:rsa))"),
+	},
+	{
+		Info: gpgagent.KeyInfo{
+			Keygrip: otherGrip, Type: gpgagent.KeyTypeCard, SerialNo: "D2760001240102010006", IDStr: "OPENPGP.1",
+		},
+	},
+}
+
+func startFakeAgent(t *testing.T) (*gpgagent.Client, *gpgagenttest.Agent) {
+	t.Helper()
+
+	ok := func(context.Context, *common.Pipe, interface{}, string) error { return nil }
+
+	fa := gpgagenttest.New()
+	fa.Version = "2.2.27"
+	fa.PID = 4242
+	fa.SocketName = "/run/user/1000/gnupg/S.gpg-agent"
+	fa.SSHSocketName = "/run/user/1000/gnupg/S.gpg-agent.ssh"
+	fa.Keys = testKeys
+	fa.Handle("GET_PASSPHRASE", func(_ context.Context, pipe *common.Pipe, _ interface{}, _ string) error {
+		return pipe.WriteData([]byte("secret"))
+	})
+	fa.Handle("CLEAR_PASSPHRASE", ok)
+	fa.Handle("PRESET_PASSPHRASE", ok)
+	fa.Handle("SETKEY", ok)
+	fa.Handle("PKDECRYPT", func(_ context.Context, pipe *common.Pipe, _ interface{}, _ string) error {
+		r, err := server.InquireReader(pipe, "CIPHERTEXT", 0)
+		if err != nil {
+			return err
+		}
+		ct, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return pipe.WriteData(append([]byte("(5:value"), ct...))
+	})
+	fa.Handle("SCD", func(_ context.Context, pipe *common.Pipe, _ interface{}, _ string) error {
+		if err := server.SendStatus(pipe, "SERIALNO", "D2760001240102010006 0"); err != nil {
+			return err
+		}
+		return nil
+	})
+
+	cl, srv := net.Pipe()
+	go func() {
+		defer srv.Close()
+		_ = fa.Serve(srv)
+	}()
+
+	c, err := gpgagent.New(cl)
+	if err != nil {
+		t.Fatal("Unexpected error on gpgagent.New:", err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		cl.Close()
+	})
+	return c, fa
+}
+
+func TestClient_GetInfo(t *testing.T) {
+	c, _ := startFakeAgent(t)
+	ctx := context.Background()
+
+	if v, err := c.Version(ctx); err != nil || v != "2.2.27" {
+		t.Error("Unexpected version:", v, err)
+	}
+	if pid, err := c.PID(ctx); err != nil || pid != 4242 {
+		t.Error("Unexpected pid:", pid, err)
+	}
+	if s, err := c.SocketName(ctx); err != nil || s != "/run/user/1000/gnupg/S.gpg-agent" {
+		t.Error("Unexpected socket name:", s, err)
+	}
+	if s, err := c.SSHSocketName(ctx); err != nil || s != "/run/user/1000/gnupg/S.gpg-agent.ssh" {
+		t.Error("Unexpected ssh socket name:", s, err)
+	}
+}
+
+func TestClient_KeyInfo(t *testing.T) {
+	c, _ := startFakeAgent(t)
+	ctx := context.Background()
+
+	list, err := c.KeyInfoList(ctx)
+	if err != nil {
+		t.Fatal("Unexpected error on KeyInfoList:", err)
+	}
+	expected := []gpgagent.KeyInfo{testKeys[0].Info, testKeys[1].Info}
+	// Fingerprint is reported only when requested.
+	expected[0].Fingerprint = ""
+	if len(list) != len(expected) {
+		t.Fatalf("Key list length mismatch: wanted %d, got %d", len(expected), len(list))
+	}
+	for i := range expected {
+		if list[i] != expected[i] {
+			t.Errorf("Key info mismatch: wanted %+v, got %+v", expected[i], list[i])
+		}
+	}
+
+	list, err = c.SSHKeyInfoList(ctx)
+	if err != nil {
+		t.Fatal("Unexpected error on SSHKeyInfoList:", err)
+	}
+	sshExpected := testKeys[0].Info
+	if len(list) != 1 || list[0] != sshExpected {
+		t.Errorf("SSH key info mismatch: wanted %+v, got %+v", sshExpected, list)
+	}
+	if s := list[0].String(); s != testGrip+" D - - 1 P SHA256:AbCdEf 600 Sc" {
+		t.Error("Unexpected KeyInfo string:", s)
+	}
+
+	ki, err := c.KeyInfo(ctx, testGrip)
+	if err != nil || ki != expected[0] {
+		t.Errorf("Key info mismatch: wanted %+v, got %+v (%v)", expected[0], ki, err)
+	}
+}
+
+func TestClient_HaveKey(t *testing.T) {
+	c, _ := startFakeAgent(t)
+	ctx := context.Background()
+
+	if ok, err := c.HaveKey(ctx, missingGrip, testGrip); err != nil || !ok {
+		t.Error("Expected key to be present:", ok, err)
+	}
+	if ok, err := c.HaveKey(ctx, missingGrip); err != nil || ok {
+		t.Error("Expected key to be absent:", ok, err)
+	}
+}
+
+func TestClient_Passphrase(t *testing.T) {
+	c, fa := startFakeAgent(t)
+	ctx := context.Background()
+
+	pass, err := c.GetPassphrase(ctx, gpgagent.PassphraseRequest{
+		CacheID: "cache1", Prompt: "Passphrase:", Description: "Please enter passphrase", Repeat: 1,
+	})
+	if err != nil || pass != "secret" {
+		t.Error("Unexpected passphrase:", pass, err)
+	}
+	if err := c.ClearPassphrase(ctx, "cache1"); err != nil {
+		t.Error("Unexpected error on ClearPassphrase:", err)
+	}
+	if err := c.PresetPassphrase(ctx, testGrip, -1, "pw"); err != nil {
+		t.Error("Unexpected error on PresetPassphrase:", err)
+	}
+	// Plus sign and percent are escaped, agent receives them percent-decoded.
+	if _, err := c.GetPassphrase(ctx, gpgagent.PassphraseRequest{Prompt: "PIN:", Description: "a+b 100%"}); err != nil {
+		t.Error("Unexpected error for plus sign in request:", err)
+	}
+
+	expected := []string{
+		"GET_PASSPHRASE --data --repeat=1 cache1 X Passphrase: Please+enter+passphrase",
+		"CLEAR_PASSPHRASE cache1",
+		"PRESET_PASSPHRASE " + testGrip + " -1 7077",
+		"GET_PASSPHRASE --data X X PIN: a+b+100%",
+	}
+	if strings.Join(fa.Commands(), "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Commands mismatch: wanted %q, got %q", expected, fa.Commands())
+	}
+}
+
+func TestClient_SignDecrypt(t *testing.T) {
+	c, fa := startFakeAgent(t)
+	ctx := context.Background()
+
+	sig, err := c.Sign(ctx, testGrip, crypto.SHA256, []byte{0xDE, 0xAD, 0xBE, 0xEF})
+	if err != nil || string(sig) != "(7:sig-val(3:rsa(1:s4:\xDE\xAD\xBE\xEF)))" {
+		t.Error("Unexpected signature:", string(sig), err)
+	}
+	plain, err := c.Decrypt(ctx, testGrip, []byte("(7:enc-val)"))
+	if err != nil || string(plain) != "(5:value(7:enc-val)" {
+		t.Error("Unexpected plaintext:", string(plain), err)
+	}
+	if key, err := c.ReadKey(ctx, testGrip); err != nil || string(key) != "(10:public-key(3:rsa))" {
+		t.Error("Unexpected public key:", string(key), err)
+	}
+
+	expected := []string{
+		"SIGKEY " + testGrip,
+		"SETHASH 8 DEADBEEF",
+		"PKSIGN",
+		"SETKEY " + testGrip,
+		"PKDECRYPT",
+		"READKEY " + testGrip,
+	}
+	if strings.Join(fa.Commands(), "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Commands mismatch: wanted %q, got %q", expected, fa.Commands())
+	}
+}
+
+func TestClient_Misc(t *testing.T) {
+	c, fa := startFakeAgent(t)
+	ctx := context.Background()
+
+	if serial, err := c.ScdSerialNo(ctx); err != nil || serial != "D2760001240102010006" {
+		t.Error("Unexpected serial number:", serial, err)
+	}
+	if err := c.ReloadAgent(ctx); err != nil {
+		t.Error("Unexpected error on ReloadAgent:", err)
+	}
+	if err := c.Reset(ctx); err != nil {
+		t.Error("Unexpected error on Reset:", err)
+	}
+	if err := c.KillAgent(ctx); err != nil {
+		t.Error("Unexpected error on KillAgent:", err)
+	}
+
+	expected := []string{"SCD SERIALNO", "RELOADAGENT", "RESET", "KILLAGENT"}
+	if strings.Join(fa.Commands(), "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Commands mismatch: wanted %q, got %q", expected, fa.Commands())
+	}
+}
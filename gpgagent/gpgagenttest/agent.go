@@ -0,0 +1,376 @@
+// Package gpgagenttest provides in-process fake gpg-agent for tests.
+//
+// Agent speaks enough of gpg-agent Assuan protocol to exercise clients
+// (GETINFO, KEYINFO, HAVEKEY, READKEY, SIGKEY, SETHASH, PKSIGN, KILLAGENT,
+// RESET, RELOADAGENT). Additional commands could be added with Handle. It can
+// serve single stream, unix socket or Windows style Assuan socket file (TCP
+// port and nonce).
+package gpgagenttest
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+	"github.com/rupor-github/win-gpg-agent/assuan/server"
+	"github.com/rupor-github/win-gpg-agent/gpgagent"
+)
+
+// Key is a key known to fake agent.
+type Key struct {
+	// Info is reported by KEYINFO, Info.Keygrip identifies the key.
+	Info gpgagent.KeyInfo
+	// PublicKey is returned by READKEY.
+	PublicKey []byte
+}
+
+// Agent is a fake gpg-agent. Fields should be set before agent starts
+// serving.
+type Agent struct {
+	Version       string
+	PID           int
+	SocketName    string
+	SSHSocketName string
+	Keys          []Key
+
+	// Sign produces signature for PKSIGN, by default signature is an
+	// S-expression containing digest.
+	Sign func(keygrip string, hashAlgo int, digest []byte) ([]byte, error)
+
+	mu       sync.Mutex
+	handlers map[string]server.CommandHandler
+	cmds     []string
+	reloads  int
+	killed   chan struct{}
+	killOnce sync.Once
+	srv      server.Server
+}
+
+// New creates fake agent with some reasonable defaults and no keys.
+func New() *Agent {
+	return &Agent{
+		Version:       "2.2.27",
+		PID:           os.Getpid(),
+		SocketName:    "S.gpg-agent",
+		SSHSocketName: "S.gpg-agent.ssh",
+		handlers:      make(map[string]server.CommandHandler),
+		killed:        make(chan struct{}),
+	}
+}
+
+// sessionState keeps per connection state of fake agent.
+type sessionState struct {
+	sigKey   string
+	hashAlgo int
+	digest   []byte
+}
+
+// Handle adds handler for additional command or replaces default one.
+func (a *Agent) Handle(cmd string, handler server.CommandHandler) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.handlers[strings.ToUpper(cmd)] = handler
+}
+
+// Commands returns all commands received by agent so far with their
+// parameters, options are reported as "OPTION name=value".
+func (a *Agent) Commands() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.cmds...)
+}
+
+// Reloads returns number of RELOADAGENT commands received.
+func (a *Agent) Reloads() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.reloads
+}
+
+// Killed returns channel which is closed when KILLAGENT is received.
+func (a *Agent) Killed() <-chan struct{} {
+	return a.killed
+}
+
+// Proto returns protocol definition of fake agent.
+func (a *Agent) Proto() server.ProtoInfo {
+	handlers := map[string]server.CommandHandler{
+		"GETINFO":     a.getInfo,
+		"KEYINFO":     a.keyInfo,
+		"HAVEKEY":     a.haveKey,
+		"READKEY":     a.readKey,
+		"SIGKEY":      a.sigKey,
+		"SETHASH":     a.setHash,
+		"PKSIGN":      a.pkSign,
+		"RESET":       a.reset,
+		"RELOADAGENT": a.reloadAgent,
+		"KILLAGENT":   a.killAgent,
+	}
+	a.mu.Lock()
+	for cmd, h := range a.handlers {
+		handlers[cmd] = h
+	}
+	a.mu.Unlock()
+
+	for cmd, h := range handlers {
+		cmd, h := cmd, h
+		handlers[cmd] = func(ctx context.Context, pipe *common.Pipe, state interface{}, params string) error {
+			a.mu.Lock()
+			if params != "" {
+				a.cmds = append(a.cmds, cmd+" "+params)
+			} else {
+				a.cmds = append(a.cmds, cmd)
+			}
+			a.mu.Unlock()
+			return h(ctx, pipe, state, params)
+		}
+	}
+
+	return server.ProtoInfo{
+		Greeting:        "Pleased to meet you, process " + strconv.Itoa(a.PID),
+		Handlers:        handlers,
+		GetDefaultState: func() interface{} { return &sessionState{} },
+		SetOption: func(_ interface{}, key, val string) error {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			a.cmds = append(a.cmds, "OPTION "+key+"="+val)
+			return nil
+		},
+	}
+}
+
+// Serve handles single session on stream.
+func (a *Agent) Serve(stream io.ReadWriter) error {
+	return server.Serve(stream, a.Proto())
+}
+
+// ListenUnix starts serving connections on unix socket.
+func (a *Agent) ListenUnix(path string) error {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	a.start(l)
+	return nil
+}
+
+// ListenAssuanSocket starts serving connections on Assuan socket file, see
+// server.ListenAssuanSocket. Socket file is removed by Close.
+func (a *Agent) ListenAssuanSocket(path string) error {
+	l, err := server.ListenAssuanSocket(path)
+	if err != nil {
+		return err
+	}
+	a.start(l)
+	return nil
+}
+
+// Close stops serving, terminates all sessions and removes socket file.
+func (a *Agent) Close() error {
+	return a.srv.Close()
+}
+
+func (a *Agent) start(l net.Listener) {
+	a.srv.Proto = a.Proto()
+	go func() {
+		if err := a.srv.Serve(context.Background(), l); err != nil && !errors.Is(err, server.ErrServerClosed) {
+			log.Println("Fake gpg-agent listener fail:", err)
+		}
+	}()
+}
+
+func (a *Agent) findKey(keygrip string) (Key, bool) {
+	for _, k := range a.Keys {
+		if strings.EqualFold(k.Info.Keygrip, keygrip) {
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+func noSecKey() *common.Error {
+	return &common.Error{
+		Src: common.ErrSrcGPGagent, Code: common.ErrNoSeckey,
+		SrcName: "GPG Agent", Message: "No secret key",
+	}
+}
+
+func missingValue(msg string) *common.Error {
+	return &common.Error{
+		Src: common.ErrSrcGPGagent, Code: common.ErrMissingValue,
+		SrcName: "GPG Agent", Message: msg,
+	}
+}
+
+func (a *Agent) getInfo(_ context.Context, pipe *common.Pipe, _ interface{}, params string) error {
+	var res string
+	switch strings.TrimSpace(params) {
+	case "version":
+		res = a.Version
+	case "pid":
+		res = strconv.Itoa(a.PID)
+	case "socket_name":
+		res = a.SocketName
+	case "ssh_socket_name":
+		res = a.SSHSocketName
+	default:
+		return &common.Error{
+			Src: common.ErrSrcGPGagent, Code: common.ErrAssParameter,
+			SrcName: "GPG Agent", Message: "unknown value for WHAT",
+		}
+	}
+	return pipe.WriteData([]byte(res))
+}
+
+func (a *Agent) keyInfo(_ context.Context, pipe *common.Pipe, _ interface{}, params string) error {
+	var list, sshList, sshFpr bool
+	var grips []string
+	for _, f := range strings.Fields(params) {
+		switch f {
+		case "--list":
+			list = true
+		case "--ssh-list":
+			sshList = true
+		case "--ssh-fpr":
+			sshFpr = true
+		default:
+			if !strings.HasPrefix(f, "--") {
+				grips = append(grips, f)
+			}
+		}
+	}
+
+	var keys []Key
+	switch {
+	case list || sshList:
+		for _, k := range a.Keys {
+			if !sshList || k.Info.SSHControl {
+				keys = append(keys, k)
+			}
+		}
+	case len(grips) == 1:
+		k, ok := a.findKey(grips[0])
+		if !ok {
+			return &common.Error{
+				Src: common.ErrSrcGPGagent, Code: common.ErrNotFound,
+				SrcName: "GPG Agent", Message: "Not found",
+			}
+		}
+		keys = append(keys, k)
+	default:
+		return missingValue("Missing value")
+	}
+
+	for _, k := range keys {
+		ki := k.Info
+		if !sshFpr {
+			ki.Fingerprint = ""
+		}
+		if err := server.SendStatus(pipe, "KEYINFO", ki.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Agent) haveKey(_ context.Context, _ *common.Pipe, _ interface{}, params string) error {
+	for _, grip := range strings.Fields(params) {
+		if _, ok := a.findKey(grip); ok {
+			return nil
+		}
+	}
+	return noSecKey()
+}
+
+func (a *Agent) readKey(_ context.Context, pipe *common.Pipe, _ interface{}, params string) error {
+	k, ok := a.findKey(strings.TrimSpace(params))
+	if !ok {
+		return noSecKey()
+	}
+	return pipe.WriteData(k.PublicKey)
+}
+
+func (a *Agent) sigKey(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
+	grip := strings.TrimSpace(params)
+	if _, ok := a.findKey(grip); !ok {
+		return noSecKey()
+	}
+	state.(*sessionState).sigKey = grip
+	return nil
+}
+
+func (a *Agent) setHash(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
+	fields := strings.Fields(params)
+	if len(fields) != 2 {
+		return missingValue("Missing value")
+	}
+	algo, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return &common.Error{
+			Src: common.ErrSrcGPGagent, Code: common.ErrDigestAlgo,
+			SrcName: "GPG Agent", Message: "Invalid digest algorithm",
+		}
+	}
+	digest, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return &common.Error{
+			Src: common.ErrSrcGPGagent, Code: common.ErrAssParameter,
+			SrcName: "GPG Agent", Message: "Invalid digest",
+		}
+	}
+	st := state.(*sessionState)
+	st.hashAlgo, st.digest = algo, digest
+	return nil
+}
+
+func (a *Agent) pkSign(_ context.Context, pipe *common.Pipe, state interface{}, _ string) error {
+	st := state.(*sessionState)
+	if st.sigKey == "" || st.digest == nil {
+		return missingValue("Missing key or hash")
+	}
+
+	var sig []byte
+	if a.Sign != nil {
+		var err error
+		if sig, err = a.Sign(st.sigKey, st.hashAlgo, st.digest); err != nil {
+			return &common.Error{
+				Src: common.ErrSrcGPGagent, Code: common.ErrGeneral,
+				SrcName: "GPG Agent", Message: "General error",
+			}
+		}
+	} else {
+		sig = []byte(fmt.Sprintf("(7:sig-val(3:rsa(1:s%d:%s)))", len(st.digest), st.digest))
+	}
+	return pipe.WriteData(sig)
+}
+
+func (a *Agent) reset(_ context.Context, _ *common.Pipe, state interface{}, _ string) error {
+	*(state.(*sessionState)) = sessionState{}
+	return nil
+}
+
+func (a *Agent) reloadAgent(context.Context, *common.Pipe, interface{}, string) error {
+	a.mu.Lock()
+	a.reloads++
+	a.mu.Unlock()
+	return nil
+}
+
+// killAgent stops serving like real gpg-agent does: no new connections are
+// accepted and sessions are closed after current command.
+func (a *Agent) killAgent(context.Context, *common.Pipe, interface{}, string) error {
+	a.killOnce.Do(func() {
+		close(a.killed)
+		go func() { _ = a.srv.Shutdown(context.Background()) }()
+	})
+	return nil
+}
@@ -0,0 +1,129 @@
+package gpgagenttest_test
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/client"
+	"github.com/rupor-github/win-gpg-agent/gpgagent"
+	"github.com/rupor-github/win-gpg-agent/gpgagent/gpgagenttest"
+)
+
+const testGrip = "0123456789ABCDEF0123456789ABCDEF01234567"
+
+func newAgent() *gpgagenttest.Agent {
+	a := gpgagenttest.New()
+	a.Keys = []gpgagenttest.Key{{
+		Info:      gpgagent.KeyInfo{Keygrip: testGrip, Type: gpgagent.KeyTypeDisk, Protection: "P"},
+		PublicKey: []byte("(10:public-key(3:rsa))"),
+	}}
+	return a
+}
+
+func TestAgent_ListenUnix(t *testing.T) {
+	a := newAgent()
+	path := filepath.Join(t.TempDir(), "S.gpg-agent")
+	if err := a.ListenUnix(path); err != nil {
+		t.Fatal("Unexpected error on ListenUnix:", err)
+	}
+	defer a.Close()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal("Unexpected dial error:", err)
+	}
+	c, err := gpgagent.New(conn)
+	if err != nil {
+		t.Fatal("Unexpected error on gpgagent.New:", err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	if v, err := c.Version(ctx); err != nil || v != a.Version {
+		t.Error("Unexpected version:", v, err)
+	}
+	sig, err := c.Sign(ctx, testGrip, crypto.SHA1, []byte("0123456789abcdefghij"))
+	if err != nil || string(sig) != "(7:sig-val(3:rsa(1:s20:0123456789abcdefghij)))" {
+		t.Error("Unexpected signature:", string(sig), err)
+	}
+	if _, err := c.Sign(ctx, "NOSUCHKEY", crypto.SHA1, []byte("0123456789abcdefghij")); err == nil {
+		t.Error("Expected error signing with unknown key")
+	}
+	if err := c.ReloadAgent(ctx); err != nil || a.Reloads() != 1 {
+		t.Error("Unexpected reload result:", a.Reloads(), err)
+	}
+}
+
+func TestAgent_ListenAssuanSocket(t *testing.T) {
+	a := newAgent()
+	path := filepath.Join(t.TempDir(), "S.gpg-agent")
+	if err := a.ListenAssuanSocket(path); err != nil {
+		t.Fatal("Unexpected error on ListenAssuanSocket:", err)
+	}
+
+	c, err := gpgagent.Dial(path)
+	if err != nil {
+		t.Fatal("Unexpected error on gpgagent.Dial:", err)
+	}
+	ctx := context.Background()
+	if list, err := c.KeyInfoList(ctx); err != nil || len(list) != 1 || list[0] != a.Keys[0].Info {
+		t.Error("Unexpected key list:", list, err)
+	}
+	if err := c.KillAgent(ctx); err != nil {
+		t.Error("Unexpected error on KillAgent:", err)
+	}
+	select {
+	case <-a.Killed():
+	case <-time.After(time.Second):
+		t.Error("Agent was not killed")
+	}
+	c.Close()
+
+	if _, err := client.Dial(path); err == nil {
+		t.Error("Killed agent should not accept connections")
+	}
+
+	if err := a.Close(); err != nil {
+		t.Error("Unexpected error on Close:", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Socket file was not removed:", err)
+	}
+}
+
+func TestAgent_InvalidNonce(t *testing.T) {
+	a := newAgent()
+	path := filepath.Join(t.TempDir(), "S.gpg-agent")
+	if err := a.ListenAssuanSocket(path); err != nil {
+		t.Fatal("Unexpected error on ListenAssuanSocket:", err)
+	}
+	defer a.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal("Unable to read socket file:", err)
+	}
+	port := string(data[:len(data)-17])
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Fatal("Unexpected dial error:", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(make([]byte, 16)); err != nil {
+		t.Fatal("Unexpected write error:", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 64)); !errors.Is(err, io.EOF) {
+		t.Error("Connection with invalid nonce was not rejected:", err)
+	}
+}
@@ -0,0 +1,116 @@
+package gpgagent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KeyType tells where secret key is stored.
+type KeyType string
+
+// Key types reported by KEYINFO.
+const (
+	KeyTypeDisk    KeyType = "D" // key is stored on disk
+	KeyTypeCard    KeyType = "T" // key is stored on smart card
+	KeyTypeUnknown KeyType = "X" // key type is unknown
+	KeyTypeMissing KeyType = "-" // key is missing
+)
+
+// KeyInfo is information about a key as reported by KEYINFO command:
+//
+//	S KEYINFO <keygrip> <type> <serialno> <idstr> <cached> <protection> <fpr> <ttl> <flags>
+//
+// Fields which are not available ("-") are left empty.
+type KeyInfo struct {
+	Keygrip string
+	Type    KeyType
+	// SerialNo and IDStr identify smart card and key on it.
+	SerialNo string
+	IDStr    string
+	// Cached is true when passphrase for the key is cached.
+	Cached bool
+	// Protection is "P" for protected key, "C" for unprotected one, empty if
+	// unknown.
+	Protection string
+	// Fingerprint is ssh fingerprint of the key, only set when requested.
+	Fingerprint string
+	// TTL is cache TTL in seconds from sshcontrol file, 0 if not set.
+	TTL int
+	// Flags from sshcontrol file.
+	Disabled   bool
+	SSHControl bool
+	Confirm    bool
+}
+
+// ParseKeyInfo parses parameters of KEYINFO status line.
+func ParseKeyInfo(params string) (KeyInfo, error) {
+	fields := strings.Fields(params)
+	if len(fields) < 7 {
+		return KeyInfo{}, fmt.Errorf("malformed KEYINFO line: %s", params)
+	}
+
+	opt := func(i int) string {
+		if i >= len(fields) || fields[i] == "-" {
+			return ""
+		}
+		return fields[i]
+	}
+
+	ki := KeyInfo{
+		Keygrip:     fields[0],
+		Type:        KeyType(fields[1]),
+		SerialNo:    opt(2),
+		IDStr:       opt(3),
+		Cached:      fields[4] == "1",
+		Protection:  opt(5),
+		Fingerprint: opt(6),
+	}
+	if ttl := opt(7); ttl != "" {
+		var err error
+		if ki.TTL, err = strconv.Atoi(ttl); err != nil {
+			return KeyInfo{}, fmt.Errorf("malformed KEYINFO ttl \"%s\": %w", ttl, err)
+		}
+	}
+	for _, f := range opt(8) {
+		switch f {
+		case 'D':
+			ki.Disabled = true
+		case 'S':
+			ki.SSHControl = true
+		case 'c':
+			ki.Confirm = true
+		}
+	}
+	return ki, nil
+}
+
+// String formats KeyInfo the same way gpg-agent does in KEYINFO status line.
+func (ki KeyInfo) String() string {
+	dash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	cached, ttl, flags := "-", "-", ""
+	if ki.Cached {
+		cached = "1"
+	}
+	if ki.TTL != 0 {
+		ttl = strconv.Itoa(ki.TTL)
+	}
+	if ki.Disabled {
+		flags += "D"
+	}
+	if ki.SSHControl {
+		flags += "S"
+	}
+	if ki.Confirm {
+		flags += "c"
+	}
+	return strings.Join([]string{
+		ki.Keygrip, string(ki.Type), dash(ki.SerialNo), dash(ki.IDStr), cached,
+		dash(ki.Protection), dash(ki.Fingerprint), ttl, dash(flags),
+	}, " ")
+}
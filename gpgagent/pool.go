@@ -0,0 +1,277 @@
+package gpgagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/client"
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+)
+
+// ErrPoolClosed is returned by Pool.Do after pool is closed.
+var ErrPoolClosed = errors.New("gpg-agent pool is closed")
+
+// Health describes state of connections to gpg-agent.
+type Health struct {
+	// OK is true when last dial or command reached gpg-agent.
+	OK bool
+	// LastError is the last dial or I/O error, it is kept after agent
+	// becomes reachable again.
+	LastError error
+	// Changed is the time OK changed last.
+	Changed time.Time
+	// Dials is number of connections established so far.
+	Dials int
+	// Open is number of established sessions, Idle - number of sessions
+	// not used at the moment.
+	Open, Idle int
+}
+
+// Pool is a goroutine safe set of gpg-agent sessions. Each session is used by
+// one caller at a time, so independent commands could run in parallel.
+//
+// Sessions are dialed on demand, socket file is read anew every time, so pool
+// keeps working after gpg-agent restarts with new port and nonce. Options are
+// sent to every new session before it is used.
+type Pool struct {
+	addr    string
+	options []string
+	sem     chan struct{}
+
+	mu     sync.Mutex
+	idle   []*Client
+	open   int
+	closed bool
+	health Health
+}
+
+// NewPool creates pool of at most size sessions to gpg-agent endpoint (see
+// client.Dial for supported addresses). Options are in "name=value" form,
+// they are sent with OPTION command after every connect in specified order.
+func NewPool(addr string, size int, options ...string) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	return &Pool{
+		addr:    addr,
+		options: append([]string(nil), options...),
+		sem:     make(chan struct{}, size),
+	}
+}
+
+// Do calls fn with exclusive session, waiting for free session if all are
+// busy. Error returned by fn is returned as is.
+//
+// Sessions broken by I/O errors are dropped. If session was taken from idle
+// ones (agent may have been restarted since it was used last) and nothing was
+// received from agent before it broke, all idle sessions are dropped and fn is
+// called once again with freshly dialed session, so fn should not have side
+// effects beyond agent commands. Once agent started to reply fn is never
+// repeated.
+func (p *Pool) Do(ctx context.Context, fn func(*Client) error) error {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return common.ContextError(ctx.Err())
+	}
+	defer func() { <-p.sem }()
+
+	for {
+		c, reused, err := p.get(ctx)
+		if err != nil {
+			p.report(err)
+			return err
+		}
+		received := c.received()
+		err = fn(c)
+		if !broken(err) {
+			p.put(c)
+			p.report(nil)
+			return err
+		}
+		p.drop(c)
+		p.report(err)
+		if !reused || ctx.Err() != nil || c.received() != received {
+			return err
+		}
+		log.Println("Session to gpg-agent is broken, redialing:", err)
+		p.dropIdle()
+	}
+}
+
+// Ping checks that gpg-agent is reachable by sending NOP.
+func (p *Pool) Ping(ctx context.Context) error {
+	return p.Do(ctx, func(c *Client) error {
+		_, err := c.Session.SimpleCmdContext(ctx, "NOP", "")
+		return err
+	})
+}
+
+// Health returns current state of the pool.
+func (p *Pool) Health() Health {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := p.health
+	h.Open, h.Idle = p.open, len(p.idle)
+	return h
+}
+
+// Close ends idle sessions, sessions in use are closed when released.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	p.dropIdle()
+	return nil
+}
+
+// broken reports whether error means session could not be used any more.
+// Only I/O errors break session: ERR from agent, cancellation (Session discards
+// late responses itself) and errors of fn itself (like unexpected response)
+// leave it usable.
+func broken(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, common.ErrAssReadError) || errors.Is(err, common.ErrAssWriteError) {
+		return true
+	}
+	var perr common.Error
+	if errors.As(err, &perr) || errors.Is(err, common.ErrCanceled) || errors.Is(err, common.ErrTimeout) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrClosed) {
+		return true
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr) && !nerr.Timeout()
+}
+
+// countingConn counts bytes received from agent, so pool could tell whether
+// agent started to reply before session broke.
+type countingConn struct {
+	net.Conn
+	n int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// received returns number of bytes read from session dialed by pool.
+func (c *Client) received() int64 {
+	if cc, ok := c.conn.(*countingConn); ok {
+		return atomic.LoadInt64(&cc.n)
+	}
+	return 0
+}
+
+// get returns idle session or dials new one.
+func (p *Pool) get(ctx context.Context) (c *Client, reused bool, err error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, false, ErrPoolClosed
+	}
+	if n := len(p.idle); n > 0 {
+		c = p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return c, true, nil
+	}
+	p.mu.Unlock()
+
+	if c, err = p.dial(ctx); err != nil {
+		return nil, false, err
+	}
+	p.mu.Lock()
+	p.open++
+	p.health.Dials++
+	p.mu.Unlock()
+	return c, false, nil
+}
+
+func (p *Pool) dial(ctx context.Context) (*Client, error) {
+	nc, err := client.DialContext(ctx, p.addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to dial assuan socket \"%s\": %w", p.addr, err)
+	}
+	conn := &countingConn{Conn: nc}
+	c, err := New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to init assuan session on \"%s\": %w", p.addr, err)
+	}
+	c.conn = conn
+
+	for _, opt := range p.options {
+		if _, err := c.Session.SimpleCmdContext(ctx, "OPTION", opt); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("unable to set option \"%s\" on \"%s\": %w", opt, p.addr, err)
+		}
+	}
+	return c, nil
+}
+
+// put returns session to the pool.
+func (p *Pool) put(c *Client) {
+	p.mu.Lock()
+	if !p.closed {
+		p.idle = append(p.idle, c)
+		p.mu.Unlock()
+		return
+	}
+	p.open--
+	p.mu.Unlock()
+	c.Close()
+}
+
+// drop closes broken session.
+func (p *Pool) drop(c *Client) {
+	p.mu.Lock()
+	p.open--
+	p.mu.Unlock()
+	c.Close()
+}
+
+// dropIdle closes all idle sessions, they are likely stale too.
+func (p *Pool) dropIdle() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.open -= len(idle)
+	p.mu.Unlock()
+
+	for _, c := range idle {
+		c.Close()
+	}
+}
+
+// report updates health after agent was (or was not) reached.
+func (p *Pool) report(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil && errors.Is(err, ErrPoolClosed) {
+		return
+	}
+	ok := err == nil
+	if ok != p.health.OK || p.health.Changed.IsZero() {
+		p.health.Changed = time.Now()
+	}
+	p.health.OK = ok
+	if err != nil {
+		p.health.LastError = err
+	}
+}
@@ -0,0 +1,159 @@
+package gpgagent_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+	"github.com/rupor-github/win-gpg-agent/gpgagent"
+	"github.com/rupor-github/win-gpg-agent/gpgagent/gpgagenttest"
+)
+
+func listenFakeAgent(t *testing.T, path string) *gpgagenttest.Agent {
+	t.Helper()
+
+	fa := gpgagenttest.New()
+	fa.Keys = testKeys
+	if err := fa.ListenUnix(path); err != nil {
+		t.Fatal("Unable to start fake agent:", err)
+	}
+	return fa
+}
+
+func options(fa *gpgagenttest.Agent) []string {
+	var opts []string
+	for _, cmd := range fa.Commands() {
+		if strings.HasPrefix(cmd, "OPTION ") {
+			opts = append(opts, cmd)
+		}
+	}
+	return opts
+}
+
+func TestPool_Parallel(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "S.gpg-agent")
+	fa := listenFakeAgent(t, sock)
+	defer fa.Close()
+
+	p := gpgagent.NewPool("unix:"+sock, 2, "ttyname=/dev/pts/1", "lc-ctype=C")
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- p.Do(context.Background(), func(c *gpgagent.Client) error {
+				list, err := c.KeyInfoList(context.Background())
+				if err == nil && len(list) != len(testKeys) {
+					t.Errorf("Expected %d keys, got %d", len(testKeys), len(list))
+				}
+				return err
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error("Unexpected error:", err)
+		}
+	}
+
+	h := p.Health()
+	if !h.OK || h.Open > 2 || h.Open != h.Idle || h.Dials != h.Open {
+		t.Errorf("Unexpected health: %+v", h)
+	}
+	if opts := options(fa); len(opts) != 2*h.Dials {
+		t.Errorf("Options were not sent to every session: %q", opts)
+	}
+
+	// ERR from agent does not break session.
+	err := p.Do(context.Background(), func(c *gpgagent.Client) error {
+		_, err := c.KeyInfo(context.Background(), missingGrip)
+		return err
+	})
+	if !errors.Is(err, common.ErrNotFound) {
+		t.Error("Expected not found error, got:", err)
+	}
+	if h2 := p.Health(); h2.Dials != h.Dials || !h2.OK {
+		t.Errorf("Session was dropped after ERR: %+v", h2)
+	}
+}
+
+func TestPool_Redial(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "S.gpg-agent")
+	fa := listenFakeAgent(t, sock)
+
+	p := gpgagent.NewPool(sock, 2, "ttyname=/dev/pts/1")
+	defer p.Close()
+
+	if err := p.Ping(context.Background()); err != nil {
+		t.Fatal("Unexpected ping error:", err)
+	}
+
+	// Agent goes away.
+	fa.Close()
+	if err := p.Ping(context.Background()); err == nil {
+		t.Fatal("Ping succeeded without agent")
+	}
+	if h := p.Health(); h.OK || h.LastError == nil || h.Open != 0 {
+		t.Errorf("Unexpected health: %+v", h)
+	}
+
+	// Agent is back, options are replayed on the new connection.
+	fa = listenFakeAgent(t, sock)
+	defer fa.Close()
+	if err := p.Ping(context.Background()); err != nil {
+		t.Fatal("Unexpected ping error after restart:", err)
+	}
+	if opts := options(fa); len(opts) != 1 || opts[0] != "OPTION ttyname=/dev/pts/1" {
+		t.Errorf("Options were not replayed: %q", opts)
+	}
+	if h := p.Health(); !h.OK || h.Dials != 2 {
+		t.Errorf("Unexpected health: %+v", h)
+	}
+
+	p.Close()
+	if err := p.Ping(context.Background()); !errors.Is(err, gpgagent.ErrPoolClosed) {
+		t.Error("Expected closed pool error, got:", err)
+	}
+}
+
+func TestPool_FnError(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "S.gpg-agent")
+	fa := listenFakeAgent(t, sock)
+	defer fa.Close()
+
+	p := gpgagent.NewPool(sock, 1)
+	defer p.Close()
+
+	if err := p.Ping(context.Background()); err != nil {
+		t.Fatal("Unexpected ping error:", err)
+	}
+
+	// Response agent sent is not what caller expects, session is fine.
+	calls := 0
+	parseErr := errors.New("no key information returned")
+	err := p.Do(context.Background(), func(c *gpgagent.Client) error {
+		calls++
+		if _, err := c.Session.SimpleCmd("NOP", ""); err != nil {
+			return err
+		}
+		return parseErr
+	})
+	if !errors.Is(err, parseErr) {
+		t.Error("Expected error returned by fn, got:", err)
+	}
+	if calls != 1 {
+		t.Errorf("fn was called %d times", calls)
+	}
+	if h := p.Health(); !h.OK || h.Dials != 1 || h.Open != 1 || h.Idle != 1 {
+		t.Errorf("Session was dropped after fn error: %+v", h)
+	}
+}
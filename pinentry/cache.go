@@ -0,0 +1,135 @@
+package pinentry
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+	"github.com/rupor-github/win-gpg-agent/assuan/server"
+)
+
+// PassphraseCache is external passphrase cache gpg-agent allows pinentry to
+// use (OPTION allow-external-password-cache). Passphrases are keyed by
+// SETKEYINFO value.
+type PassphraseCache interface {
+	// Get returns cached passphrase, ok is false if there is none.
+	Get(key string) (passphrase string, ok bool, err error)
+	// Put stores passphrase replacing existing one.
+	Put(key, passphrase string) error
+	// Delete removes passphrase, it is not an error if there is none.
+	Delete(key string) error
+	// List returns keys of cached passphrases.
+	List() ([]string, error)
+}
+
+// clearPassphrase returns CLEARPASSPHRASE handler removing passphrase from
+// cache, without cache there is nothing to do.
+func clearPassphrase(cache PassphraseCache) server.CommandHandler {
+	return func(_ context.Context, _ *common.Pipe, _ interface{}, params string) error {
+		if cache == nil {
+			return nil
+		}
+		if err := cache.Delete(strings.Trim(params, " ")); err != nil {
+			log.Printf("Unable to remove passphrase from cache: %s", err.Error())
+			return &common.Error{
+				Src: common.ErrSrcPinentry, Code: common.ErrAssGeneral,
+				SrcName: "pinentry", Message: "CLEARPASSPHRASE cannot access cache",
+			}
+		}
+		return nil
+	}
+}
+
+// Cached is a Prompter using external passphrase cache when client allows it
+// and key has stable identifier (SETKEYINFO). Cached passphrase is returned
+// without asking user unless previous one was rejected (SETERROR) or new
+// passphrase is requested. Passphrase is stored if user asked to (PIN.Save).
+type Cached struct {
+	Prompter
+	Cache PassphraseCache
+}
+
+// GetPIN implements Prompter.
+func (c *Cached) GetPIN(ctx context.Context, s *Settings) (PIN, error) {
+	if !s.Opts.AllowExtPasswdCache || len(s.KeyInfo) == 0 {
+		return c.Prompter.GetPIN(ctx, s)
+	}
+
+	if len(s.Error) == 0 && len(s.RepeatPrompt) == 0 {
+		// GnuPG calls it "reading from password cache" - let's try it
+		passwd, ok, err := c.Cache.Get(s.KeyInfo)
+		if err != nil {
+			log.Printf("Unable to read passphrase cache: %s", err.Error())
+			// do not offer to save passphrase either
+			s.Opts.AllowExtPasswdCache = false
+		}
+		// we never store empty passphrase
+		if ok && len(passwd) > 0 {
+			return PIN{Value: passwd, FromCache: true}, nil
+		}
+	}
+
+	pin, err := c.Prompter.GetPIN(ctx, s)
+	if err != nil {
+		return pin, err
+	}
+	if pin.Save && s.Opts.AllowExtPasswdCache && len(pin.Value) > 0 {
+		if err := c.Cache.Put(s.KeyInfo, pin.Value); err != nil {
+			log.Printf("Unable to store passphrase for %s: %s", s.KeyInfo, err.Error())
+		}
+	}
+	return pin, nil
+}
+
+// MemoryCache keeps passphrases in memory for lifetime of the process, it is
+// mostly useful for tests.
+type MemoryCache struct {
+	mu    sync.Mutex
+	items map[string]string
+}
+
+// Get implements PassphraseCache.
+func (m *MemoryCache) Get(key string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	passwd, ok := m.items[key]
+	return passwd, ok, nil
+}
+
+// Put implements PassphraseCache.
+func (m *MemoryCache) Put(key, passphrase string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.items == nil {
+		m.items = make(map[string]string)
+	}
+	m.items[key] = passphrase
+	return nil
+}
+
+// Delete implements PassphraseCache.
+func (m *MemoryCache) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.items, key)
+	return nil
+}
+
+// List implements PassphraseCache.
+func (m *MemoryCache) List() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return sortedKeys(m.items), nil
+}
+
+func sortedKeys(items map[string]string) []string {
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
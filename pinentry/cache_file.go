@@ -0,0 +1,185 @@
+package pinentry
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CacheKeySize is size of FileCache key (AES-256).
+const CacheKeySize = 32
+
+// Additional authenticated data, makes file format recognizable.
+var fileCacheAD = []byte("PinGO passphrase cache v1")
+
+// FileCache keeps passphrases in a file encrypted with AES-GCM. Whole file is
+// rewritten on every change, so concurrent pinentry processes could lose
+// each other updates - last one wins.
+type FileCache struct {
+	path string
+	aead cipher.AEAD
+	mu   sync.Mutex
+}
+
+// NewFileCache creates cache stored in file at path encrypted with key of
+// CacheKeySize bytes. File is created on first Put.
+func NewFileCache(path string, key []byte) (*FileCache, error) {
+	if len(key) != CacheKeySize {
+		return nil, fmt.Errorf("cache key must be %d bytes, got %d", CacheKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &FileCache{path: path, aead: aead}, nil
+}
+
+// LoadCacheKey reads FileCache key from file, new random key is generated and
+// saved if file does not exist. Key file should be protected no worse than
+// passphrases themselves.
+func LoadCacheKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) != CacheKeySize {
+			return nil, fmt.Errorf("bad cache key size in %s", path)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	key = make([]byte, CacheKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// load reads and decrypts cache file, missing file is an empty cache.
+func (fc *FileCache) load() (map[string]string, error) {
+	data, err := os.ReadFile(fc.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	size := fc.aead.NonceSize()
+	if len(data) < size {
+		return nil, fmt.Errorf("cache file %s is truncated", fc.path)
+	}
+	plain, err := fc.aead.Open(nil, data[:size], data[size:], fileCacheAD)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt cache file %s: %w", fc.path, err)
+	}
+	items := map[string]string{}
+	if err := json.Unmarshal(plain, &items); err != nil {
+		return nil, fmt.Errorf("bad cache file %s: %w", fc.path, err)
+	}
+	return items, nil
+}
+
+// save encrypts items and replaces cache file.
+func (fc *FileCache) save(items map[string]string) error {
+	plain, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, fc.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data := fc.aead.Seal(nonce, nonce, plain, fileCacheAD)
+
+	if err := os.MkdirAll(filepath.Dir(fc.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fc.path), filepath.Base(fc.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fc.path)
+}
+
+// Get implements PassphraseCache.
+func (fc *FileCache) Get(key string) (string, bool, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	items, err := fc.load()
+	if err != nil {
+		return "", false, err
+	}
+	passwd, ok := items[key]
+	return passwd, ok, nil
+}
+
+// Put implements PassphraseCache.
+func (fc *FileCache) Put(key, passphrase string) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	items, err := fc.load()
+	if err != nil {
+		return err
+	}
+	items[key] = passphrase
+	return fc.save(items)
+}
+
+// Delete implements PassphraseCache.
+func (fc *FileCache) Delete(key string) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	items, err := fc.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := items[key]; !ok {
+		return nil
+	}
+	delete(items, key)
+	return fc.save(items)
+}
+
+// List implements PassphraseCache.
+func (fc *FileCache) List() ([]string, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	items, err := fc.load()
+	if err != nil {
+		return nil, err
+	}
+	return sortedKeys(items), nil
+}
@@ -0,0 +1,165 @@
+package pinentry_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/assuantest"
+	"github.com/rupor-github/win-gpg-agent/pinentry"
+)
+
+func testCache(t *testing.T, c pinentry.PassphraseCache) {
+	t.Helper()
+
+	if _, ok, err := c.Get("n/A"); ok || err != nil {
+		t.Errorf("Unexpected passphrase in empty cache: %v, %v", ok, err)
+	}
+	if err := c.Delete("n/A"); err != nil {
+		t.Error("Unexpected error deleting missing passphrase:", err)
+	}
+	for _, kv := range [][2]string{{"n/A", "first"}, {"n/B", "second"}, {"n/A", "third"}} {
+		if err := c.Put(kv[0], kv[1]); err != nil {
+			t.Fatal("Unable to store passphrase:", err)
+		}
+	}
+	if p, ok, err := c.Get("n/A"); !ok || err != nil || p != "third" {
+		t.Errorf("Unexpected passphrase: %q, %v, %v", p, ok, err)
+	}
+	if keys, err := c.List(); err != nil || strings.Join(keys, ",") != "n/A,n/B" {
+		t.Errorf("Unexpected keys: %q, %v", keys, err)
+	}
+	if err := c.Delete("n/A"); err != nil {
+		t.Error("Unable to delete passphrase:", err)
+	}
+	if _, ok, err := c.Get("n/A"); ok || err != nil {
+		t.Errorf("Deleted passphrase is still cached: %v, %v", ok, err)
+	}
+	if keys, err := c.List(); err != nil || strings.Join(keys, ",") != "n/B" {
+		t.Errorf("Unexpected keys: %q, %v", keys, err)
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	testCache(t, &pinentry.MemoryCache{})
+}
+
+func TestFileCache(t *testing.T) {
+	dir := t.TempDir()
+	path, keyPath := filepath.Join(dir, "pinentry.cache"), filepath.Join(dir, "keys", "pinentry.key")
+
+	key, err := pinentry.LoadCacheKey(keyPath)
+	if err != nil {
+		t.Fatal("Unable to create key:", err)
+	}
+	if fi, err := os.Stat(keyPath); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Unexpected key file: %v, %v", fi, err)
+	}
+	if again, err := pinentry.LoadCacheKey(keyPath); err != nil || !bytes.Equal(key, again) {
+		t.Error("Key was not loaded from file:", err)
+	}
+
+	fc, err := pinentry.NewFileCache(path, key)
+	if err != nil {
+		t.Fatal("Unable to create cache:", err)
+	}
+	testCache(t, fc)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal("Unable to read cache file:", err)
+	}
+	if bytes.Contains(data, []byte("second")) || bytes.Contains(data, []byte("n/B")) {
+		t.Error("Cache file is not encrypted")
+	}
+
+	// Passphrases survive restart.
+	if fc, err = pinentry.NewFileCache(path, key); err != nil {
+		t.Fatal("Unable to reopen cache:", err)
+	}
+	if p, ok, err := fc.Get("n/B"); !ok || err != nil || p != "second" {
+		t.Errorf("Unexpected passphrase after reopening: %q, %v, %v", p, ok, err)
+	}
+
+	// Wrong key is detected.
+	other := make([]byte, pinentry.CacheKeySize)
+	if fc, err = pinentry.NewFileCache(path, other); err != nil {
+		t.Fatal("Unable to create cache:", err)
+	}
+	if _, _, err := fc.Get("n/B"); err == nil {
+		t.Error("Expected decryption error")
+	}
+	if _, err := pinentry.NewFileCache(path, key[:16]); err == nil {
+		t.Error("Expected key size error")
+	}
+}
+
+// saving asks to keep every passphrase it returns, like checked "Remember
+// me" box in credentials dialog.
+type saving struct {
+	*pinentry.Script
+}
+
+func (s saving) GetPIN(ctx context.Context, st *pinentry.Settings) (pinentry.PIN, error) {
+	pin, err := s.Script.GetPIN(ctx, st)
+	pin.Save = true
+	return pin, err
+}
+
+const cachedScript = `
+S: OK PinGO (w32)
+C: GETPIN
+S: D nokey
+S: OK
+C: OPTION allow-external-password-cache
+S: OK
+C: SETKEYINFO n/0123456789ABCDEF
+S: OK
+C: GETPIN
+S: D secret
+S: OK
+C: GETPIN
+S: S PASSWORD_FROM_CACHE
+S: D secret
+S: OK
+C: SETREPEAT Repeat:
+S: OK
+C: GETPIN
+S: S PIN_REPEATED
+S: D changed
+S: OK
+C: CLEARPASSPHRASE n/0123456789ABCDEF
+S: OK
+C: SETREPEAT
+S: OK
+C: GETPIN
+S: D again
+S: OK
+`
+
+func TestCached(t *testing.T) {
+	s, err := assuantest.ParseScript("cached", strings.NewReader(cachedScript))
+	if err != nil {
+		t.Fatal("Unable to parse script:", err)
+	}
+	cache := &pinentry.MemoryCache{}
+	sc := pinentry.NewScript("PIN nokey", "PIN secret", "PIN changed", "PIN again")
+	callbacks := pinentry.PrompterCallbacks(&pinentry.Cached{Prompter: saving{sc}, Cache: cache})
+	callbacks.Cache = cache
+	if err := assuantest.RunServer(s, pinentry.Proto(callbacks, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(sc.Shown()); n != 4 {
+		t.Errorf("Expected 4 prompts, got %d", n)
+	}
+	if keys, _ := cache.List(); strings.Join(keys, ",") != "n/0123456789ABCDEF" {
+		t.Errorf("Unexpected keys cached: %q", keys)
+	}
+	if p, _, _ := cache.Get("n/0123456789ABCDEF"); p != "again" {
+		t.Errorf("Unexpected passphrase cached: %q", p)
+	}
+}
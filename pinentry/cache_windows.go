@@ -0,0 +1,67 @@
+package pinentry
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/rupor-github/win-gpg-agent/wincred"
+)
+
+// WinCredCache keeps passphrases in Windows Credential Manager as generic
+// credentials named with CredentialName.
+type WinCredCache struct{}
+
+// Get implements PassphraseCache.
+func (WinCredCache) Get(key string) (string, bool, error) {
+	cred, err := wincred.GetGenericCredential(CredentialName(key))
+	if err != nil {
+		if errors.Is(err, windows.ERROR_NOT_FOUND) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	if cred == nil {
+		// this should never happen, but just in case
+		return "", false, nil
+	}
+	return string(cred.CredentialBlob), true, nil
+}
+
+// Put implements PassphraseCache.
+func (WinCredCache) Put(key, passphrase string) error {
+	cred := wincred.NewGenericCredential(CredentialName(key))
+	cred.CredentialBlob = []byte(passphrase)
+	cred.Persist = wincred.PersistLocalMachine
+	return cred.Write()
+}
+
+// Delete implements PassphraseCache.
+func (WinCredCache) Delete(key string) error {
+	cred, err := wincred.GetGenericCredential(CredentialName(key))
+	if err != nil {
+		if errors.Is(err, windows.ERROR_NOT_FOUND) {
+			return nil
+		}
+		return err
+	}
+	if cred == nil {
+		return nil
+	}
+	return cred.Delete()
+}
+
+// List implements PassphraseCache.
+func (WinCredCache) List() ([]string, error) {
+	prefix := CredentialName("")
+	creds, err := wincred.FilteredList(prefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(creds))
+	for _, cred := range creds {
+		keys = append(keys, strings.TrimPrefix(cred.TargetName, prefix))
+	}
+	return keys, nil
+}
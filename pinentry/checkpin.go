@@ -0,0 +1,33 @@
+package pinentry
+
+import (
+	"strings"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+)
+
+// checkPIN implements Settings.CheckPIN with INQUIRE CHECKPIN. Client sends
+// back nothing if passphrase satisfies constraints and description of the
+// problem otherwise.
+func (in *inquirer) checkPIN(pin string) string {
+	res, err := in.inquire("CHECKPIN", pin)
+	if err != nil {
+		in.pipe.Logf(common.LogError, "... passphrase check failed: %s", err)
+		return ""
+	}
+	return strings.TrimSpace(string(res))
+}
+
+// CheckPassphrase asks client (gpg-agent) whether new passphrase satisfies
+// constraints (OPTION constraints-enforce). It returns error message to show
+// to user, empty if passphrase is acceptable or no check is needed.
+func (s *Settings) CheckPassphrase(pin string) string {
+	if s.CheckPIN == nil {
+		return ""
+	}
+	hint := s.CheckPIN(pin)
+	if len(hint) != 0 && len(s.Opts.ConstraintsErrorTitle) != 0 {
+		hint = s.Opts.ConstraintsErrorTitle + ":\n" + hint
+	}
+	return hint
+}
@@ -1,9 +1,11 @@
 package pinentry
 
 import (
+	"context"
 	"io"
 	"os/exec"
 	"strconv"
+	"strings"
 	"time"
 
 	assuan "github.com/rupor-github/win-gpg-agent/assuan/client"
@@ -14,8 +16,7 @@ import (
 type Client struct {
 	Session *assuan.Session
 
-	current    Settings
-	qualityBar bool
+	current Settings
 }
 
 // Launch starts pinentry binary found in directories from PATH envvar and creates pinentry.Client for interaction with it.
@@ -146,6 +147,27 @@ func (c *Client) SetRepeatPrompt(text string) error {
 	return nil
 }
 
+// SetRepeatOK sends SETREPEATOK Assuan command and stores results.
+func (c *Client) SetRepeatOK(text string) error {
+	if _, err := c.Session.SimpleCmd("SETREPEATOK", text); err != nil {
+		return err
+	}
+	c.current.RepeatOK = text
+	return nil
+}
+
+// SetOption sends OPTION Assuan command and stores results in current
+// settings options. Options unknown to this package are sent but not stored,
+// options without value (constraints-enforce, formatted-passphrase) are sent
+// with empty value.
+func (c *Client) SetOption(name, value string) error {
+	if err := c.Session.Option(name, value); err != nil {
+		return err
+	}
+	_ = setOpt(&c.current, name, value)
+	return nil
+}
+
 // SetRepeatError sends SETREPEATERROR Assuan command and stores results.
 func (c *Client) SetRepeatError(text string) error {
 	if _, err := c.Session.SimpleCmd("SETREPEATERROR", text); err != nil {
@@ -161,15 +183,54 @@ func (c *Client) SetQualityBar(text string) error {
 		return err
 	}
 	c.current.QualityBar = text
-	c.qualityBar = true
 	return nil
 }
 
+// SetQualityBarToolTip sends SETQUALITYBAR_TT Assuan command and stores results.
+func (c *Client) SetQualityBarToolTip(text string) error {
+	if _, err := c.Session.SimpleCmd("SETQUALITYBAR_TT", text); err != nil {
+		return err
+	}
+	c.current.QualityBarToolTip = text
+	return nil
+}
+
+// SetGenPIN sends SETGENPIN Assuan command and stores results.
+func (c *Client) SetGenPIN(text string) error {
+	if _, err := c.Session.SimpleCmd("SETGENPIN", text); err != nil {
+		return err
+	}
+	c.current.GenPINLabel = text
+	return nil
+}
+
+// SetGenPINToolTip sends SETGENPIN_TT Assuan command and stores results.
+func (c *Client) SetGenPINToolTip(text string) error {
+	if _, err := c.Session.SimpleCmd("SETGENPIN_TT", text); err != nil {
+		return err
+	}
+	c.current.GenPINToolTip = text
+	return nil
+}
+
+// SetGenPINCallback stores passphrase generator, it is called when pinentry
+// sends INQUIRE GENPIN (generate action enabled with SETGENPIN is used).
+func (c *Client) SetGenPINCallback(callback func() (string, error)) {
+	c.current.GenPIN = callback
+}
+
 // SetPasswdQualityCallback stores quality check callback.
 func (c *Client) SetPasswdQualityCallback(callback func(string) int) {
 	c.current.PasswordQuality = callback
 }
 
+// SetCheckPINCallback stores passphrase constraints check callback, it is
+// called when pinentry sends INQUIRE CHECKPIN (OPTION constraints-enforce is
+// set) and returns error message for rejected passphrase.
+func (c *Client) SetCheckPINCallback(callback func(string) string) {
+	c.current.CheckPIN = callback
+}
+
 // Current returns a copy of current settings.
 func (c *Client) Current() Settings {
 	return c.current
@@ -201,91 +262,113 @@ func (c *Client) Apply(s Settings) error {
 	if err := c.SetTimeout(s.Timeout); err != nil {
 		return err
 	}
-	if err := c.SetRepeatPrompt(s.RepeatPrompt); err != nil {
-		return err
+	// SETREPEAT and SETQUALITYBAR enable features even without label, they
+	// are only sent when set.
+	if len(s.RepeatPrompt) != 0 {
+		if err := c.SetRepeatPrompt(s.RepeatPrompt); err != nil {
+			return err
+		}
 	}
 	if err := c.SetRepeatError(s.RepeatError); err != nil {
 		return err
 	}
-	if err := c.SetQualityBar(s.QualityBar); err != nil {
+	// Older programs do not know SETREPEATOK.
+	if len(s.RepeatOK) != 0 {
+		if err := c.SetRepeatOK(s.RepeatOK); err != nil {
+			return err
+		}
+	}
+	if len(s.QualityBar) != 0 {
+		if err := c.SetQualityBar(s.QualityBar); err != nil {
+			return err
+		}
+	}
+	if err := c.SetQualityBarToolTip(s.QualityBarToolTip); err != nil {
 		return err
 	}
+	// Generate action is only offered when label is set.
+	if len(s.GenPINLabel) != 0 {
+		if err := c.SetGenPIN(s.GenPINLabel); err != nil {
+			return err
+		}
+		if len(s.GenPINToolTip) != 0 {
+			if err := c.SetGenPINToolTip(s.GenPINToolTip); err != nil {
+				return err
+			}
+		}
+	}
 	c.current.PasswordQuality = s.PasswordQuality
+	c.current.CheckPIN = s.CheckPIN
+	c.current.GenPIN = s.GenPIN
 	return nil
 }
 
 // GetPIN shows window with password textbox, Cancel and Ok buttons.
 // Error is returned if Cancel is pressed.
 func (c *Client) GetPIN() (string, error) {
-	if c.qualityBar {
-		return c.getPINWithQualBar()
-	}
-
-	dat, err := c.Session.SimpleCmd("GETPIN", "")
+	dat, err := c.Session.TransactWith(context.Background(), "GETPIN", "", assuan.InquireFunc(c.inquire))
 	if err != nil {
 		return "", err
 	}
 	return string(dat), nil
 }
 
-func (c *Client) getPINWithQualBar() (string, error) {
-	// We will get requests in following form:
-	//  INQUIRE QUALITY password-here
-	// and we should respond with quality percentage,
-	// otherwise pinentry will hang.
-	// This is different from usual transaction so we have to use raw I/O.
-
-	defer func() { c.qualityBar = false }()
-
-	pipe := c.Session.Pipe
-	if err := pipe.WriteLine("GETPIN", ""); err != nil {
-		return "", err
-	}
-	for {
-		cmd, params, err := pipe.ReadLine()
+// inquire answers pinentry inquiries. When quality bar is enabled we will get
+// requests in following form:
+//
+//	INQUIRE QUALITY password-here
+//
+// and we should respond with quality percentage. With constraints enforced
+// new passphrase is sent with
+//
+//	INQUIRE CHECKPIN password-here
+//
+// and we respond with nothing if it is acceptable or error message otherwise.
+// Password is plus-escaped (spaces are sent as +, literal + as %2B). When
+// user asks to generate passphrase we get
+//
+//	INQUIRE GENPIN
+//
+// and respond with generated passphrase.
+func (c *Client) inquire(ctx context.Context, keyword string, _ string) (io.Reader, error) {
+	if keyword == "GENPIN" {
+		if c.current.GenPIN == nil {
+			return nil, &common.Error{
+				Src: common.ErrSrcPinentry, Code: common.ErrNotImplemented,
+				SrcName: "pinentry", Message: "passphrase generation is not supported",
+			}
+		}
+		pin, err := c.current.GenPIN()
 		if err != nil {
-			return "", err
+			return nil, err
 		}
+		return strings.NewReader(pin), nil
+	}
 
-		if cmd == "D" {
-			// We got password.
-
-			// Take OK from pipe.
-			if _, _, err := pipe.ReadLine(); err != nil {
-				return "", err
-			}
-
-			return params, nil
+	pin, err := common.PlusUnescape(assuan.RawInquiryArgs(ctx))
+	if err != nil {
+		return nil, &common.Error{
+			Src: common.ErrSrcPinentry, Code: common.ErrAssParameter,
+			SrcName: "pinentry", Message: "bad passphrase encoding in " + keyword,
 		}
-
-		if cmd == "INQUIRE" {
-			// params[8:] is
-			//  QUALITY password-here
-			//          ^~~~~~~~~~~~~
-			passwd := params[8:]
-
-			if c.current.PasswordQuality == nil {
-				if err := pipe.WriteLine("D", "0"); err != nil {
-					return "", err
-				}
-				if err := pipe.WriteLine("END", ""); err != nil {
-					return "", err
-				}
-				continue
-			}
-
-			quality := c.current.PasswordQuality(passwd)
-			if err := pipe.WriteLine("D", strconv.Itoa(quality)); err != nil {
-				return "", err
-			}
-			if err := pipe.WriteLine("END", ""); err != nil {
-				return "", err
-			}
+	}
+	switch keyword {
+	case "QUALITY":
+		quality := 0
+		if c.current.PasswordQuality != nil {
+			quality = c.current.PasswordQuality(pin)
 		}
-
-		if cmd == "ERR" {
-			return "", common.DecodeErrCmd(params)
+		return strings.NewReader(strconv.Itoa(quality)), nil
+	case "CHECKPIN":
+		hint := ""
+		if c.current.CheckPIN != nil {
+			hint = c.current.CheckPIN(pin)
 		}
+		return strings.NewReader(hint), nil
+	}
+	return nil, &common.Error{
+		Src: common.ErrSrcPinentry, Code: common.ErrAssNoInquireCb,
+		SrcName: "pinentry", Message: "unexpected inquiry " + keyword,
 	}
 }
 
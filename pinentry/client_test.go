@@ -0,0 +1,95 @@
+package pinentry_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/assuantest"
+	"github.com/rupor-github/win-gpg-agent/assuan/client"
+	"github.com/rupor-github/win-gpg-agent/pinentry"
+)
+
+// Passphrase with literal + and % is plus-escaped by pinentry.
+const clientInquireScript = `
+S: OK PinGO (w32)
+C: GETPIN
+S: INQUIRE QUALITY a%2Bb+c%25d
+C: D 42
+C: END
+S: INQUIRE CHECKPIN a%2Bb+c%25d
+C: END
+S: D a+b c%25d
+S: OK
+`
+
+func TestClient_Inquire(t *testing.T) {
+	s, err := assuantest.ParseScript("inquire", strings.NewReader(clientInquireScript))
+	if err != nil {
+		t.Fatal("Unable to parse script:", err)
+	}
+
+	var inquired []string
+	err = assuantest.RunClient(s, func(ses *client.Session) error {
+		c := &pinentry.Client{Session: ses}
+		c.SetPasswdQualityCallback(func(pin string) int {
+			inquired = append(inquired, pin)
+			return 42
+		})
+		c.SetCheckPINCallback(func(pin string) string {
+			inquired = append(inquired, pin)
+			return ""
+		})
+		pin, err := c.GetPIN()
+		if err != nil || pin != "a+b c%d" {
+			return fmt.Errorf("unexpected GETPIN result: %q (%v)", pin, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(inquired, "|") != "a+b c%d|a+b c%d" {
+		t.Errorf("Unexpected passphrases inquired: %q", inquired)
+	}
+}
+
+const clientGenPINScript = `
+S: OK PinGO (w32)
+C: SETGENPIN _Generate
+S: OK
+C: SETGENPIN_TT Suggest a random passphrase
+S: OK
+C: GETPIN
+S: INQUIRE GENPIN
+C: D abc def
+C: END
+S: S PIN_REPEATED
+S: D abc def
+S: OK
+`
+
+func TestClient_GenPIN(t *testing.T) {
+	s, err := assuantest.ParseScript("genpin", strings.NewReader(clientGenPINScript))
+	if err != nil {
+		t.Fatal("Unable to parse script:", err)
+	}
+	err = assuantest.RunClient(s, func(ses *client.Session) error {
+		c := &pinentry.Client{Session: ses}
+		if err := c.SetGenPIN("_Generate"); err != nil {
+			return err
+		}
+		if err := c.SetGenPINToolTip("Suggest a random passphrase"); err != nil {
+			return err
+		}
+		c.SetGenPINCallback(func() (string, error) { return "abc def", nil })
+		pin, err := c.GetPIN()
+		if err != nil || pin != "abc def" {
+			return fmt.Errorf("unexpected GETPIN result: %q (%v)", pin, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,120 @@
+package pinentry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os/exec"
+
+	assuan "github.com/rupor-github/win-gpg-agent/assuan/client"
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+)
+
+// External is a Prompter proxying prompts to another pinentry program (for
+// example pinentry-qt from Gpg4win). Program is started for every prompt and
+// killed when prompt is dismissed. Quality inquiries of the program are
+// forwarded to our client.
+type External struct {
+	Path string
+	Args []string
+}
+
+// start launches program and passes settings and options to it.
+func (e *External) start(ctx context.Context, s *Settings) (*Client, func(), error) {
+	cmd := exec.CommandContext(ctx, e.Path, e.Args...)
+	ses, err := assuan.InitCmd(cmd)
+	if err != nil {
+		if cmd.Process != nil {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		}
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+		return nil, nil, err
+	}
+	c := &Client{Session: ses}
+	done := func() {
+		_ = c.Close()
+		_ = cmd.Wait()
+	}
+
+	for _, opt := range s.Opts.assuanOptions() {
+		// Older programs may not know some options, it is not fatal.
+		if err := c.SetOption(opt[0], opt[1]); err != nil {
+			log.Printf("Pinentry %s does not accept option %s: %s", e.Path, opt[0], err.Error())
+		}
+	}
+	if err := c.Apply(*s); err != nil {
+		done()
+		return nil, nil, err
+	}
+	return c, done, nil
+}
+
+// GetPIN implements Prompter.
+func (e *External) GetPIN(ctx context.Context, s *Settings) (PIN, error) {
+	c, done, err := e.start(ctx, s)
+	if err != nil {
+		return PIN{}, err
+	}
+	defer done()
+
+	var (
+		pin      PIN
+		accepted *string
+	)
+	c.Session.OnStatus("PIN_REPEATED", func(string, string) { pin.Repeated = true })
+	if s.CheckPIN != nil {
+		// Program checks passphrase itself if it supports constraints,
+		// remember what was accepted so it is not checked again.
+		c.SetCheckPINCallback(func(p string) string {
+			hint := s.CheckPIN(p)
+			if len(hint) == 0 {
+				accepted = &p
+			}
+			return hint
+		})
+	}
+	dat, err := c.Session.TransactWith(ctx, "GETPIN", "", assuan.InquireFunc(c.inquire))
+	if err != nil {
+		return PIN{}, err
+	}
+	pin.Value = string(dat)
+	pin.Checked = accepted != nil && *accepted == pin.Value
+	return pin, nil
+}
+
+// Confirm implements Prompter.
+func (e *External) Confirm(ctx context.Context, s *Settings) (ConfirmResult, error) {
+	c, done, err := e.start(ctx, s)
+	if err != nil {
+		return ConfirmCancel, err
+	}
+	defer done()
+
+	_, err = c.Session.SimpleCmdContext(ctx, "CONFIRM", s.CmdArgs)
+	switch {
+	case err == nil:
+		return ConfirmOK, nil
+	case errors.Is(err, common.ErrNotConfirmed):
+		return ConfirmNotOK, nil
+	case errors.Is(err, common.ErrCanceled):
+		return ConfirmCancel, nil
+	}
+	return ConfirmCancel, err
+}
+
+// Message implements Prompter.
+func (e *External) Message(ctx context.Context, s *Settings) error {
+	c, done, err := e.start(ctx, s)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	_, err = c.Session.SimpleCmdContext(ctx, "MESSAGE", "")
+	return err
+}
@@ -0,0 +1,105 @@
+package pinentry
+
+import (
+	"bufio"
+	"crypto/rand"
+	_ "embed" // for wordlist
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+// EFF large wordlist for diceware passphrases (CC BY 3.0, Electronic Frontier
+// Foundation): https://www.eff.org/dice
+//
+//go:embed eff_large_wordlist.txt
+var effWordlist string
+
+var (
+	wordsOnce sync.Once
+	words     []string
+)
+
+// wordlist returns words from embedded list, dice rolls are dropped.
+func wordlist() []string {
+	wordsOnce.Do(func() {
+		scanner := bufio.NewScanner(strings.NewReader(effWordlist))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if i := strings.IndexByte(line, '\t'); i >= 0 {
+				line = line[i+1:]
+			}
+			if len(line) != 0 {
+				words = append(words, line)
+			}
+		}
+	})
+	return words
+}
+
+// Defaults for random character passphrases.
+const (
+	DefaultGenPINLength  = 24
+	DefaultGenPINCharset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// Generator produces random passphrases for SETGENPIN. If Words is positive
+// passphrase consists of that many words from embedded EFF wordlist (about
+// 12.9 bits of entropy per word) joined with Separator. Otherwise it is
+// Length characters from Charset (DefaultGenPINLength and
+// DefaultGenPINCharset are used when not set).
+type Generator struct {
+	Words     int
+	Separator string
+	Length    int
+	Charset   string
+}
+
+// DefaultGenerator is used when Callbacks.GenPIN is not set.
+var DefaultGenerator = Generator{Words: 6, Separator: " "}
+
+// Generate returns new random passphrase.
+func (g Generator) Generate() (string, error) {
+	if g.Words > 0 {
+		list := wordlist()
+		res := make([]string, g.Words)
+		for i := range res {
+			n, err := randIndex(len(list))
+			if err != nil {
+				return "", err
+			}
+			res[i] = list[n]
+		}
+		return strings.Join(res, g.Separator), nil
+	}
+
+	length, charset := g.Length, []rune(g.Charset)
+	if length <= 0 {
+		length = DefaultGenPINLength
+	}
+	if len(charset) == 0 {
+		charset = []rune(DefaultGenPINCharset)
+	}
+	if len(charset) < 2 {
+		return "", errors.New("passphrase charset is too small")
+	}
+	res := make([]rune, length)
+	for i := range res {
+		n, err := randIndex(len(charset))
+		if err != nil {
+			return "", err
+		}
+		res[i] = charset[n]
+	}
+	return string(res), nil
+}
+
+// randIndex returns uniformly distributed random number in [0, n).
+func randIndex(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
@@ -0,0 +1,42 @@
+package pinentry_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/rupor-github/win-gpg-agent/pinentry"
+)
+
+func TestGenerator(t *testing.T) {
+	pin, err := pinentry.DefaultGenerator.Generate()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if !regexp.MustCompile(`^[a-z-]+( [a-z-]+){5}$`).MatchString(pin) {
+		t.Errorf("Unexpected diceware passphrase: %q", pin)
+	}
+	other, _ := pinentry.DefaultGenerator.Generate()
+	if other == pin {
+		t.Errorf("Same passphrase generated twice: %q", pin)
+	}
+
+	pin, err = pinentry.Generator{Words: 3, Separator: "."}.Generate()
+	if err != nil || len(strings.Split(pin, ".")) < 3 {
+		t.Errorf("Unexpected passphrase: %q %v", pin, err)
+	}
+
+	pin, err = pinentry.Generator{Length: 40, Charset: "xyzüñ"}.Generate()
+	if err != nil || len([]rune(pin)) != 40 || strings.Trim(pin, "xyzüñ") != "" {
+		t.Errorf("Unexpected passphrase: %q %v", pin, err)
+	}
+
+	pin, err = pinentry.Generator{}.Generate()
+	if err != nil || len(pin) != pinentry.DefaultGenPINLength || strings.Trim(pin, pinentry.DefaultGenPINCharset) != "" {
+		t.Errorf("Unexpected passphrase: %q %v", pin, err)
+	}
+
+	if _, err := (pinentry.Generator{Charset: "a"}).Generate(); err == nil {
+		t.Error("Expected error for single character charset")
+	}
+}
@@ -0,0 +1,84 @@
+package pinentry
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Mnemonic splits label with "_" marking accelerator key (as in "_OK", "__"
+// is literal underscore) into text to show and lower case key, zero if
+// label has no mnemonic.
+func Mnemonic(label string) (text string, key rune) {
+	var b strings.Builder
+	runes := []rune(label)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == '_' && i+1 < len(runes) {
+			i++
+			if runes[i] != '_' && key == 0 {
+				key = unicode.ToLower(runes[i])
+			}
+		}
+		b.WriteRune(runes[i])
+	}
+	return b.String(), key
+}
+
+func firstOf(labels ...string) string {
+	for _, l := range labels {
+		if len(l) != 0 {
+			return l
+		}
+	}
+	return ""
+}
+
+// OkLabel returns label of OK button: set with SETOK, OPTION default-ok or
+// "_OK".
+func (s *Settings) OkLabel() string {
+	return firstOf(s.OkBtn, s.Opts.DefaultOK, "_OK")
+}
+
+// CancelLabel returns label of Cancel button: set with SETCANCEL, OPTION
+// default-cancel or "_Cancel".
+func (s *Settings) CancelLabel() string {
+	return firstOf(s.CancelBtn, s.Opts.DefaultCancel, "_Cancel")
+}
+
+// PromptLabel returns passphrase prompt: set with SETPROMPT, OPTION
+// default-prompt or "PIN:".
+func (s *Settings) PromptLabel() string {
+	return firstOf(s.Prompt, s.Opts.DefaultPrompt, "PIN:")
+}
+
+// groupSeparator separates groups of formatted passphrase. Like pinentry-qt we
+// use no-break space, so it can be told apart from spaces which are part of
+// passphrase.
+const groupSeparator = '\u00a0'
+
+// FormatPassphrase groups passphrase shown to user by five characters when
+// OPTION formatted-passphrase is set. Passphrases with spaces (made of words)
+// are left alone.
+func (s *Settings) FormatPassphrase(pin string) string {
+	if !s.Opts.FormattedPassphrase || strings.ContainsRune(pin, ' ') {
+		return pin
+	}
+	runes := []rune(pin)
+	var b strings.Builder
+	for i, r := range runes {
+		if i != 0 && i%5 == 0 {
+			b.WriteRune(groupSeparator)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// UnformatPassphrase removes group separators which end up in passphrase when
+// user copies formatted one. Ordinary spaces are kept, they may be part of
+// passphrase.
+func (s *Settings) UnformatPassphrase(pin string) string {
+	if !s.Opts.FormattedPassphrase {
+		return pin
+	}
+	return strings.ReplaceAll(pin, string(groupSeparator), "")
+}
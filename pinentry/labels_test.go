@@ -0,0 +1,65 @@
+package pinentry_test
+
+import (
+	"testing"
+
+	"github.com/rupor-github/win-gpg-agent/pinentry"
+)
+
+func TestMnemonic(t *testing.T) {
+	for _, tc := range []struct {
+		label, text string
+		key         rune
+	}{
+		{"_OK", "OK", 'o'},
+		{"Do _not trust", "Do not trust", 'n'},
+		{"snake__case", "snake_case", 0},
+		{"__init_Value", "_initValue", 'v'},
+		{"trailing_", "trailing_", 0},
+		{"", "", 0},
+	} {
+		text, key := pinentry.Mnemonic(tc.label)
+		if text != tc.text || key != tc.key {
+			t.Errorf("Mnemonic(%q) = %q, %q; expected %q, %q", tc.label, text, key, tc.text, tc.key)
+		}
+	}
+}
+
+func TestSettings_Labels(t *testing.T) {
+	var s pinentry.Settings
+	if s.OkLabel() != "_OK" || s.CancelLabel() != "_Cancel" || s.PromptLabel() != "PIN:" {
+		t.Errorf("Unexpected built-in labels: %q %q %q", s.OkLabel(), s.CancelLabel(), s.PromptLabel())
+	}
+	s.Opts.DefaultOK, s.Opts.DefaultCancel, s.Opts.DefaultPrompt = "_Okay", "_Abort", "Passphrase:"
+	if s.OkLabel() != "_Okay" || s.CancelLabel() != "_Abort" || s.PromptLabel() != "Passphrase:" {
+		t.Errorf("Default labels are ignored: %q %q %q", s.OkLabel(), s.CancelLabel(), s.PromptLabel())
+	}
+	s.OkBtn, s.CancelBtn, s.Prompt = "_Yes", "_No", "PIN"
+	if s.OkLabel() != "_Yes" || s.CancelLabel() != "_No" || s.PromptLabel() != "PIN" {
+		t.Errorf("Labels are ignored: %q %q %q", s.OkLabel(), s.CancelLabel(), s.PromptLabel())
+	}
+}
+
+func TestSettings_FormatPassphrase(t *testing.T) {
+	var s pinentry.Settings
+	if got := s.FormatPassphrase("abcdefghijkl"); got != "abcdefghijkl" {
+		t.Errorf("Passphrase formatted without option: %q", got)
+	}
+	s.Opts.FormattedPassphrase = true
+	for pin, expected := range map[string]string{
+		"abcdefghijkl":      "abcde\u00a0fghij\u00a0kl",
+		"abcde":             "abcde",
+		"üñïçødé":           "üñïçø\u00a0dé",
+		"correct horse bat": "correct horse bat",
+	} {
+		if got := s.FormatPassphrase(pin); got != expected {
+			t.Errorf("FormatPassphrase(%q) = %q, expected %q", pin, got, expected)
+		}
+	}
+	if got := s.UnformatPassphrase("abcde\u00a0fghij\u00a0kl"); got != "abcdefghijkl" {
+		t.Errorf("Unexpected unformatted passphrase: %q", got)
+	}
+	if got := s.UnformatPassphrase("correct horse\u00a0bat"); got != "correct horsebat" {
+		t.Errorf("Spaces should be kept in unformatted passphrase: %q", got)
+	}
+}
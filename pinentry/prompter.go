@@ -0,0 +1,194 @@
+package pinentry
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+	"github.com/rupor-github/win-gpg-agent/assuan/server"
+)
+
+// ErrUnavailable is wrapped by Prompter errors when prompt could not be shown
+// in current environment: there is no terminal, external program is missing,
+// etc. Chain tries next prompter in this case.
+var ErrUnavailable = errors.New("prompter is not available")
+
+// ConfirmResult is user answer to confirmation request.
+type ConfirmResult int
+
+// Possible answers, NOT OK is only possible when SETNOTOK label is set.
+const (
+	ConfirmOK ConfirmResult = iota
+	ConfirmNotOK
+	ConfirmCancel
+)
+
+func (r ConfirmResult) String() string {
+	switch r {
+	case ConfirmOK:
+		return "OK"
+	case ConfirmNotOK:
+		return "NOTOK"
+	case ConfirmCancel:
+		return "CANCEL"
+	}
+	return "UNKNOWN"
+}
+
+// PIN is passphrase obtained by Prompter.
+type PIN struct {
+	Value string
+	// Repeated is set when passphrase was entered twice or generated
+	// passphrase was accepted, client gets PIN_REPEATED status.
+	Repeated bool
+	// FromCache is set when passphrase was taken from external cache, client
+	// gets PASSWORD_FROM_CACHE status.
+	FromCache bool
+	// Save is set when user asked to keep passphrase in external cache.
+	Save bool
+	// Checked is set when passphrase was accepted by
+	// Settings.CheckPassphrase, otherwise it is checked after prompt.
+	Checked bool
+}
+
+// Prompter interacts with user on behalf of GETPIN, CONFIRM and MESSAGE.
+// Context is done when prompt should be dismissed (see Callbacks). Errors
+// are sent to client as ERR, *common.Error as is, unknown ones with
+// ErrPinEntry code (ErrNoPinEntry if prompter is not available).
+type Prompter interface {
+	// GetPIN asks for passphrase, handling repeat prompt and passphrase
+	// generation if requested. Passphrase rejected by
+	// Settings.CheckPassphrase is asked again with returned error.
+	GetPIN(ctx context.Context, s *Settings) (PIN, error)
+	// Confirm asks user to confirm description, only OK button should be
+	// shown when s.CmdArgs is "--one-button".
+	Confirm(ctx context.Context, s *Settings) (ConfirmResult, error)
+	// Message shows description and waits for user to acknowledge it.
+	Message(ctx context.Context, s *Settings) error
+}
+
+// Chain is a Prompter trying prompters in order, next one is used when
+// previous is not available (returns error wrapping ErrUnavailable). For
+// example GUI prompter followed by TTY one.
+type Chain []Prompter
+
+func (c Chain) try(fn func(Prompter) error) error {
+	err := error(ErrUnavailable)
+	for _, p := range c {
+		if err = fn(p); !errors.Is(err, ErrUnavailable) {
+			return err
+		}
+		log.Printf("Prompter %T is not available: %s", p, err.Error())
+	}
+	return err
+}
+
+// GetPIN implements Prompter.
+func (c Chain) GetPIN(ctx context.Context, s *Settings) (pin PIN, err error) {
+	err = c.try(func(p Prompter) (err error) {
+		pin, err = p.GetPIN(ctx, s)
+		return err
+	})
+	return pin, err
+}
+
+// Confirm implements Prompter.
+func (c Chain) Confirm(ctx context.Context, s *Settings) (res ConfirmResult, err error) {
+	err = c.try(func(p Prompter) (err error) {
+		res, err = p.Confirm(ctx, s)
+		return err
+	})
+	return res, err
+}
+
+// Message implements Prompter.
+func (c Chain) Message(ctx context.Context, s *Settings) error {
+	return c.try(func(p Prompter) error {
+		return p.Message(ctx, s)
+	})
+}
+
+// PrompterCallbacks returns Callbacks showing prompts with p.
+func PrompterCallbacks(p Prompter) Callbacks {
+	return Callbacks{
+		GetPIN: func(ctx context.Context, pipe *common.Pipe, s *Settings) (string, *common.Error) {
+			defer func(msg string) { s.Error = msg }(s.Error)
+
+			pin, err := p.GetPIN(ctx, s)
+			for err == nil && !pin.FromCache && !pin.Checked {
+				hint := s.CheckPassphrase(pin.Value)
+				if len(hint) == 0 {
+					break
+				}
+				// Prompter did not check passphrase, ask again showing
+				// why it was rejected.
+				s.Error = hint
+				pin, err = p.GetPIN(ctx, s)
+			}
+			if err != nil {
+				return "", promptError(err)
+			}
+			if pin.FromCache {
+				if err := server.SendStatus(pipe, "PASSWORD_FROM_CACHE", ""); err != nil {
+					return "", err
+				}
+			}
+			if pin.Repeated && len(s.RepeatPrompt) != 0 {
+				if err := server.SendStatus(pipe, "PIN_REPEATED", ""); err != nil {
+					return "", err
+				}
+			}
+			return pin.Value, nil
+		},
+		Confirm: func(ctx context.Context, _ *common.Pipe, s *Settings) (bool, *common.Error) {
+			res, err := p.Confirm(ctx, s)
+			if err != nil {
+				return false, promptError(err)
+			}
+			switch res {
+			case ConfirmOK:
+				return true, nil
+			case ConfirmNotOK:
+				return false, &common.Error{
+					Src: common.ErrSrcPinentry, Code: common.ErrNotConfirmed,
+					SrcName: "pinentry", Message: "not confirmed",
+				}
+			}
+			return false, nil
+		},
+		Msg: func(ctx context.Context, _ *common.Pipe, s *Settings) *common.Error {
+			if err := p.Message(ctx, s); err != nil {
+				return promptError(err)
+			}
+			return nil
+		},
+	}
+}
+
+// promptError converts error returned by Prompter to ERR response.
+func promptError(err error) *common.Error {
+	var perr *common.Error
+	if errors.As(err, &perr) {
+		return perr
+	}
+	var verr common.Error
+	if errors.As(err, &verr) {
+		return &verr
+	}
+	if errors.Is(err, context.Canceled) {
+		return canceledError()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return timeoutError()
+	}
+	log.Println("... prompt failed:", err)
+	code := common.ErrPinEntry
+	if errors.Is(err, ErrUnavailable) {
+		code = common.ErrNoPinEntry
+	}
+	return &common.Error{
+		Src: common.ErrSrcPinentry, Code: code,
+		SrcName: "pinentry", Message: err.Error(), Err: err,
+	}
+}
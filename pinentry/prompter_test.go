@@ -0,0 +1,231 @@
+package pinentry_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/assuantest"
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+	"github.com/rupor-github/win-gpg-agent/pinentry"
+)
+
+// Test binary serves as external pinentry program when answers are passed in
+// environment.
+const answersEnv = "PINENTRY_TEST_ANSWERS"
+
+func TestMain(m *testing.M) {
+	if answers := os.Getenv(answersEnv); len(answers) != 0 {
+		sc := pinentry.NewScript(strings.Split(answers, ";")...)
+		if err := pinentry.Serve(pinentry.PrompterCallbacks(sc), ""); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+const prompterScript = `
+S: OK PinGO (w32)
+C: SETDESC Enter new passphrase
+S: OK
+C: SETREPEAT Repeat:
+S: OK
+C: GETPIN
+S: S PIN_REPEATED
+S: D secret
+S: OK
+C: RESET
+S: OK
+C: SETDESC Do you trust this key?
+S: OK
+C: SETNOTOK _No
+S: OK
+C: CONFIRM
+S: ERR 83886194 not confirmed <pinentry>
+C: CONFIRM
+S: ERR 83886179 operation canceled <pinentry>
+C: MESSAGE
+S: OK
+C: GETPIN
+S: ERR 83886179 operation canceled <pinentry>
+C: GETPIN
+S: ERR 83886165 prompter is not available: script has no answer for GETPIN <pinentry>
+`
+
+func TestPrompterCallbacks(t *testing.T) {
+	s, err := assuantest.ParseScript("prompter", strings.NewReader(prompterScript))
+	if err != nil {
+		t.Fatal("Unable to parse script:", err)
+	}
+	sc := pinentry.NewScript("PIN secret", "NOTOK", "CANCEL", "OK", "CANCEL")
+	chain := pinentry.Chain{pinentry.NewScript(), sc}
+	if err := assuantest.RunServer(s, pinentry.Proto(pinentry.PrompterCallbacks(chain), "")); err != nil {
+		t.Error(err)
+	}
+
+	shown := sc.Shown()
+	if len(shown) != 5 {
+		t.Fatalf("Expected 5 prompts, got %d", len(shown))
+	}
+	if shown[0].Desc != "Enter new passphrase" || shown[0].RepeatPrompt != "Repeat:" {
+		t.Errorf("Unexpected GETPIN settings: %s", shown[0].String())
+	}
+	if shown[1].Desc != "Do you trust this key?" || shown[1].NotOkBtn != "_No" {
+		t.Errorf("Unexpected CONFIRM settings: %s", shown[1].String())
+	}
+}
+
+func TestScript_Timeout(t *testing.T) {
+	sc := pinentry.NewScript("TIMEOUT")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := sc.GetPIN(ctx, &pinentry.Settings{}); !errors.Is(err, context.Canceled) {
+		t.Error("Expected context error, got:", err)
+	}
+	if _, err := sc.Confirm(ctx, &pinentry.Settings{}); !errors.Is(err, pinentry.ErrUnavailable) {
+		t.Error("Expected unavailable error, got:", err)
+	}
+}
+
+func TestExternal(t *testing.T) {
+	ext := &pinentry.External{Path: os.Args[0], Args: []string{"-test.run=^$"}}
+	ctx := context.Background()
+
+	t.Setenv(answersEnv, "PIN secret")
+	pin, err := ext.GetPIN(ctx, &pinentry.Settings{Desc: "Enter new passphrase", RepeatPrompt: "Repeat:"})
+	if err != nil || pin.Value != "secret" || !pin.Repeated {
+		t.Errorf("Unexpected GETPIN result: %+v, %v", pin, err)
+	}
+
+	t.Setenv(answersEnv, "CANCEL")
+	if _, err := ext.GetPIN(ctx, &pinentry.Settings{}); !errors.Is(err, common.ErrCanceled) {
+		t.Error("Expected canceled error, got:", err)
+	}
+
+	for _, answer := range []pinentry.ConfirmResult{pinentry.ConfirmOK, pinentry.ConfirmNotOK, pinentry.ConfirmCancel} {
+		t.Setenv(answersEnv, answer.String())
+		res, err := ext.Confirm(ctx, &pinentry.Settings{NotOkBtn: "_No"})
+		if err != nil || res != answer {
+			t.Errorf("Expected %s, got %s, %v", answer, res, err)
+		}
+	}
+
+	t.Setenv(answersEnv, "OK")
+	if err := ext.Message(ctx, &pinentry.Settings{}); err != nil {
+		t.Error("Unexpected MESSAGE error:", err)
+	}
+
+	// Missing program is skipped by chain.
+	missing := &pinentry.External{Path: "pinentry-does-not-exist"}
+	if _, err := missing.GetPIN(ctx, &pinentry.Settings{}); !errors.Is(err, pinentry.ErrUnavailable) {
+		t.Error("Expected unavailable error, got:", err)
+	}
+	pin, err = pinentry.Chain{missing, pinentry.NewScript("PIN fallback")}.GetPIN(ctx, &pinentry.Settings{})
+	if err != nil || pin.Value != "fallback" {
+		t.Errorf("Unexpected fallback result: %+v, %v", pin, err)
+	}
+}
+
+const checkPINScript = `
+S: OK PinGO (w32)
+C: OPTION constraints-enforce
+S: OK
+C: OPTION constraints-error-title=Passphrase+not+allowed
+S: OK
+C: SETREPEAT Repeat:
+S: OK
+C: SETERROR Original
+S: OK
+C: GETPIN
+S: INQUIRE CHECKPIN weak
+C: D Too short
+C: END
+S: INQUIRE CHECKPIN strong+pass
+C: END
+S: S PIN_REPEATED
+S: D strong pass
+S: OK
+C: GETPIN
+S: INQUIRE CHECKPIN a%2Bb+c%25d
+C: END
+S: S PIN_REPEATED
+S: D a+b c%25d
+S: OK
+`
+
+func TestPrompterCallbacks_CheckPIN(t *testing.T) {
+	s, err := assuantest.ParseScript("checkpin", strings.NewReader(checkPINScript))
+	if err != nil {
+		t.Fatal("Unable to parse script:", err)
+	}
+	sc := pinentry.NewScript("PIN weak", "PIN strong pass", "PIN a+b c%d")
+	if err := assuantest.RunServer(s, pinentry.Proto(pinentry.PrompterCallbacks(sc), "")); err != nil {
+		t.Fatal(err)
+	}
+
+	var errs []string
+	for _, shown := range sc.Shown() {
+		errs = append(errs, shown.Error)
+	}
+	if strings.Join(errs, "|") != "Original|Passphrase not allowed:\nToo short|Original" {
+		t.Errorf("Unexpected errors shown: %q", errs)
+	}
+}
+
+func TestExternal_CheckPIN(t *testing.T) {
+	ext := &pinentry.External{Path: os.Args[0], Args: []string{"-test.run=^$"}}
+
+	var checked []string
+	s := &pinentry.Settings{
+		RepeatPrompt: "Repeat:",
+		Opts:         pinentry.Options{ConstraintsEnforce: true},
+		CheckPIN: func(pin string) string {
+			checked = append(checked, pin)
+			if len(pin) < 6 {
+				return "Too short"
+			}
+			return ""
+		},
+	}
+	// Passphrase is plus-escaped on its way to us and back.
+	t.Setenv(answersEnv, "PIN weak;PIN a+b c%d")
+	pin, err := ext.GetPIN(context.Background(), s)
+	if err != nil || pin.Value != "a+b c%d" || !pin.Checked {
+		t.Errorf("Unexpected GETPIN result: %+v, %v", pin, err)
+	}
+	if strings.Join(checked, ",") != "weak,a+b c%d" {
+		t.Errorf("Unexpected passphrases checked: %q", checked)
+	}
+}
+
+// timedOutPrompter reports prompt dismissed on timeout.
+type timedOutPrompter struct{}
+
+func (timedOutPrompter) GetPIN(context.Context, *pinentry.Settings) (pinentry.PIN, error) {
+	return pinentry.PIN{}, context.DeadlineExceeded
+}
+
+func (timedOutPrompter) Confirm(context.Context, *pinentry.Settings) (pinentry.ConfirmResult, error) {
+	return pinentry.ConfirmCancel, context.DeadlineExceeded
+}
+
+func (timedOutPrompter) Message(context.Context, *pinentry.Settings) error {
+	return context.DeadlineExceeded
+}
+
+func TestPrompterCallbacks_Timeout(t *testing.T) {
+	cb := pinentry.PrompterCallbacks(timedOutPrompter{})
+	s := &pinentry.Settings{}
+	if _, err := cb.GetPIN(context.Background(), nil, s); err == nil || err.Code != common.ErrTimeout {
+		t.Error("Expected timeout error from GETPIN, got:", err)
+	}
+	if _, err := cb.Confirm(context.Background(), nil, s); err == nil || err.Code != common.ErrTimeout {
+		t.Error("Expected timeout error from CONFIRM, got:", err)
+	}
+	if err := cb.Msg(context.Background(), nil, s); err == nil || err.Code != common.ErrTimeout {
+		t.Error("Expected timeout error from MESSAGE, got:", err)
+	}
+}
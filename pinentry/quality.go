@@ -0,0 +1,88 @@
+package pinentry
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+	"github.com/rupor-github/win-gpg-agent/assuan/server"
+)
+
+// defaultQualityBar is used when SETQUALITYBAR has no label.
+const defaultQualityBar = "Quality:"
+
+// inquirer asks peer (gpg-agent) about passphrases while GETPIN is running.
+// Its methods could be called from any goroutine, inquiries are serialized.
+type inquirer struct {
+	ctx  context.Context
+	pipe *common.Pipe
+	mu   sync.Mutex
+}
+
+// inquire sends INQUIRE keyword with passphrase argument and returns data
+// sent back.
+func (in *inquirer) inquire(keyword, pin string) ([]byte, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	// gpg-agent expects passphrase to be plus-escaped.
+	return server.InquireEncoded(in.ctx, in.pipe, keyword, common.PlusEscape(pin))
+}
+
+// quality implements Settings.PasswordQuality with INQUIRE QUALITY.
+func (in *inquirer) quality(pin string) int {
+	res, err := in.inquire("QUALITY", pin)
+	if err != nil {
+		in.pipe.Logf(common.LogError, "... quality inquiry failed: %s", err)
+		return 0
+	}
+	q, err := strconv.Atoi(strings.TrimSpace(string(res)))
+	if err != nil {
+		in.pipe.Logf(common.LogError, "... bad quality value: %s", err)
+		return 0
+	}
+	if q > 100 {
+		q = 100
+	} else if q < -100 {
+		q = -100
+	}
+	return q
+}
+
+// Quality returns passphrase quality in percent as estimated by gpg-agent,
+// negative value means passphrase does not satisfy constraints. ok is false
+// if quality bar was not requested.
+func (s *Settings) Quality(pin string) (q int, ok bool) {
+	if len(s.QualityBar) == 0 || s.PasswordQuality == nil {
+		return 0, false
+	}
+	return s.PasswordQuality(pin), true
+}
+
+// QualityText describes quality for prompts which cannot show quality bar,
+// for example "Quality: 42% (higher is better)". Tooltip set with
+// SETQUALITYBAR_TT is added in parentheses.
+func (s *Settings) QualityText(q int) string {
+	label := s.QualityBar
+	if len(label) == 0 {
+		label = defaultQualityBar
+	}
+	text := fmt.Sprintf("%s %d%%", label, abs(q))
+	if q < 0 {
+		text += " - too weak"
+	}
+	if len(s.QualityBarToolTip) != 0 {
+		text += " (" + s.QualityBarToolTip + ")"
+	}
+	return text
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
@@ -0,0 +1,117 @@
+package pinentry
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Script is a Prompter giving prepared answers instead of asking user. It is
+// used in tests and could be selected in configuration to run pinentry
+// unattended. Every prompt takes next answer:
+//
+//	PIN <passphrase>    passphrase for GETPIN, counts as repeated
+//	OK, NOTOK, CANCEL   button pressed, CANCEL cancels GETPIN too
+//	TIMEOUT             no answer, prompt waits until dismissed
+//
+// When answers are exhausted Script is not available.
+type Script struct {
+	mu      sync.Mutex
+	answers []string
+	shown   []Settings
+}
+
+// NewScript creates Script with specified answers.
+func NewScript(answers ...string) *Script {
+	return &Script{answers: answers}
+}
+
+// ReadScript reads answers from r, one per line. Empty lines and lines
+// starting with # are skipped.
+func ReadScript(r io.Reader) (*Script, error) {
+	sc := &Script{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sc.answers = append(sc.answers, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// Shown returns copies of settings of prompts answered so far.
+func (sc *Script) Shown() []Settings {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return append([]Settings(nil), sc.shown...)
+}
+
+// next takes answer for the prompt.
+func (sc *Script) next(ctx context.Context, op string, s *Settings) (string, error) {
+	sc.mu.Lock()
+	if len(sc.answers) == 0 {
+		sc.mu.Unlock()
+		return "", fmt.Errorf("%w: script has no answer for %s", ErrUnavailable, op)
+	}
+	answer := sc.answers[0]
+	sc.answers = sc.answers[1:]
+	sc.shown = append(sc.shown, *s)
+	sc.mu.Unlock()
+
+	if answer == "TIMEOUT" {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	return answer, nil
+}
+
+// GetPIN implements Prompter.
+func (sc *Script) GetPIN(ctx context.Context, s *Settings) (PIN, error) {
+	answer, err := sc.next(ctx, "GETPIN", s)
+	if err != nil {
+		return PIN{}, err
+	}
+	switch {
+	case answer == "CANCEL":
+		return PIN{}, context.Canceled
+	case answer == "PIN":
+		return PIN{Repeated: true}, nil
+	case strings.HasPrefix(answer, "PIN "):
+		return PIN{Value: answer[4:], Repeated: true}, nil
+	}
+	return PIN{}, fmt.Errorf("script: unexpected answer %q for GETPIN", answer)
+}
+
+// Confirm implements Prompter.
+func (sc *Script) Confirm(ctx context.Context, s *Settings) (ConfirmResult, error) {
+	answer, err := sc.next(ctx, "CONFIRM", s)
+	if err != nil {
+		return ConfirmCancel, err
+	}
+	for _, res := range []ConfirmResult{ConfirmOK, ConfirmNotOK, ConfirmCancel} {
+		if answer == res.String() {
+			return res, nil
+		}
+	}
+	return ConfirmCancel, fmt.Errorf("script: unexpected answer %q for CONFIRM", answer)
+}
+
+// Message implements Prompter.
+func (sc *Script) Message(ctx context.Context, s *Settings) error {
+	answer, err := sc.next(ctx, "MESSAGE", s)
+	if err != nil {
+		return err
+	}
+	if answer != "OK" {
+		return fmt.Errorf("script: unexpected answer %q for MESSAGE", answer)
+	}
+	return nil
+}
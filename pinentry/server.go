@@ -1,18 +1,15 @@
 package pinentry
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"log"
 	"strconv"
 	"strings"
 	"time"
 
-	"golang.org/x/sys/windows"
-
 	"github.com/rupor-github/win-gpg-agent/assuan/common"
 	"github.com/rupor-github/win-gpg-agent/assuan/server"
-	"github.com/rupor-github/win-gpg-agent/wincred"
 )
 
 var version = "undefined"
@@ -26,65 +23,113 @@ func CredentialName(key string) string {
 }
 
 // Callbacks list functions to be implemented by caller.
+//
+// Callbacks run under deadline derived from Settings.Timeout (if set), when
+// ctx is done prompt should be dismissed. Whatever callback returns after
+// deadline expired client receives ErrTimeout error.
 type Callbacks struct {
-	GetPIN  func(*common.Pipe, *Settings) (string, *common.Error)
-	Confirm func(*common.Pipe, *Settings) (bool, *common.Error)
-	Msg     func(*common.Pipe, *Settings) *common.Error
+	GetPIN  func(context.Context, *common.Pipe, *Settings) (string, *common.Error)
+	Confirm func(context.Context, *common.Pipe, *Settings) (bool, *common.Error)
+	Msg     func(context.Context, *common.Pipe, *Settings) *common.Error
+	// GenPIN generates passphrase when generate action is requested with
+	// SETGENPIN, DefaultGenerator is used if not set.
+	GenPIN func() (string, error)
+	// Cache is external passphrase cache CLEARPASSPHRASE removes passphrases
+	// from, nothing is removed if not set.
+	Cache PassphraseCache
+}
+
+// withTimeout returns context for callback, limited by prompt timeout.
+func withTimeout(ctx context.Context, s *Settings) (context.Context, context.CancelFunc) {
+	if s.Timeout > 0 {
+		return context.WithTimeout(ctx, s.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// timedOut reports whether prompt timeout expired.
+func timedOut(ctx context.Context) bool {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Println("... prompt timed out")
+		return true
+	}
+	return false
 }
 
-func setDesc(_ *common.Pipe, state interface{}, params string) error {
+func timeoutError() *common.Error {
+	return &common.Error{
+		Src: common.ErrSrcPinentry, Code: common.ErrTimeout,
+		SrcName: "pinentry", Message: "timeout",
+	}
+}
+
+func canceledError() *common.Error {
+	return &common.Error{
+		Src: common.ErrSrcPinentry, Code: common.ErrCanceled,
+		SrcName: "pinentry", Message: "operation canceled",
+	}
+}
+
+func setDesc(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
 	state.(*Settings).Desc = params
 	return nil
 }
-func setPrompt(_ *common.Pipe, state interface{}, params string) error {
+func setPrompt(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
 	state.(*Settings).Prompt = params
 	return nil
 }
-func setRepeat(_ *common.Pipe, state interface{}, params string) error {
+func setRepeat(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
 	state.(*Settings).RepeatPrompt = params
 	return nil
 }
-func setRepeatError(_ *common.Pipe, state interface{}, params string) error {
+func setRepeatError(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
 	state.(*Settings).RepeatError = params
 	return nil
 }
-func setError(_ *common.Pipe, state interface{}, params string) error {
+func setRepeatOK(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
+	state.(*Settings).RepeatOK = params
+	return nil
+}
+func setError(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
 	state.(*Settings).Error = params
 	return nil
 }
-func setOk(_ *common.Pipe, state interface{}, params string) error {
+func setOk(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
 	state.(*Settings).OkBtn = params
 	return nil
 }
-func setNotOk(_ *common.Pipe, state interface{}, params string) error {
+func setNotOk(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
 	state.(*Settings).NotOkBtn = params
 	return nil
 }
-func setCancel(_ *common.Pipe, state interface{}, params string) error {
+func setCancel(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
 	state.(*Settings).CancelBtn = params
 	return nil
 }
-func setQualityBar(_ *common.Pipe, state interface{}, params string) error {
+func setQualityBar(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
+	if len(params) == 0 {
+		params = defaultQualityBar
+	}
 	state.(*Settings).QualityBar = params
 	return nil
 }
-func setQualityBarToolTip(_ *common.Pipe, state interface{}, params string) error {
+func setQualityBarToolTip(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
 	state.(*Settings).QualityBarToolTip = params
 	return nil
 }
-func setGenPINLabel(_ *common.Pipe, state interface{}, params string) error {
+func setGenPINLabel(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
 	state.(*Settings).GenPINLabel = params
 	return nil
 }
-func setGenPINToolTip(_ *common.Pipe, state interface{}, params string) error {
+func setGenPINToolTip(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
 	state.(*Settings).GenPINToolTip = params
 	return nil
 }
-func setTitle(_ *common.Pipe, state interface{}, params string) error {
+func setTitle(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
 	state.(*Settings).Title = params
 	return nil
 }
-func setTimeout(_ *common.Pipe, state interface{}, params string) error {
+func setTimeout(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
 	i, err := strconv.Atoi(params)
 	if err != nil {
 		return &common.Error{
@@ -96,55 +141,7 @@ func setTimeout(_ *common.Pipe, state interface{}, params string) error {
 	return nil
 }
 
-func clearPassphrase(_ *common.Pipe, state interface{}, params string) error {
-	key := strings.Trim(params, " ")
-	cred, err := wincred.GetGenericCredential(CredentialName(key))
-	if err != nil && !errors.Is(err, windows.ERROR_NOT_FOUND) {
-		log.Printf("GetGenericCredential cannot access vault: %s", err.Error())
-		return &common.Error{
-			Src: common.ErrSrcPinentry, Code: common.ErrAssGeneral,
-			SrcName: "pinentry", Message: "CLEARPASSPHRASE cannot access vault",
-		}
-	}
-	if cred != nil {
-		if err := cred.Delete(); err != nil {
-			return &common.Error{
-				Src: common.ErrSrcPinentry, Code: common.ErrAssInvValue,
-				SrcName: "pinentry", Message: "CLEARPASSPHRASE cannot delete credential",
-			}
-		}
-	}
-	return nil
-}
-
-func getInfo(pipe *common.Pipe, state interface{}, params string) error {
-	var res string
-	switch strings.Trim(params, " ") {
-	case "flavor":
-		res = "PinGO (w32)"
-	case "version":
-		res = version
-	case "pid":
-		// Since gnupg_allow_set_foregound_window() dioes not know what to do with proper process id - inhibit invalid argument error
-		res = "-1"
-	case "ttyinfo":
-		res = "- - -"
-	default:
-		return &common.Error{
-			Src: common.ErrSrcPinentry, Code: common.ErrAssParameter,
-			SrcName: "pinentry", Message: fmt.Sprintf("GETINFO unknown parameter value: %s", params),
-		}
-	}
-	if len(res) != 0 {
-		if err := pipe.WriteData([]byte(res)); err != nil {
-			log.Println("... IO error, dropping session:", err)
-			return err
-		}
-	}
-	return nil
-}
-
-func setKeyInfo(_ *common.Pipe, state interface{}, params string) error {
+func setKeyInfo(_ context.Context, _ *common.Pipe, state interface{}, params string) error {
 	if len(params) == 0 || params == "--clear" {
 		state.(*Settings).KeyInfo = ""
 	} else {
@@ -153,7 +150,7 @@ func setKeyInfo(_ *common.Pipe, state interface{}, params string) error {
 	return nil
 }
 
-func resetState(_ *common.Pipe, state interface{}, _ string) error {
+func resetState(_ context.Context, _ *common.Pipe, state interface{}, _ string) error {
 	*(state.(*Settings)) = DefaultSettings
 	return nil
 }
@@ -161,89 +158,115 @@ func resetState(_ *common.Pipe, state interface{}, _ string) error {
 func setOpt(state interface{}, key string, val string) error {
 	opts := state.(*Settings)
 
-	if key == "no-grab" {
+	switch key {
+	case "no-grab":
 		opts.Opts.Grab = false
-		return nil
-	}
-	if key == "grab" {
+	case "grab":
 		opts.Opts.Grab = true
-		return nil
-	}
-	if key == "ttytype" {
+	case "ttytype":
 		opts.Opts.TTYType = val
-		return nil
-	}
-	if key == "ttyname" {
+	case "ttyname":
 		opts.Opts.TTYName = val
-		return nil
-	}
-	if key == "ttyalert" {
+	case "ttyalert":
 		opts.Opts.TTYAlert = val
-		return nil
-	}
-	if key == "lc-ctype" {
+	case "lc-ctype":
 		opts.Opts.LCCtype = val
-		return nil
-	}
-	if key == "lc-messages" {
+	case "lc-messages":
 		opts.Opts.LCMessages = val
-		return nil
-	}
-	if key == "owner" {
+	case "owner":
 		opts.Opts.Owner = val
-		return nil
-	}
-	if key == "touch-file" {
+	case "touch-file":
 		opts.Opts.TouchFile = val
-		return nil
-	}
-	if key == "parent-wid" {
+	case "parent-wid":
 		opts.Opts.ParentWID = val
-		return nil
-	}
-	if key == "invisible-char" {
+	case "invisible-char":
 		opts.Opts.InvisibleChar = val
-		return nil
-	}
-	if key == "allow-external-password-cache" {
+	case "allow-external-password-cache":
 		opts.Opts.AllowExtPasswdCache = true
-		return nil
+	case "default-ok":
+		opts.Opts.DefaultOK = val
+	case "default-cancel":
+		opts.Opts.DefaultCancel = val
+	case "default-prompt":
+		opts.Opts.DefaultPrompt = val
+	case "default-pwmngr":
+		opts.Opts.DefaultPwmngr = val
+	case "default-cf-visi":
+		opts.Opts.DefaultCfVisi = val
+	case "default-tt-visi":
+		opts.Opts.DefaultTTVisi = val
+	case "default-tt-hide":
+		opts.Opts.DefaultTTHide = val
+	case "constraints-enforce":
+		opts.Opts.ConstraintsEnforce = true
+	case "formatted-passphrase":
+		opts.Opts.FormattedPassphrase = true
+	// gpg-agent plus-escapes hints, they are received raw (see Info.RawOptions).
+	case "constraints-hint-short":
+		return plusOpt(key, val, &opts.Opts.ConstraintsHintShort)
+	case "constraints-hint-long":
+		return plusOpt(key, val, &opts.Opts.ConstraintsHintLong)
+	case "constraints-error-title":
+		return plusOpt(key, val, &opts.Opts.ConstraintsErrorTitle)
+	case "formatted-passphrase-hint":
+		return plusOpt(key, val, &opts.Opts.FormattedPassphraseHint)
+	default:
+		// Labels we have no use for (default-capshint etc).
+		if strings.HasPrefix(key, "default-") {
+			return nil
+		}
+		return &common.Error{
+			Src: common.ErrSrcPinentry, Code: common.ErrUnknownOption,
+			SrcName: "pinentry", Message: "unknown option: " + key,
+		}
 	}
+	return nil
+}
 
-	if strings.HasPrefix(key, "default-") {
-		return nil
+// plusOpt decodes plus-escaped option value into dst.
+func plusOpt(key, val string, dst *string) error {
+	text, err := common.PlusUnescape(val)
+	if err != nil {
+		return &common.Error{
+			Src: common.ErrSrcPinentry, Code: common.ErrAssParameter,
+			SrcName: "pinentry", Message: "bad encoding of option " + key,
+		}
 	}
+	*dst = text
+	return nil
+}
 
-	return &common.Error{
-		Src: common.ErrSrcPinentry, Code: common.ErrUnknownOption,
-		SrcName: "pinentry", Message: "unknown option: " + key,
+// ttyInfo reports terminal name, type and display in use, "-" if not set.
+func ttyInfo(state interface{}) (string, error) {
+	opts := state.(*Settings).Opts
+	fields := []string{opts.TTYName, opts.TTYType, opts.Display}
+	for i := range fields {
+		if len(fields[i]) == 0 {
+			fields[i] = "-"
+		}
 	}
+	return strings.Join(fields, " "), nil
 }
 
 // Info is our pinentry protocol definition.
 var Info = server.ProtoInfo{
 	Greeting: "PinGO (w32)",
-	Handlers: map[string]server.CommandHandler{
-		"SETDESC":          setDesc,
-		"SETPROMPT":        setPrompt,
-		"SETREPEAT":        setRepeat,
-		"SETREPEATERROR":   setRepeatError,
-		"SETERROR":         setError,
-		"SETOK":            setOk,
-		"SETNOTOK":         setNotOk,
-		"SETCANCEL":        setCancel,
-		"SETQUALITYBAR":    setQualityBar,
-		"SETQUALITYBAR_TT": setQualityBarToolTip,
-		"SETGENPIN":        setGenPINLabel,
-		"SETGENPIN_TT":     setGenPINToolTip,
-		"SETTITLE":         setTitle,
-		"SETTIMEOUT":       setTimeout,
-		"CLEARPASSPHRASE":  clearPassphrase,
-		"GETINFO":          getInfo,
-		"SETKEYINFO":       setKeyInfo,
-		"RESET":            resetState,
+	Options: []string{
+		"no-grab", "grab", "ttytype", "ttyname", "ttyalert", "lc-ctype", "lc-messages",
+		"owner", "touch-file", "parent-wid", "invisible-char", "allow-external-password-cache",
+		"default-*", "constraints-enforce", "constraints-hint-short", "constraints-hint-long",
+		"constraints-error-title", "formatted-passphrase", "formatted-passphrase-hint",
+	},
+	RawOptions: []string{
+		"constraints-hint-short", "constraints-hint-long", "constraints-error-title", "formatted-passphrase-hint",
+	},
+	GetInfo: map[string]server.GetInfoFunc{
+		"flavor":  func(interface{}) (string, error) { return "PinGO (w32)", nil },
+		"version": func(interface{}) (string, error) { return version, nil },
+		// Since gnupg_allow_set_foregound_window() dioes not know what to do with proper process id - inhibit invalid argument error
+		"pid":     func(interface{}) (string, error) { return "-1", nil },
+		"ttyinfo": ttyInfo,
 	},
-	Help: map[string][]string{}, // TODO
 	GetDefaultState: func() interface{} {
 		var s = DefaultSettings
 		return &s
@@ -251,15 +274,39 @@ var Info = server.ProtoInfo{
 	SetOption: setOpt,
 }
 
-// Serve handles pinentry protocol.
-func Serve(callbacks Callbacks, ver string) error {
-	info := Info
+func init() {
+	Info.Register(
+		server.Command{Name: "SETDESC", Synopsis: "SETDESC <description>", Help: "Set description text of the dialog.", Handler: setDesc},
+		server.Command{Name: "SETPROMPT", Synopsis: "SETPROMPT <prompt>", Help: "Set prompt shown before PIN entry.", Handler: setPrompt},
+		server.Command{Name: "SETREPEAT", Synopsis: "SETREPEAT [<prompt>]", Help: "Ask for PIN twice using prompt for second entry.", Handler: setRepeat},
+		server.Command{Name: "SETREPEATERROR", Synopsis: "SETREPEATERROR <text>", Help: "Set error text shown when entries do not match.", Handler: setRepeatError},
+		server.Command{Name: "SETREPEATOK", Synopsis: "SETREPEATOK <text>", Help: "Set text shown when entries match.", Handler: setRepeatOK},
+		server.Command{Name: "SETERROR", Synopsis: "SETERROR <text>", Help: "Set error text shown with the dialog.", Handler: setError},
+		server.Command{Name: "SETOK", Synopsis: "SETOK <label>", Help: "Set label of OK button.", Handler: setOk},
+		server.Command{Name: "SETNOTOK", Synopsis: "SETNOTOK <label>", Help: "Set label of NOT OK button.", Handler: setNotOk},
+		server.Command{Name: "SETCANCEL", Synopsis: "SETCANCEL <label>", Help: "Set label of Cancel button.", Handler: setCancel},
+		server.Command{Name: "SETQUALITYBAR", Synopsis: "SETQUALITYBAR [<label>]", Help: "Enable passphrase quality indicator, quality is inquired from client with\nINQUIRE QUALITY <passphrase> while passphrase is entered.", Handler: setQualityBar},
+		server.Command{Name: "SETQUALITYBAR_TT", Synopsis: "SETQUALITYBAR_TT <text>", Help: "Set tooltip of quality indicator.", Handler: setQualityBarToolTip},
+		server.Command{Name: "SETGENPIN", Synopsis: "SETGENPIN <label>", Help: "Offer to generate passphrase, label is shown on generate action.", Handler: setGenPINLabel},
+		server.Command{Name: "SETGENPIN_TT", Synopsis: "SETGENPIN_TT <text>", Help: "Set tooltip of passphrase generation button.", Handler: setGenPINToolTip},
+		server.Command{Name: "SETTITLE", Synopsis: "SETTITLE <title>", Help: "Set window title.", Handler: setTitle},
+		server.Command{Name: "SETTIMEOUT", Synopsis: "SETTIMEOUT <seconds>", Help: "Close the dialog after specified number of seconds, ERR with timeout\nerror is returned in this case.", Handler: setTimeout},
+		server.Command{Name: "CLEARPASSPHRASE", Synopsis: "CLEARPASSPHRASE <cache_id>", Help: "Remove passphrase from external cache.", Handler: clearPassphrase(nil)},
+		server.Command{Name: "SETKEYINFO", Synopsis: "SETKEYINFO <keyinfo>|--clear", Help: "Set key identifier used for external cache.", Handler: setKeyInfo},
+		server.Command{Name: "RESET", Synopsis: "RESET", Help: "Reset all settings to their defaults.", Handler: resetState},
+	)
+}
 
-	if len(version) != 0 {
+// Proto returns pinentry protocol definition with GETPIN, CONFIRM and MESSAGE
+// implemented by callbacks. Info is not modified.
+func Proto(callbacks Callbacks, ver string) server.ProtoInfo {
+	info := Info.Clone()
+
+	if len(ver) != 0 {
 		version = ver
 	}
 
-	info.Handlers["GETPIN"] = func(pipe *common.Pipe, state interface{}, params string) error {
+	getPIN := func(ctx context.Context, pipe *common.Pipe, state interface{}, params string) error {
 		if callbacks.GetPIN == nil {
 			log.Println("GETPIN requested but not supported")
 			return &common.Error{
@@ -268,19 +315,38 @@ func Serve(callbacks Callbacks, ver string) error {
 			}
 		}
 
-		state.(*Settings).CmdArgs = params
-		log.Printf("GETPIN state:\n%s", state.(*Settings).String())
-		pass, err := callbacks.GetPIN(pipe, state.(*Settings))
+		s := state.(*Settings)
+		s.CmdArgs = params
+		ctx, cancel := withTimeout(ctx, s)
+		defer cancel()
+		in := &inquirer{ctx: ctx, pipe: pipe}
+		if len(s.QualityBar) != 0 {
+			s.PasswordQuality = in.quality
+			defer func() { s.PasswordQuality = nil }()
+		}
+		if s.Opts.ConstraintsEnforce && len(s.RepeatPrompt) != 0 {
+			s.CheckPIN = in.checkPIN
+			defer func() { s.CheckPIN = nil }()
+		}
+		if len(s.GenPINLabel) != 0 {
+			s.GenPIN = callbacks.GenPIN
+			if s.GenPIN == nil {
+				s.GenPIN = DefaultGenerator.Generate
+			}
+			defer func() { s.GenPIN = nil }()
+		}
+		pipe.Logf(common.LogDebug, "GETPIN state:\n%s", s.String())
+		pass, err := callbacks.GetPIN(ctx, pipe, s)
+		if timedOut(ctx) {
+			return timeoutError()
+		}
 		if err != nil {
 			return err
 		}
 
-		if err := pipe.WriteData([]byte(pass)); err != nil {
-			return nil
-		}
-		return nil
+		return pipe.WriteData([]byte(pass))
 	}
-	info.Handlers["CONFIRM"] = func(pipe *common.Pipe, state interface{}, params string) error {
+	confirm := func(ctx context.Context, pipe *common.Pipe, state interface{}, params string) error {
 		if callbacks.Confirm == nil {
 			log.Println("CONFIRM requested but not supported")
 			return &common.Error{
@@ -290,21 +356,23 @@ func Serve(callbacks Callbacks, ver string) error {
 		}
 
 		state.(*Settings).CmdArgs = params
-		log.Printf("CONFIRM state:\n%s", state.(*Settings).String())
-		v, err := callbacks.Confirm(pipe, state.(*Settings))
+		ctx, cancel := withTimeout(ctx, state.(*Settings))
+		defer cancel()
+		pipe.Logf(common.LogDebug, "CONFIRM state:\n%s", state.(*Settings).String())
+		v, err := callbacks.Confirm(ctx, pipe, state.(*Settings))
+		if timedOut(ctx) {
+			return timeoutError()
+		}
 		if err != nil {
 			return err
 		}
 
 		if !v {
-			return &common.Error{
-				Src: common.ErrSrcPinentry, Code: common.ErrCanceled,
-				SrcName: "pinentry", Message: "operation canceled",
-			}
+			return canceledError()
 		}
 		return nil
 	}
-	info.Handlers["MESSAGE"] = func(pipe *common.Pipe, state interface{}, params string) error {
+	message := func(ctx context.Context, pipe *common.Pipe, state interface{}, params string) error {
 		if callbacks.Msg == nil {
 			log.Println("MESSAGE requested but not supported")
 			return &common.Error{
@@ -313,10 +381,30 @@ func Serve(callbacks Callbacks, ver string) error {
 			}
 		}
 		state.(*Settings).CmdArgs = params
-		log.Printf("MESSAGE state:\n%s", state.(*Settings).String())
-		return callbacks.Msg(pipe, state.(*Settings))
+		ctx, cancel := withTimeout(ctx, state.(*Settings))
+		defer cancel()
+		pipe.Logf(common.LogDebug, "MESSAGE state:\n%s", state.(*Settings).String())
+		err := callbacks.Msg(ctx, pipe, state.(*Settings))
+		if timedOut(ctx) {
+			return timeoutError()
+		}
+		if err != nil {
+			return err
+		}
+		return nil
 	}
 
-	err := server.ServeStdin(info)
-	return err
+	info.Register(
+		server.Command{Name: "GETPIN", Synopsis: "GETPIN", Help: "Ask user for PIN and return it as data. When constraints are enforced new\npassphrase is checked by client with INQUIRE CHECKPIN <passphrase>.", Handler: getPIN},
+		server.Command{Name: "CONFIRM", Synopsis: "CONFIRM [--one-button]", Help: "Ask user for confirmation.", Options: []string{"one-button"}, Handler: confirm},
+		server.Command{Name: "MESSAGE", Synopsis: "MESSAGE", Help: "Show message to user.", Handler: message},
+		server.Command{Name: "CLEARPASSPHRASE", Synopsis: "CLEARPASSPHRASE <cache_id>", Help: "Remove passphrase from external cache.", Handler: clearPassphrase(callbacks.Cache)},
+	)
+
+	return info
+}
+
+// Serve handles pinentry protocol.
+func Serve(callbacks Callbacks, ver string) error {
+	return server.ServeStdin(Proto(callbacks, ver))
 }
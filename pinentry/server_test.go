@@ -0,0 +1,192 @@
+package pinentry_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/assuantest"
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+	"github.com/rupor-github/win-gpg-agent/assuan/server"
+	"github.com/rupor-github/win-gpg-agent/pinentry"
+)
+
+var testCallbacks = pinentry.Callbacks{
+	GetPIN: func(_ context.Context, pipe *common.Pipe, s *pinentry.Settings) (string, *common.Error) {
+		if q, ok := s.Quality("weak pass"); ok {
+			return s.QualityText(q), nil
+		}
+		if s.GenPIN != nil {
+			// User accepts generated passphrase.
+			pin, err := s.GenPIN()
+			if err != nil {
+				return "", &common.Error{Src: common.ErrSrcPinentry, Code: common.ErrGeneral, SrcName: "pinentry", Message: err.Error()}
+			}
+			if len(s.RepeatPrompt) != 0 {
+				if err := server.SendStatus(pipe, "PIN_REPEATED", ""); err != nil {
+					return "", err
+				}
+			}
+			return pin, nil
+		}
+		if s.Desc != "Please enter the passphrase to unlock the OpenPGP secret key:\n\"Alice <alice@example.org>\"" ||
+			s.Prompt != "Passphrase:" || s.KeyInfo != "n/0123456789ABCDEF" || s.Opts.TTYName != "/dev/pts/1" ||
+			!s.Opts.AllowExtPasswdCache {
+			return "", &common.Error{Src: common.ErrSrcPinentry, Code: common.ErrGeneral, SrcName: "pinentry", Message: "unexpected settings"}
+		}
+		return "secret%pin", nil
+	},
+	Confirm: func(_ context.Context, _ *common.Pipe, s *pinentry.Settings) (bool, *common.Error) {
+		return s.Desc == "Do you trust this key?", nil
+	},
+	Msg: func(_ context.Context, _ *common.Pipe, s *pinentry.Settings) *common.Error {
+		if s.Desc != "Bad passphrase" {
+			return &common.Error{Src: common.ErrSrcPinentry, Code: common.ErrGeneral, SrcName: "pinentry", Message: "unexpected settings"}
+		}
+		return nil
+	},
+}
+
+func TestServer_Scripts(t *testing.T) {
+	scripts, err := filepath.Glob(filepath.Join("testdata", "*.script"))
+	if err != nil || len(scripts) == 0 {
+		t.Fatal("No test scripts found:", err)
+	}
+	for _, path := range scripts {
+		path := path
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			s, err := assuantest.LoadScript(path)
+			if err != nil {
+				t.Fatal("Unable to load script:", err)
+			}
+			if err := assuantest.RunServer(s, pinentry.Proto(testCallbacks, "1.2.3")); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// Fake prompt backend which waits for user forever.
+var hangingCallbacks = pinentry.Callbacks{
+	GetPIN: func(ctx context.Context, _ *common.Pipe, _ *pinentry.Settings) (string, *common.Error) {
+		<-ctx.Done()
+		return "", &common.Error{Src: common.ErrSrcPinentry, Code: common.ErrCanceled, SrcName: "pinentry", Message: "dismissed"}
+	},
+	Confirm: func(ctx context.Context, _ *common.Pipe, _ *pinentry.Settings) (bool, *common.Error) {
+		<-ctx.Done()
+		return false, nil
+	},
+	Msg: func(ctx context.Context, _ *common.Pipe, _ *pinentry.Settings) *common.Error {
+		<-ctx.Done()
+		return nil
+	},
+}
+
+func TestServer_Timeout(t *testing.T) {
+	for _, cmd := range []string{"GETPIN", "CONFIRM", "MESSAGE"} {
+		cmd := cmd
+		t.Run(cmd, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := assuantest.ParseScript(cmd, strings.NewReader(`S: OK PinGO (w32)
+C: SETTIMEOUT 1
+S: OK
+C: `+cmd+`
+S: ERR 83886142 timeout <pinentry>
+C: BYE
+S: OK
+`))
+			if err != nil {
+				t.Fatal("Unable to parse script:", err)
+			}
+			if err := assuantest.RunServer(s, pinentry.Proto(hangingCallbacks, "1.2.3")); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestServer_Options(t *testing.T) {
+	s, err := assuantest.ParseScript("options", strings.NewReader(`# gpg-agent 2.4 options
+S: OK PinGO (w32)
+C: OPTION default-ok=_OK
+S: OK
+C: OPTION default-cancel=_Cancel
+S: OK
+C: OPTION default-prompt=PIN:
+S: OK
+C: OPTION default-pwmngr=_Save in password manager
+S: OK
+C: OPTION default-cf-visi=Do you really want to make your passphrase visible on the screen?
+S: OK
+C: OPTION default-tt-visi=Make passphrase visible
+S: OK
+C: OPTION default-tt-hide=Hide passphrase
+S: OK
+C: OPTION default-capshint=Caps Lock is on
+S: OK
+C: OPTION constraints-enforce
+S: OK
+C: OPTION constraints-hint-short=At+least+8+characters
+S: OK
+C: OPTION constraints-hint-long=Use+letters%2C+digits+and+%2B
+S: OK
+C: OPTION constraints-error-title=Passphrase+not+allowed
+S: OK
+C: OPTION formatted-passphrase
+S: OK
+C: OPTION formatted-passphrase-hint=Blanks+are+not+part+of+the+passphrase.
+S: OK
+C: SETREPEAT Repeat:
+S: OK
+C: SETREPEATOK Passphrases match.
+S: OK
+C: GETPIN
+S: INQUIRE CHECKPIN secret
+C: END
+S: S PIN_REPEATED
+S: D secret
+S: OK
+`))
+	if err != nil {
+		t.Fatal("Unable to parse script:", err)
+	}
+	sc := pinentry.NewScript("PIN secret")
+	if err := assuantest.RunServer(s, pinentry.Proto(pinentry.PrompterCallbacks(sc), "")); err != nil {
+		t.Fatal(err)
+	}
+
+	shown := sc.Shown()
+	if len(shown) != 1 {
+		t.Fatalf("Expected single prompt, got %d", len(shown))
+	}
+	expected := pinentry.Options{
+		DefaultOK: "_OK", DefaultCancel: "_Cancel", DefaultPrompt: "PIN:",
+		DefaultPwmngr: "_Save in password manager",
+		DefaultCfVisi: "Do you really want to make your passphrase visible on the screen?",
+		DefaultTTVisi: "Make passphrase visible", DefaultTTHide: "Hide passphrase",
+		ConstraintsEnforce: true, ConstraintsHintShort: "At least 8 characters",
+		ConstraintsHintLong: "Use letters, digits and +", ConstraintsErrorTitle: "Passphrase not allowed",
+		FormattedPassphrase: true, FormattedPassphraseHint: "Blanks are not part of the passphrase.",
+	}
+	if got := shown[0].Opts; got != expected {
+		t.Errorf("Unexpected options:\n%s\nexpected:\n%s", got.String(), expected.String())
+	}
+	if shown[0].RepeatOK != "Passphrases match." {
+		t.Errorf("Unexpected repeat OK text: %q", shown[0].RepeatOK)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("broken pipe") }
+
+func TestServer_WriteError(t *testing.T) {
+	proto := pinentry.Proto(pinentry.PrompterCallbacks(pinentry.NewScript("PIN secret")), "")
+	pipe := common.NewPipe(strings.NewReader(""), failingWriter{})
+	if err := proto.Handlers["GETPIN"](context.Background(), &pipe, proto.GetDefaultState(), ""); err == nil {
+		t.Error("GETPIN reported success when PIN was not sent")
+	}
+}
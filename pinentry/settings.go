@@ -21,6 +21,56 @@ type Options struct {
 	TouchFile           string
 	ParentWID           string
 	InvisibleChar       string
+	// Labels used when not set with SET* commands (OPTION default-*), "_"
+	// marks mnemonic.
+	DefaultOK, DefaultCancel, DefaultPrompt string
+	// Label of "save in password manager" checkbox.
+	DefaultPwmngr string
+	// Confirmation text for making passphrase visible and tooltips for
+	// visibility toggle.
+	DefaultCfVisi, DefaultTTVisi, DefaultTTHide string
+	// New passphrase should be checked by client (INQUIRE CHECKPIN), hints
+	// describe constraints.
+	ConstraintsEnforce                                               bool
+	ConstraintsHintShort, ConstraintsHintLong, ConstraintsErrorTitle string
+	// Passphrase is shown in groups of five characters, hint explains it.
+	FormattedPassphrase     bool
+	FormattedPassphraseHint string
+}
+
+// assuanOptions returns non-empty options as OPTION name and value pairs.
+// Terminal, locale and label options are included, options bound to our
+// process (grab, owner, parent-wid...) are not.
+func (o *Options) assuanOptions() [][2]string {
+	var res [][2]string
+	add := func(name, value string) {
+		if len(value) != 0 {
+			res = append(res, [2]string{name, value})
+		}
+	}
+	flag := func(name string, set bool) {
+		if set {
+			res = append(res, [2]string{name, ""})
+		}
+	}
+	add("ttyname", o.TTYName)
+	add("ttytype", o.TTYType)
+	add("lc-ctype", o.LCCtype)
+	add("lc-messages", o.LCMessages)
+	add("default-ok", o.DefaultOK)
+	add("default-cancel", o.DefaultCancel)
+	add("default-prompt", o.DefaultPrompt)
+	add("default-pwmngr", o.DefaultPwmngr)
+	add("default-cf-visi", o.DefaultCfVisi)
+	add("default-tt-visi", o.DefaultTTVisi)
+	add("default-tt-hide", o.DefaultTTHide)
+	flag("constraints-enforce", o.ConstraintsEnforce)
+	add("constraints-hint-short", o.ConstraintsHintShort)
+	add("constraints-hint-long", o.ConstraintsHintLong)
+	add("constraints-error-title", o.ConstraintsErrorTitle)
+	flag("formatted-passphrase", o.FormattedPassphrase)
+	add("formatted-passphrase-hint", o.FormattedPassphraseHint)
+	return res
 }
 
 func (o *Options) String() string {
@@ -36,7 +86,18 @@ func (o *Options) String() string {
   Owner:               [%s],
   TouchFile:           [%s],
   ParentWID:           [%s],
-  InvisibleChar:       [%s]
+  InvisibleChar:       [%s],
+  DefaultOK:           [%s],
+  DefaultCancel:       [%s],
+  DefaultPrompt:       [%s],
+  DefaultPwmngr:       [%s],
+  DefaultCfVisi:       [%s],
+  DefaultTTVisi:       [%s],
+  DefaultTTHide:       [%s],
+  ConstraintsEnforce:  [%t],
+  ConstraintsHint:     [%s] [%s],
+  ConstraintsErrTitle: [%s],
+  FormattedPassphrase: [%t] [%s]
 }`,
 		o.Grab,
 		o.AllowExtPasswdCache,
@@ -50,6 +111,17 @@ func (o *Options) String() string {
 		o.TouchFile,
 		o.ParentWID,
 		o.InvisibleChar,
+		o.DefaultOK,
+		o.DefaultCancel,
+		o.DefaultPrompt,
+		o.DefaultPwmngr,
+		o.DefaultCfVisi,
+		o.DefaultTTVisi,
+		o.DefaultTTHide,
+		o.ConstraintsEnforce,
+		o.ConstraintsHintShort, o.ConstraintsHintLong,
+		o.ConstraintsErrorTitle,
+		o.FormattedPassphrase, o.FormattedPassphraseHint,
 	)
 }
 
@@ -72,21 +144,32 @@ type Settings struct {
 	CancelBtn string
 	// Window title.
 	Title string
-	// Prompt timeout. Any user interaction disables timeout.
+	// Prompt timeout, zero means no timeout. Prompt is dismissed when it
+	// expires.
 	Timeout time.Duration
 	// Text right before repeat textbox.
 	// Repeat textbox is hidden after GetPin.
 	RepeatPrompt string
 	// Error text to be shown if passwords do not match.
 	RepeatError string
+	// Text to be shown if passwords match.
+	RepeatOK string
 	// Text before password quality bar.
 	QualityBar, QualityBarToolTip string
 	// label and tooltip to be used for a generate action.
 	GenPINLabel, GenPINToolTip string
 	// To identify a key for caching - empty string mean that the key does not have a stable identifier.
 	KeyInfo string
-	// Password quality callback.
+	// Password quality callback, set by GETPIN when quality bar is requested.
+	// See Quality.
 	PasswordQuality func(string) int
+	// Passphrase constraints callback, set by GETPIN when new passphrase is
+	// requested and constraints are enforced. See CheckPassphrase.
+	CheckPIN func(string) string
+	// Passphrase generator, set by GETPIN when generate action is requested
+	// (GenPINLabel is not empty). Generated passphrase should be shown to the
+	// user, if accepted it counts as repeated (see PIN_REPEATED status).
+	GenPIN func() (string, error)
 
 	Opts Options
 
@@ -107,6 +190,7 @@ func (s *Settings) String() string {
   Timeout:      [%s],
   RepeatPrompt: [%s],
   RepeatError:  [%s],
+  RepeatOK:     [%s],
   QualityBar:   [%s],
   QualityBarTT: [%s],
   GenPINLabel:  [%s],
@@ -124,7 +208,7 @@ func (s *Settings) String() string {
 		s.CancelBtn,
 		s.Title,
 		s.Timeout,
-		s.RepeatPrompt, s.RepeatError,
+		s.RepeatPrompt, s.RepeatError, s.RepeatOK,
 		s.QualityBar, s.QualityBarToolTip,
 		s.GenPINLabel, s.GenPINToolTip,
 		s.KeyInfo,
@@ -0,0 +1,60 @@
+package pinentry
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openTerminal opens named terminal device or controlling terminal of the
+// process.
+func openTerminal(name string) (in, out *os.File, err error) {
+	if len(name) == 0 {
+		name = "/dev/tty"
+	}
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f, nil
+}
+
+func ioctlTermios(fd uintptr, req uintptr, t *syscall.Termios) error {
+	if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(t))); e != 0 {
+		return e
+	}
+	return nil
+}
+
+// noEcho turns off echo on terminal, returned function restores previous
+// mode. Descriptor is accessed with SyscallConn so file stays in non-blocking
+// mode and could be closed to interrupt reading.
+func noEcho(f *os.File) (func(), error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		old  syscall.Termios
+		terr error
+	)
+	err = rc.Control(func(fd uintptr) {
+		if terr = ioctlTermios(fd, syscall.TCGETS, &old); terr != nil {
+			return
+		}
+		t := old
+		t.Lflag &^= syscall.ECHO
+		terr = ioctlTermios(fd, syscall.TCSETS, &t)
+	})
+	if err == nil {
+		err = terr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		_ = rc.Control(func(fd uintptr) {
+			_ = ioctlTermios(fd, syscall.TCSETS, &old)
+		})
+	}, nil
+}
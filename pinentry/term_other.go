@@ -0,0 +1,27 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package pinentry
+
+import (
+	"errors"
+	"os"
+)
+
+// openTerminal opens named terminal device or controlling terminal of the
+// process.
+func openTerminal(name string) (in, out *os.File, err error) {
+	if len(name) == 0 {
+		name = "/dev/tty"
+	}
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f, nil
+}
+
+// noEcho is not supported, so passphrase is never read from terminal.
+func noEcho(*os.File) (func(), error) {
+	return nil, errors.New("turning off echo is not supported on this platform")
+}
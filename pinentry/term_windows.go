@@ -0,0 +1,47 @@
+package pinentry
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// openTerminal opens console of the process, pinentry started by gpg-agent
+// usually does not have one. Terminal names make no sense here and are
+// ignored.
+func openTerminal(_ string) (in, out *os.File, err error) {
+	in, err = os.OpenFile("CONIN$", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err = os.OpenFile("CONOUT$", os.O_WRONLY, 0)
+	if err != nil {
+		in.Close()
+		return nil, nil, err
+	}
+	return in, out, nil
+}
+
+// noEcho turns off echo on console input, returned function restores
+// previous mode. Console reads could not be interrupted other than by closing
+// handle, so mode is restored on fresh handle if needed - it belongs to the
+// console, not to the handle.
+func noEcho(f *os.File) (func(), error) {
+	h := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err != nil {
+		return nil, err
+	}
+	if err := windows.SetConsoleMode(h, mode&^windows.ENABLE_ECHO_INPUT); err != nil {
+		return nil, err
+	}
+	return func() {
+		if windows.SetConsoleMode(h, mode) == nil {
+			return
+		}
+		if in, err := os.OpenFile("CONIN$", os.O_RDWR, 0); err == nil {
+			_ = windows.SetConsoleMode(windows.Handle(in.Fd()), mode)
+			in.Close()
+		}
+	}, nil
+}
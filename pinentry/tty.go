@@ -0,0 +1,373 @@
+package pinentry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// TTY is a Prompter asking user on a terminal. Passphrase is read with echo
+// turned off. End of input (Ctrl-D) cancels prompt.
+//
+// Terminal is the one set with OPTION ttyname (gpg-agent passes terminal of
+// gpg there), controlling terminal of the process is used otherwise. Errors
+// are highlighted when OPTION ttytype is set to anything but "dumb".
+type TTY struct {
+	// In and Out are used as is when set (echo is not touched), otherwise
+	// terminal is opened for every prompt. In is read in background, line
+	// being read when prompt is cancelled goes to the next prompt.
+	In  io.Reader
+	Out io.Writer
+
+	mu   sync.Mutex
+	feed *lineFeed
+}
+
+// terminal is a single prompt session.
+type terminal struct {
+	in  io.Reader
+	out io.Writer
+	// tty is terminal opened by us, echo is turned off on it.
+	tty   *os.File
+	files []*os.File
+	// dumb terminal (or unknown one) does not understand escape sequences.
+	dumb bool
+	// feed delivers lines from TTY.In.
+	feed *lineFeed
+}
+
+// lineFeed reads lines from reader in background, so read pending when
+// prompt is cancelled is not lost. Nothing is read ahead but a single line.
+type lineFeed struct {
+	src   io.Reader
+	lines chan string
+	err   error // valid after lines is closed
+}
+
+func newLineFeed(r io.Reader) *lineFeed {
+	f := &lineFeed{src: r, lines: make(chan string)}
+	go func() {
+		defer close(f.lines)
+		for {
+			line, err := readLineFrom(r)
+			if err != nil {
+				f.err = err
+				return
+			}
+			f.lines <- line
+		}
+	}()
+	return f
+}
+
+// input returns feed reading from In, new one is started when In changes.
+func (t *TTY) input() *lineFeed {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.feed == nil || t.feed.src != t.In {
+		t.feed = newLineFeed(t.In)
+	}
+	return t.feed
+}
+
+func (t *TTY) open(s *Settings) (*terminal, error) {
+	dumb := len(s.Opts.TTYType) == 0 || s.Opts.TTYType == "dumb"
+	if t.In != nil {
+		term := &terminal{in: t.In, out: t.Out, dumb: dumb, feed: t.input()}
+		if term.out == nil {
+			term.out = io.Discard
+		}
+		return term, nil
+	}
+	in, out, err := openTerminal(s.Opts.TTYName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	files := []*os.File{in}
+	if out != in {
+		files = append(files, out)
+	}
+	return &terminal{in: in, out: out, tty: in, files: files, dumb: dumb}, nil
+}
+
+func (term *terminal) close() {
+	for _, f := range term.files {
+		f.Close()
+	}
+	term.files = nil
+}
+
+func (term *terminal) printf(format string, args ...interface{}) {
+	fmt.Fprintf(term.out, format, args...)
+}
+
+// header shows title and description of the prompt.
+func (term *terminal) header(s *Settings) {
+	term.printf("\n")
+	if len(s.Title) != 0 {
+		term.printf("%s\n\n", s.Title)
+	}
+	if len(s.Desc) != 0 {
+		term.printf("%s\n", s.Desc)
+	}
+}
+
+// showError shows error message, in bold when terminal supports it.
+func (term *terminal) showError(msg string) {
+	if term.dumb {
+		term.printf("%s\n", msg)
+		return
+	}
+	term.printf("\x1b[1m%s\x1b[0m\n", msg)
+}
+
+type lineResult struct {
+	line string
+	err  error
+}
+
+// interrupt unblocks reading from terminal opened by us. Terminal is closed
+// if it does not support deadlines.
+func (term *terminal) interrupt() {
+	if term.tty == nil || term.tty.SetReadDeadline(time.Now()) != nil {
+		term.close()
+	}
+}
+
+// readLineFrom reads single line byte by byte, so nothing is read ahead from
+// the input. End of input cancels prompt.
+func readLineFrom(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				return strings.TrimSuffix(string(line), "\r"), nil
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF {
+			return "", canceledError()
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// readLine reads single line. When ctx is done reading is interrupted.
+func (term *terminal) readLine(ctx context.Context) (string, error) {
+	if term.feed != nil {
+		select {
+		case line, ok := <-term.feed.lines:
+			if !ok {
+				return "", term.feed.err
+			}
+			return line, nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	res := make(chan lineResult, 1)
+	go func() {
+		line, err := readLineFrom(term.in)
+		res <- lineResult{line: line, err: err}
+	}()
+	select {
+	case r := <-res:
+		return r.line, r.err
+	case <-ctx.Done():
+		term.interrupt()
+		return "", ctx.Err()
+	}
+}
+
+// readSecret shows prompt and reads line with echo turned off. Echo is
+// turned off before prompt is shown, so nothing typed after prompt appears is
+// echoed.
+func (term *terminal) readSecret(ctx context.Context, prompt string) (string, error) {
+	if term.tty != nil {
+		restore, err := noEcho(term.tty)
+		if err != nil {
+			return "", fmt.Errorf("%w: unable to turn off echo: %v", ErrUnavailable, err)
+		}
+		defer restore()
+	}
+	term.printf("%s ", prompt)
+	line, err := term.readLine(ctx)
+	// Newline typed by user is not echoed.
+	term.printf("\n")
+	return line, err
+}
+
+type choice struct {
+	label  string
+	key    rune
+	result ConfirmResult
+}
+
+// choose shows choices and reads answer: either choice key or full label.
+// Keys are label mnemonics, first unused letter of label otherwise. When
+// there is a single choice empty answer selects it.
+func (term *terminal) choose(ctx context.Context, choices []choice) (ConfirmResult, error) {
+	used := map[rune]bool{}
+	for i := range choices {
+		choices[i].label, choices[i].key = Mnemonic(choices[i].label)
+		if used[choices[i].key] {
+			// Conflicting mnemonic, pick another key.
+			choices[i].key = 0
+		} else if choices[i].key != 0 {
+			used[choices[i].key] = true
+		}
+	}
+	labels := make([]string, len(choices))
+	for i := range choices {
+		if choices[i].key == 0 {
+			for _, r := range strings.ToLower(choices[i].label) {
+				if unicode.IsLetter(r) && !used[r] {
+					choices[i].key, used[r] = r, true
+					break
+				}
+			}
+		}
+		labels[i] = choices[i].label
+		if choices[i].key != 0 {
+			labels[i] += fmt.Sprintf(" (%c)", choices[i].key)
+		}
+	}
+	for {
+		term.printf("%s? ", strings.Join(labels, ", "))
+		answer, err := term.readLine(ctx)
+		if err != nil {
+			return ConfirmCancel, err
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if len(answer) == 0 && len(choices) == 1 {
+			return choices[0].result, nil
+		}
+		for _, c := range choices {
+			if answer == strings.ToLower(c.label) || (c.key != 0 && answer == string(c.key)) {
+				return c.result, nil
+			}
+		}
+	}
+}
+
+// offerGenerated shows generated passphrase and asks user whether to use it or
+// enter passphrase.
+func (term *terminal) offerGenerated(ctx context.Context, s *Settings) (string, bool, error) {
+	pin, err := s.GenPIN()
+	if err != nil {
+		log.Printf("Unable to generate passphrase: %s", err.Error())
+		return "", false, nil
+	}
+	label, _ := Mnemonic(s.GenPINLabel)
+	term.printf("%s: %s\n", label, s.FormatPassphrase(pin))
+	if len(s.GenPINToolTip) != 0 {
+		term.printf("%s\n", s.GenPINToolTip)
+	}
+	res, err := term.choose(ctx, []choice{
+		{label: "_Use generated passphrase", result: ConfirmOK},
+		{label: "_Enter passphrase", result: ConfirmNotOK},
+	})
+	return pin, res == ConfirmOK, err
+}
+
+// GetPIN implements Prompter.
+func (t *TTY) GetPIN(ctx context.Context, s *Settings) (PIN, error) {
+	term, err := t.open(s)
+	if err != nil {
+		return PIN{}, err
+	}
+	defer term.close()
+
+	term.header(s)
+	if s.Opts.FormattedPassphrase && len(s.Opts.FormattedPassphraseHint) != 0 {
+		term.printf("%s\n", s.Opts.FormattedPassphraseHint)
+	}
+	if s.GenPIN != nil {
+		pin, ok, err := term.offerGenerated(ctx, s)
+		if err != nil {
+			return PIN{}, err
+		}
+		if ok {
+			// Generated passphrase is shown to user, there is nothing to repeat.
+			return PIN{Value: pin, Repeated: true}, nil
+		}
+	}
+	prompt, _ := Mnemonic(s.PromptLabel())
+	errMsg := s.Error
+	for {
+		if len(errMsg) != 0 {
+			term.showError(errMsg)
+		}
+		pin, err := term.readSecret(ctx, prompt)
+		if err != nil {
+			return PIN{}, err
+		}
+		pin = s.UnformatPassphrase(pin)
+		if errMsg = s.CheckPassphrase(pin); len(errMsg) != 0 {
+			continue
+		}
+		if len(s.RepeatPrompt) == 0 {
+			return PIN{Value: pin, Checked: true}, nil
+		}
+		if q, ok := s.Quality(pin); ok {
+			term.printf("%s\n", s.QualityText(q))
+		}
+		pin2, err := term.readSecret(ctx, s.RepeatPrompt)
+		if err != nil {
+			return PIN{}, err
+		}
+		if pin == s.UnformatPassphrase(pin2) {
+			if len(s.RepeatOK) != 0 {
+				term.printf("%s\n", s.RepeatOK)
+			}
+			return PIN{Value: pin, Repeated: true, Checked: true}, nil
+		}
+		errMsg = s.RepeatError
+		if len(errMsg) == 0 {
+			errMsg = "Does not match - try again"
+		}
+	}
+}
+
+// Confirm implements Prompter.
+func (t *TTY) Confirm(ctx context.Context, s *Settings) (ConfirmResult, error) {
+	term, err := t.open(s)
+	if err != nil {
+		return ConfirmCancel, err
+	}
+	defer term.close()
+
+	term.header(s)
+	choices := []choice{{label: s.OkLabel(), result: ConfirmOK}}
+	if strings.TrimSpace(s.CmdArgs) != "--one-button" {
+		if len(s.NotOkBtn) != 0 {
+			choices = append(choices, choice{label: s.NotOkBtn, result: ConfirmNotOK})
+		}
+		choices = append(choices, choice{label: s.CancelLabel(), result: ConfirmCancel})
+	}
+	return term.choose(ctx, choices)
+}
+
+// Message implements Prompter.
+func (t *TTY) Message(ctx context.Context, s *Settings) error {
+	term, err := t.open(s)
+	if err != nil {
+		return err
+	}
+	defer term.close()
+
+	term.header(s)
+	_, err = term.choose(ctx, []choice{{label: s.OkLabel(), result: ConfirmOK}})
+	return err
+}
@@ -0,0 +1,206 @@
+package pinentry_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/rupor-github/win-gpg-agent/pinentry"
+)
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// console is master side of pseudo-terminal, it collects everything written
+// to terminal.
+type console struct {
+	master *os.File
+	slave  *os.File
+	name   string
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+	pos int
+}
+
+func openConsole(t *testing.T) *console {
+	t.Helper()
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skip("Pseudo-terminals are not available:", err)
+	}
+	var (
+		unlock int32
+		n      uint32
+	)
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		t.Fatal("Unable to unlock pty:", err)
+	}
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		t.Fatal("Unable to get pty number:", err)
+	}
+	c := &console{master: master, name: fmt.Sprintf("/dev/pts/%d", n)}
+	// Keep slave open, so terminal does not hang up between prompts.
+	if c.slave, err = os.OpenFile(c.name, os.O_RDWR, 0); err != nil {
+		t.Fatal("Unable to open pty:", err)
+	}
+	t.Cleanup(func() {
+		c.slave.Close()
+		c.master.Close()
+	})
+
+	go func() {
+		b := make([]byte, 256)
+		for {
+			n, err := c.master.Read(b)
+			c.mu.Lock()
+			c.buf.Write(b[:n])
+			c.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// expect waits for text to appear on terminal after previous expected text.
+func (c *console) expect(t *testing.T, text string) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		c.mu.Lock()
+		i := strings.Index(c.buf.String()[c.pos:], text)
+		if i >= 0 {
+			c.pos += i + len(text)
+		}
+		c.mu.Unlock()
+		if i >= 0 {
+			return
+		}
+	}
+	t.Fatalf("%q did not appear on terminal, got %q", text, c.output())
+}
+
+func (c *console) output() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.String()
+}
+
+func (c *console) input(t *testing.T, text string) {
+	t.Helper()
+	if _, err := c.master.Write([]byte(text)); err != nil {
+		t.Fatal("Unable to write to terminal:", err)
+	}
+}
+
+func (c *console) echo(t *testing.T) bool {
+	t.Helper()
+	var tios syscall.Termios
+	if err := ioctl(c.slave, syscall.TCGETS, unsafe.Pointer(&tios)); err != nil {
+		t.Fatal("Unable to get terminal mode:", err)
+	}
+	return tios.Lflag&syscall.ECHO != 0
+}
+
+type pinResult struct {
+	pin pinentry.PIN
+	err error
+}
+
+func TestTTY_Terminal(t *testing.T) {
+	c := openConsole(t)
+	tty := &pinentry.TTY{}
+	opts := pinentry.Options{TTYName: c.name, TTYType: "xterm"}
+
+	s := &pinentry.Settings{
+		Desc: "Enter new passphrase", Prompt: "Passphrase:", RepeatPrompt: "Repeat:",
+		Error: "Bad passphrase", Opts: opts,
+	}
+	res := make(chan pinResult, 1)
+	go func() {
+		pin, err := tty.GetPIN(context.Background(), s)
+		res <- pinResult{pin, err}
+	}()
+	c.expect(t, "Enter new passphrase")
+	c.expect(t, "\x1b[1mBad passphrase\x1b[0m")
+	c.expect(t, "Passphrase: ")
+	c.input(t, "secret\n")
+	c.expect(t, "Repeat: ")
+	c.input(t, "secret\n")
+	if r := <-res; r.err != nil || r.pin.Value != "secret" || !r.pin.Repeated {
+		t.Errorf("Unexpected GETPIN result: %+v, %v", r.pin, r.err)
+	}
+	if strings.Contains(c.output(), "secret") {
+		t.Errorf("Passphrase was echoed: %q", c.output())
+	}
+	if !c.echo(t) {
+		t.Error("Echo was not restored")
+	}
+
+	confirm := make(chan pinentry.ConfirmResult, 1)
+	go func() {
+		r, err := tty.Confirm(context.Background(), &pinentry.Settings{Desc: "Do you trust this key?", NotOkBtn: "_No", Opts: opts})
+		if err != nil {
+			t.Error("Unexpected CONFIRM error:", err)
+		}
+		confirm <- r
+	}()
+	c.expect(t, "OK (o), No (n), Cancel (c)? ")
+	c.input(t, "n\n")
+	if r := <-confirm; r != pinentry.ConfirmNotOK {
+		t.Errorf("Expected NOTOK, got %s", r)
+	}
+
+	// Dismissed prompt gives up on terminal and restores echo.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		pin, err := tty.GetPIN(ctx, &pinentry.Settings{Opts: opts})
+		res <- pinResult{pin, err}
+	}()
+	c.expect(t, "PIN: ")
+	cancel()
+	select {
+	case r := <-res:
+		if !errors.Is(r.err, context.Canceled) {
+			t.Error("Expected canceled error, got:", r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Prompt was not dismissed")
+	}
+	if !c.echo(t) {
+		t.Error("Echo was not restored after dismissed prompt")
+	}
+}
+
+func TestTTY_NoTerminal(t *testing.T) {
+	tty := &pinentry.TTY{}
+	s := &pinentry.Settings{Opts: pinentry.Options{TTYName: "/nonexistent/tty"}}
+	if _, err := tty.GetPIN(context.Background(), s); !errors.Is(err, pinentry.ErrUnavailable) {
+		t.Error("Expected unavailable error, got:", err)
+	}
+}
@@ -0,0 +1,121 @@
+package pinentry_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rupor-github/win-gpg-agent/assuan/common"
+	"github.com/rupor-github/win-gpg-agent/pinentry"
+)
+
+func TestTTY(t *testing.T) {
+	ctx := context.Background()
+	var out strings.Builder
+	tty := &pinentry.TTY{
+		In:  strings.NewReader("first\nsecond\nsecret\nsecret\r\nx\nn\n\n"),
+		Out: &out,
+	}
+
+	s := &pinentry.Settings{Desc: "Enter new passphrase", Prompt: "Passphrase:", RepeatPrompt: "Repeat:", RepeatError: "Mismatch"}
+	pin, err := tty.GetPIN(ctx, s)
+	if err != nil || pin.Value != "secret" || !pin.Repeated {
+		t.Errorf("Unexpected GETPIN result: %+v, %v", pin, err)
+	}
+	if text := out.String(); !strings.Contains(text, "Enter new passphrase\nPassphrase: \nRepeat: \nMismatch\n") {
+		t.Errorf("Unexpected GETPIN output: %q", text)
+	}
+
+	out.Reset()
+	res, err := tty.Confirm(ctx, &pinentry.Settings{Desc: "Do you trust this key?", OkBtn: "_Yes", NotOkBtn: "_No"})
+	if err != nil || res != pinentry.ConfirmNotOK {
+		t.Errorf("Unexpected CONFIRM result: %s, %v", res, err)
+	}
+	if text := out.String(); strings.Count(text, "Yes (y), No (n), Cancel (c)? ") != 2 {
+		t.Errorf("Unexpected CONFIRM output: %q", text)
+	}
+
+	// Mnemonics take precedence, other keys are picked from labels.
+	out.Reset()
+	tty.In = strings.NewReader("x\n")
+	res, err = tty.Confirm(ctx, &pinentry.Settings{OkBtn: "Rela_x", NotOkBtn: "No", Opts: pinentry.Options{DefaultCancel: "E_xit"}})
+	if err != nil || res != pinentry.ConfirmOK {
+		t.Errorf("Unexpected CONFIRM result: %s, %v", res, err)
+	}
+	if text := out.String(); !strings.Contains(text, "Relax (x), No (n), Exit (e)? ") {
+		t.Errorf("Unexpected CONFIRM output: %q", text)
+	}
+
+	tty.In = strings.NewReader("\n")
+	if err := tty.Message(ctx, &pinentry.Settings{Desc: "Bad passphrase"}); err != nil {
+		t.Error("Unexpected MESSAGE error:", err)
+	}
+
+	// Rejected passphrase is asked again before repeat.
+	out.Reset()
+	tty.In = strings.NewReader("abc\nabcd\nabcd\n")
+	s.CheckPIN = func(pin string) string {
+		if len(pin) < 4 {
+			return "Too short"
+		}
+		return ""
+	}
+	pin, err = tty.GetPIN(ctx, s)
+	if err != nil || pin.Value != "abcd" || !pin.Checked {
+		t.Errorf("Unexpected GETPIN result: %+v, %v", pin, err)
+	}
+	if text := out.String(); !strings.Contains(text, "Passphrase: \nToo short\nPassphrase: \nRepeat: \n") {
+		t.Errorf("Unexpected GETPIN output: %q", text)
+	}
+
+	// Generated passphrase is offered first.
+	out.Reset()
+	tty.In = strings.NewReader("u\ne\nmine\nmine\n")
+	gen := &pinentry.Settings{
+		Prompt: "Passphrase:", RepeatPrompt: "Repeat:",
+		GenPINLabel: "_Generate", GenPINToolTip: "Suggest a random passphrase",
+		GenPIN: func() (string, error) { return "abc def", nil },
+	}
+	pin, err = tty.GetPIN(ctx, gen)
+	if err != nil || pin.Value != "abc def" || !pin.Repeated {
+		t.Errorf("Unexpected GETPIN result: %+v, %v", pin, err)
+	}
+	if text := out.String(); !strings.Contains(text, "Generate: abc def\nSuggest a random passphrase\nUse generated passphrase (u), Enter passphrase (e)? ") {
+		t.Errorf("Unexpected GETPIN output: %q", text)
+	}
+	pin, err = tty.GetPIN(ctx, gen)
+	if err != nil || pin.Value != "mine" || !pin.Repeated {
+		t.Errorf("Unexpected GETPIN result: %+v, %v", pin, err)
+	}
+
+	// End of input cancels.
+	if _, err := tty.GetPIN(ctx, s); !errors.Is(err, common.ErrCanceled) {
+		t.Error("Expected canceled error, got:", err)
+	}
+}
+
+func TestTTY_CanceledPrompt(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	tty := &pinentry.TTY{In: r}
+	s := &pinentry.Settings{Prompt: "Passphrase:"}
+
+	// Nothing is typed before prompt times out.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := tty.GetPIN(ctx, s); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected deadline error, got:", err)
+	}
+
+	// Input typed afterwards goes to the next prompt.
+	go w.Write([]byte("secret\n"))
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pin, err := tty.GetPIN(ctx, s)
+	if err != nil || pin.Value != "secret" {
+		t.Errorf("Unexpected GETPIN result: %+v, %v", pin, err)
+	}
+}
@@ -3,6 +3,7 @@
 package util
 
 import (
+	"context"
 	"errors"
 	"log"
 	"regexp"
@@ -19,6 +20,7 @@ var (
 	pPromptForWindowsCredentials    = modCredUI.NewProc("CredUIPromptForWindowsCredentialsW")
 	pCredPackAuthenticationBuffer   = modCredUI.NewProc("CredPackAuthenticationBufferW")
 	pCredUnPackAuthenticationBuffer = modCredUI.NewProc("CredUnPackAuthenticationBufferW")
+	pFindWindowEx                   = modUser32.NewProc("FindWindowExW")
 )
 
 type DlgDetails struct {
@@ -96,10 +98,72 @@ const (
 	//                               for the authentication package specified by the pulAuthPackage parameter should be enumerated.
 )
 
+// ownWindows returns top level windows with specified class and name which
+// belong to current process, so windows of other applications with the same
+// title are never touched.
+func ownWindows(class, name *uint16) []win.HWND {
+	var (
+		pid  = windows.GetCurrentProcessId()
+		res  []win.HWND
+		hwnd uintptr
+	)
+	for {
+		hwnd, _, _ = pFindWindowEx.Call(0, hwnd, uintptr(unsafe.Pointer(class)), uintptr(unsafe.Pointer(name)))
+		if hwnd == 0 {
+			return res
+		}
+		var owner uint32
+		win.GetWindowThreadProcessId(win.HWND(hwnd), &owner)
+		if owner == pid {
+			res = append(res, win.HWND(hwnd))
+		}
+	}
+}
+
+// dismissOnDone posts message to the window of current process with specified
+// class and name when ctx is done, so modal dialog waiting for user on current
+// thread returns. Returned function must be called after dialog returns.
+func dismissOnDone(ctx context.Context, class, name string, msg uint32, wParam uintptr) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		log.Printf("Dismissing dialog \"%s\": %s", name, ctx.Err())
+
+		var pClass *uint16
+		if len(class) != 0 {
+			pClass = windows.StringToUTF16Ptr(class)
+		}
+		pName := windows.StringToUTF16Ptr(name)
+
+		// Window may not be shown yet - keep trying until dialog returns.
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			for _, hwnd := range ownWindows(pClass, pName) {
+				win.PostMessage(hwnd, msg, wParam, 0)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
 // PromptForWindowsCredentials calls Windows CredUI.dll to pupup "standard" Windows security dialog using provided description, prompt and a flag,
 // indicating that user could make a choice to save the result in Windows Credential manager. It returns canceled flag (indicating error or user's
 // refusal to complete operation) and when false string with entered password/pin and flag indicating that user checked "Remember me" checkbox.
-func PromptForWindowsCredentials(details DlgDetails, errorMessage, description, prompt string, save bool) (bool, string, bool) {
+// When ctx is done dialog (found using details) is closed and canceled flag is returned.
+func PromptForWindowsCredentials(ctx context.Context, details DlgDetails, errorMessage, description, prompt string, save bool) (bool, string, bool) {
 
 	// NOTE: since pinentry is being started from arbitrary "background" process after long chain of executions timing may vary and often
 	// passphrase dialog would not come into foreground (as it should) - instead meaningless icon will flash on taskbar. To fight it we
@@ -152,6 +216,7 @@ func PromptForWindowsCredentials(details DlgDetails, errorMessage, description,
 		dwFlags += CREDUIWIN_CHECKBOX
 	}
 
+	stop := dismissOnDone(ctx, details.WndClass, details.WndName, win.WM_CLOSE, 0)
 	r1, _, err := pPromptForWindowsCredentials.Call(
 		uintptr(unsafe.Pointer(&uiInfo)),
 		0,                                      // DWORD   dwAuthError,
@@ -163,6 +228,7 @@ func PromptForWindowsCredentials(details DlgDetails, errorMessage, description,
 		uintptr(unsafe.Pointer(&saveFlag)),     // BOOL    *pfSave,
 		uintptr(dwFlags),                       // DWORD   dwFlags
 	)
+	stop()
 
 	// ERROR_CANCELED is the only other option
 	if r1 != 0 {
@@ -207,7 +273,11 @@ func PromptForWindowsCredentials(details DlgDetails, errorMessage, description,
 	return false, res, saveFlag != 0
 }
 
-func PromptForConfirmaion(_ DlgDetails, description, prompt string, onebutton bool) bool {
+// PromptForConfirmaion shows message box with OK button or Yes and No buttons and returns true if user agreed. When ctx is done
+// message box is dismissed (as if No was pressed).
+func PromptForConfirmaion(ctx context.Context, _ DlgDetails, description, prompt string, onebutton bool) bool {
+
+	const messageBoxClass = "#32770"
 
 	caption := "Pinentry (go)"
 
@@ -216,13 +286,18 @@ func PromptForConfirmaion(_ DlgDetails, description, prompt string, onebutton bo
 		description = description + "\n\n" + prompt
 	}
 
-	var flags uint32
+	var (
+		flags   uint32
+		dismiss uintptr
+	)
 	if onebutton {
-		flags = MB_OK + MB_ICONASTERISK + MB_SETFOREGROUND
+		flags, dismiss = MB_OK+MB_ICONASTERISK+MB_SETFOREGROUND, IDOK
 	} else {
-		flags = MB_YESNO + MB_ICONQUESTION + MB_SETFOREGROUND
+		flags, dismiss = MB_YESNO+MB_ICONQUESTION+MB_SETFOREGROUND, IDNO
 	}
 
+	stop := dismissOnDone(ctx, messageBoxClass, caption, win.WM_COMMAND, dismiss)
 	ret := MessageBox(caption, description, uintptr(flags))
+	stop()
 	return ret == IDYES || ret == IDOK
 }